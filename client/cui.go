@@ -66,7 +66,29 @@ func doRecv(g *gocui.Gui) {
 	for msg := range recvChannel {
 		switch msg.Type {
 		case sdk.MsgTypeText:
+			if msg.SentByMe {
+				viewPrint(g, "me", msg.Content, false)
+				continue
+			}
 			viewPrint(g, msg.Name, msg.Content, false)
+			// the message is shown, so it is read
+			chat.MarkRead(msg)
+		case sdk.MsgTypePresence:
+			viewPrint(g, msg.FormUserID, msg.Content, false)
+		case sdk.MsgTypeGroup:
+			viewPrint(g, "group "+msg.GroupID, msg.Content, false)
+		case sdk.MsgTypeDelivered, sdk.MsgTypeRead:
+			viewPrint(g, msg.FormUserID, msg.Content, false)
+		case sdk.MsgTypeRecall:
+			viewPrint(g, msg.FormUserID, fmt.Sprintf("recalled #%d %s", msg.MsgID, msg.Content), false)
+		case sdk.MsgTypeEdit:
+			viewPrint(g, msg.FormUserID, fmt.Sprintf("edited #%d: %s", msg.MsgID, msg.Content), false)
+		case sdk.MsgTypeSignal:
+			text := fmt.Sprintf("%s is %s...", msg.FormUserID, msg.Content)
+			g.Update(func(g *gocui.Gui) error {
+				setHeadText(g, text)
+				return nil
+			})
 		}
 	}
 	g.Close()
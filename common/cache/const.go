@@ -3,8 +3,28 @@ package cache
 import "time"
 
 const (
-	MaxClientIDKey  = "max_client_id_{%d}_%d"
-	LastMsgKey      = "last_msg_{%d}_%d"
-	LoginSlotSetKey = "login_slot_set_{%d}" // though hash tag guarantees that in cluster mode the key is on the same shard
-	TTL7D           = 7 * 24 * time.Hour
-)
\ No newline at end of file
+	MaxClientIDKey     = "max_client_id_{%d}_%d"
+	LastMsgKey         = "last_msg_{%d}_%d"
+	UpMsgDedupKey      = "up_msg_dedup_{%d}_%d"      // hash of the recently accepted clientID -> msgID of the connection
+	ConnKeysKey        = "conn_keys_{%d}_%d"         // set of the keys created for the connection, deleted with the connection
+	LoginSlotSetKey    = "login_slot_set_{%d}"       // though hash tag guarantees that in cluster mode the key is on the same shard
+	PresenceKey        = "presence_{%d}"             // hash of deviceID -> "online|lastActive", keyed by userID
+	PresenceSubKey     = "presence_sub_{%d}"         // set of connIDs subscribed to the userID
+	ConnPresenceSubKey = "conn_presence_sub_{%d}_%d" // set of userIDs subscribed by the connID
+	DeviceAckKey       = "device_ack_{%d}"           // hash of deviceID -> last acked inbox seq, keyed by userID
+	InboxSeqKey        = "inbox_seq_{%d}"            // seq allocator of the inbox, keyed by userID
+	InboxKey           = "inbox_{%d}"                // capped list of the latest direct messages of the user, keyed by userID
+	OfflineMsgKey      = "offline_msg_{%d}"          // list of messages not delivered to the device, keyed by deviceID
+	DeadLetterMsgKey   = "dead_letter_msg"           // capped list of messages dropped after exhausting retransmission
+	GroupIDKey         = "group_id_seq"              // group id allocator
+	GroupMembersKey    = "group_members_{%d}"        // set of userIDs in the group
+	GroupOwnerKey      = "group_owner_{%d}"          // userID of the creator of the group
+	GroupMsgIDKey      = "group_msg_id_{%d}"         // msgID allocator of the group session
+	DirectMsgIDKey     = "direct_msg_id_{%d}_%d"     // msgID allocator of the direct session, keyed by the lower and the higher userID
+	GroupTimelineKey   = "group_msg_{%d}"            // capped list of the latest messages of the group
+	ReadCursorKey      = "read_cursor_{%d}"          // hash of session -> last read msgID, keyed by userID
+	SessionSeqKey      = "session_seq_{%d}"          // hash of direct session -> latest msgID delivered, keyed by userID
+	SentMsgKey         = "sent_msg_{%d}"             // hash of "session_msgID" -> "peerUserID|sentAt", keyed by the sender userID
+	OfflineModifyKey   = "offline_modify_{%d}"       // list of modify events not delivered to the device, keyed by deviceID
+	TTL7D              = 7 * 24 * time.Hour
+)
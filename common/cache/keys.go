@@ -0,0 +1,19 @@
+package cache
+
+import "fmt"
+
+// ConnSlot is the login slot of the connection. The keys of the connection and its login slot set
+// carry the slot as the hash tag, so that they are on the same node in cluster mode.
+func ConnSlot(slotRange []int, connID uint64) int {
+	return slotRange[connID%uint64(len(slotRange))]
+}
+
+// ConnKey formats a key of the connection, such as LastMsgKey or ConnKeysKey
+func ConnKey(format string, slotRange []int, connID uint64) string {
+	return fmt.Sprintf(format, ConnSlot(slotRange, connID), connID)
+}
+
+// LoginSlotKey is the login slot set holding the connection
+func LoginSlotKey(slotRange []int, connID uint64) string {
+	return fmt.Sprintf(LoginSlotSetKey, ConnSlot(slotRange, connID))
+}
@@ -0,0 +1,24 @@
+package cache
+
+import "testing"
+
+func TestConnKey(t *testing.T) {
+	slotRange := []int{5, 6, 7, 8}
+	tests := []struct {
+		connID uint64
+		key    string
+		slot   string
+	}{
+		{0, "conn_keys_{5}_0", "login_slot_set_{5}"},
+		{42, "conn_keys_{7}_42", "login_slot_set_{7}"},
+		{43, "conn_keys_{8}_43", "login_slot_set_{8}"},
+	}
+	for _, tt := range tests {
+		if got := ConnKey(ConnKeysKey, slotRange, tt.connID); got != tt.key {
+			t.Fatalf("connID %d got key %s, want %s", tt.connID, got, tt.key)
+		}
+		if got := LoginSlotKey(slotRange, tt.connID); got != tt.slot {
+			t.Fatalf("connID %d got login slot %s, want %s", tt.connID, got, tt.slot)
+		}
+	}
+}
@@ -3,86 +3,154 @@ package cache
 import (
 	"context"
 	"fmt"
+
+	"github.com/redis/go-redis/v9"
 )
 
+// All the keys touched by a script share one hash tag, so that every script runs on a single node in cluster mode.
+
+// marks an accepted ClientID in the dedup hash until the msgID assigned to it is recorded
+const clientIDPending = "pending"
+
 const (
-	LuaCompareAndIncrClientID = "LuaCompareAndIncrClientID"
+	LuaAcceptClientID = "LuaAcceptClientID"
+
+	LuaReleaseClientID = "LuaReleaseClientID"
 
 	LuaCleanupConnection = "LuaCleanupConnection"
+
+	LuaUpdatePresence = "LuaUpdatePresence"
+
+	LuaAdvanceCursor = "LuaAdvanceCursor"
 )
 
 type luaPart struct {
 	LuaScript string
-	Sha       string
+	script    *redis.Script
 }
 
 var luaScriptTable map[string]*luaPart = map[string]*luaPart{
-	LuaCompareAndIncrClientID: {
-		LuaScript: "if redis.call('exists', KEYS[1]) == 0 then redis.call('set', KEYS[1], 0) end;if redis.call('get', KEYS[1]) == ARGV[1] then redis.call('incr', KEYS[1]);redis.call('expire', KEYS[1], ARGV[2]); return 1 else return -1 end",
+	LuaAcceptClientID: {
+		// This script accepts the next ClientID of a connection and classifies the others.
+		// KEYS[1]: max client id key
+		// KEYS[2]: dedup hash of the recently accepted clientID -> msgID
+		// KEYS[3]: index set of the keys created for the connection
+		// ARGV[1]: clientID
+		// ARGV[2]: ttl seconds
+		// returns {1, 0} when accepted, {2, msgID} for a duplicate, msgID is 0 if it is no longer recorded,
+		// {3, expected clientID} when the clientID is ahead of the expected one,
+		// {4, 0} for a duplicate of an accepted clientID whose msgID is not recorded yet
+		LuaScript: `
+            local max = tonumber(redis.call("GET", KEYS[1]) or "0")
+            local id = tonumber(ARGV[1])
+            if id == max then
+                redis.call("SET", KEYS[1], max + 1, "EX", ARGV[2])
+                redis.call("HSET", KEYS[2], ARGV[1], "` + clientIDPending + `")
+                redis.call("EXPIRE", KEYS[2], ARGV[2])
+                redis.call("SADD", KEYS[3], KEYS[1], KEYS[2])
+                redis.call("EXPIRE", KEYS[3], ARGV[2])
+                return {1, 0}
+            end
+            if id < max then
+                local msg_id = redis.call("HGET", KEYS[2], ARGV[1])
+                if msg_id == "` + clientIDPending + `" then
+                    return {4, 0}
+                end
+                return {2, tonumber(msg_id or "0")}
+            end
+            return {3, max}
+        `,
+	},
+	LuaReleaseClientID: {
+		// This script gives back the latest accepted ClientID of a connection, so that it is accepted again when resent.
+		// KEYS[1]: max client id key
+		// KEYS[2]: dedup hash of the recently accepted clientID -> msgID
+		// ARGV[1]: clientID
+		// ARGV[2]: ttl seconds
+		// returns 1 when released, 0 when a later ClientID was accepted meanwhile
+		LuaScript: `
+            local max = tonumber(redis.call("GET", KEYS[1]) or "0")
+            local id = tonumber(ARGV[1])
+            if max ~= id + 1 then return 0 end
+            redis.call("SET", KEYS[1], id, "EX", ARGV[2])
+            redis.call("HDEL", KEYS[2], ARGV[1])
+            return 1
+        `,
 	},
 	LuaCleanupConnection: {
-		// This script cleans up all distributed states for a connection atomically.
-		// ARGV[1]: connID
-		// ARGV[2]: deviceID
-		// ARGV[3]: slot_size
+		// This script cleans up all distributed states of a connection in its slot atomically.
+		// The router record is keyed by the device in another slot, so it is deleted by the caller.
+		// KEYS[1]: index set of the keys created for the connection
+		// KEYS[2]: login slot set
+		// ARGV[1]: login slot meta
 		LuaScript: `
-            -- Get arguments
-            local conn_id_str = ARGV[1]
-            local conn_id_num = tonumber(conn_id_str)
-            local device_id = ARGV[2]
-            local slot_size = tonumber(ARGV[3])
+            local index_key = KEYS[1]
+            local login_slot_key = KEYS[2]
+            local login_slot_meta = ARGV[1]
 
-            -- TODO: For multi-gateway deployment, a unique gateway_id is required as an argument.
-            -- All keys below should be prefixed with this gateway_id to prevent connID collisions.
-            -- e.g., local router_key = "gateway_rotuer:" .. gateway_id .. ":" .. device_id
-            
             -- 1. Clean up Login Slot
-            local slot = conn_id_num % slot_size
-            local login_slot_key = "login_slot_set_{" .. slot .."}"
-            -- The meta value format is "deviceID|connID" in the current implementation.
-            local login_slot_meta = device_id .. "|" .. conn_id_str
             redis.call("SREM", login_slot_key, login_slot_meta)
 
-            -- 2. Clean up Router information
-            local router_key = "gateway_rotuer_" .. device_id
-            redis.call("DEL", router_key)
-
-            -- 3. Clean up the "last message" for downlink ACK
-            local last_msg_key = "last_msg_{" .. slot .. "}_" .. conn_id_str
-            redis.call("DEL", last_msg_key)
-            
-            -- 4. Clean up uplink idempotency keys (max_client_id)
-            -- This is the most complex part without sessionIDs.
-            -- The current implementation uses a wildcard key.
-            -- We will use SCAN to find and delete all related keys. This can be slow.
-            -- TODO: A better approach is to have the application pass all associated sessionIDs
-            -- to this script for precise deletion.
-            local pattern = "max_client_id_{" .. slot .. "}_" .. conn_id_str .. "_*"
-            local cursor = "0"
-            repeat
-                local result = redis.call("SCAN", cursor, "MATCH", pattern, "COUNT", 100)
-                cursor = result[1]
-                local keys = result[2]
-                if #keys > 0 then
-                    redis.call("DEL", unpack(keys))
-                end
-            until cursor == "0"
+            -- 2. Delete exactly the keys indexed for the connection: the last msg, the uplink
+            -- idempotency keys and the presence subscriptions. No keyspace walk is needed.
+            local keys = redis.call("SMEMBERS", index_key)
+            for i = 1, #keys, 100 do
+                redis.call("DEL", unpack(keys, i, math.min(i + 99, #keys)))
+            end
+            redis.call("DEL", index_key)
 
             return 1
         `,
 	},
+	LuaUpdatePresence: {
+		// This script updates the presence of a device and reports whether its online status changed.
+		// KEYS[1]: presence hash key of the user
+		// ARGV[1]: deviceID
+		// ARGV[2]: online flag, 1 or 0
+		// ARGV[3]: last active unix milliseconds
+		// ARGV[4]: ttl seconds
+		LuaScript: `
+            local old = redis.call("HGET", KEYS[1], ARGV[1])
+            redis.call("HSET", KEYS[1], ARGV[1], ARGV[2] .. "|" .. ARGV[3])
+            redis.call("EXPIRE", KEYS[1], ARGV[4])
+            if not old then
+                if ARGV[2] == "1" then return 1 end
+                return 0
+            end
+            if string.sub(old, 1, 1) == ARGV[2] then return 0 end
+            return 1
+        `,
+	},
+	LuaAdvanceCursor: {
+		// This script moves a cursor stored in a hash field forward only.
+		// KEYS[1]: cursor hash key
+		// ARGV[1]: field
+		// ARGV[2]: new cursor
+		// ARGV[3]: ttl seconds
+		LuaScript: `
+            local old = tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "0")
+            if tonumber(ARGV[2]) <= old then return 0 end
+            redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+            redis.call("EXPIRE", KEYS[1], ARGV[3])
+            return 1
+        `,
+	},
 }
 
-// init lua script
+// init lua script, in cluster mode the scripts are loaded on every master
 func initLuaScript(ctx context.Context) {
 	for name, part := range luaScriptTable {
-		cmd := rdb.ScriptLoad(ctx, part.LuaScript)
-		if cmd == nil {
-			panic(fmt.Sprintf("lua init failed lua=%s", name))
+		part.script = redis.NewScript(part.LuaScript)
+		var err error
+		if cluster, ok := rdb.(*redis.ClusterClient); ok {
+			err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+				return part.script.Load(ctx, node).Err()
+			})
+		} else {
+			err = part.script.Load(ctx, rdb).Err()
 		}
-		if cmd.Err() != nil {
-			panic(cmd.Err())
+		if err != nil {
+			panic(fmt.Sprintf("lua init failed lua=%s err=%s", name, err.Error()))
 		}
-		part.Sha = cmd.Val()
 	}
-}
\ No newline at end of file
+}
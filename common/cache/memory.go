@@ -0,0 +1,564 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errWrongType     = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNoSuchKey     = errors.New("ERR no such key")
+	errIndexOutRange = errors.New("ERR index out of range")
+)
+
+// memStore implements Store in memory with the same semantics as redis, including the ttl.
+// It is used to test the state server without a redis.
+type memStore struct {
+	sync.Mutex
+	entries map[string]*memEntry
+	now     func() time.Time
+}
+
+// the value is a string, a set, a hash or a list
+type memEntry struct {
+	value    interface{}
+	expireAt time.Time // zero means no expiration
+}
+
+var _ Store = (*memStore)(nil)
+
+func NewMemoryStore() Store {
+	return &memStore{entries: make(map[string]*memEntry), now: time.Now}
+}
+
+// the entry of the key, expired entries are evicted lazily
+func (s *memStore) get(key string) *memEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !s.now().Before(e.expireAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (s *memStore) expire(key string, ttl time.Duration) {
+	e := s.get(key)
+	if e == nil {
+		return
+	}
+	// like redis, a non positive ttl deletes the key
+	if ttl <= 0 {
+		delete(s.entries, key)
+		return
+	}
+	e.expireAt = s.now().Add(ttl)
+}
+
+func (s *memStore) setString(key, value string, ttl time.Duration) {
+	e := &memEntry{value: value}
+	if ttl > 0 {
+		e.expireAt = s.now().Add(ttl)
+	}
+	s.entries[key] = e
+}
+
+func (s *memStore) getString(key string) (string, bool, error) {
+	e := s.get(key)
+	if e == nil {
+		return "", false, nil
+	}
+	v, ok := e.value.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return v, true, nil
+}
+
+func (s *memStore) set(key string, create bool) (map[string]struct{}, error) {
+	e := s.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		e = &memEntry{value: make(map[string]struct{})}
+		s.entries[key] = e
+	}
+	v, ok := e.value.(map[string]struct{})
+	if !ok {
+		return nil, errWrongType
+	}
+	return v, nil
+}
+
+func (s *memStore) hash(key string, create bool) (map[string]string, error) {
+	e := s.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		e = &memEntry{value: make(map[string]string)}
+		s.entries[key] = e
+	}
+	v, ok := e.value.(map[string]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return v, nil
+}
+
+func (s *memStore) list(key string) ([]string, error) {
+	e := s.get(key)
+	if e == nil {
+		return nil, nil
+	}
+	v, ok := e.value.([]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return v, nil
+}
+
+// store the list, an empty list is deleted like in redis
+func (s *memStore) setList(key string, list []string) {
+	if len(list) == 0 {
+		delete(s.entries, key)
+		return
+	}
+	if e := s.get(key); e != nil {
+		e.value = list
+		return
+	}
+	s.entries[key] = &memEntry{value: list}
+}
+
+func (s *memStore) sadd(key string, members ...string) error {
+	set, err := s.set(key, true)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return nil
+}
+
+func (s *memStore) hset(key, field, value string) error {
+	hash, err := s.hash(key, true)
+	if err != nil {
+		return err
+	}
+	hash[field] = value
+	return nil
+}
+
+func (s *memStore) hget(key, field string) (string, bool, error) {
+	hash, err := s.hash(key, false)
+	if err != nil {
+		return "", false, err
+	}
+	v, ok := hash[field]
+	return v, ok, nil
+}
+
+// format the argument the way the redis client does
+func memArg(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (s *memStore) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok, err := s.getString(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+func (s *memStore) SetBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	s.setString(key, string(value), ttl)
+	return nil
+}
+
+func (s *memStore) SetBytesIndexed(ctx context.Context, key string, value []byte, ttl time.Duration, indexKey string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.setString(key, string(value), ttl)
+	if err := s.sadd(indexKey, key); err != nil {
+		return err
+	}
+	s.expire(indexKey, ttl)
+	return nil
+}
+
+func (s *memStore) GetString(ctx context.Context, key string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok, err := s.getString(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", Nil
+	}
+	return v, nil
+}
+
+func (s *memStore) SetString(ctx context.Context, key string, value string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	s.setString(key, value, ttl)
+	return nil
+}
+
+func (s *memStore) IncrUint64(ctx context.Context, key string) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok, err := s.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	if ok {
+		if n, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, errors.New("ERR value is not an integer or out of range")
+		}
+	}
+	n++
+	// the ttl of the key is kept
+	if e := s.get(key); e != nil {
+		e.value = strconv.FormatUint(n, 10)
+	} else {
+		s.setString(key, strconv.FormatUint(n, 10), 0)
+	}
+	return n, nil
+}
+
+func (s *memStore) Del(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	s.expire(key, ttl)
+	return nil
+}
+
+func (s *memStore) SADD(ctx context.Context, key string, member interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.sadd(key, memArg(member))
+}
+
+func (s *memStore) SREM(ctx context.Context, key string, members ...interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	set, err := s.set(key, false)
+	if err != nil || set == nil {
+		return err
+	}
+	for _, member := range members {
+		delete(set, memArg(member))
+	}
+	if len(set) == 0 {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *memStore) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	set, err := s.set(key, false)
+	if err != nil {
+		return false, err
+	}
+	_, ok := set[memArg(member)]
+	return ok, nil
+}
+
+func (s *memStore) SmembersStrSlice(ctx context.Context, key string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	set, err := s.set(key, false)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (s *memStore) SAddIndex(ctx context.Context, indexKey string, ttl time.Duration, keys ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.sadd(indexKey, keys...); err != nil {
+		return err
+	}
+	s.expire(indexKey, ttl)
+	return nil
+}
+
+func (s *memStore) HSet(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.hset(key, field, memArg(value)); err != nil {
+		return err
+	}
+	s.expire(key, ttl)
+	return nil
+}
+
+func (s *memStore) HGetString(ctx context.Context, key, field string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, _, err := s.hget(key, field)
+	return v, err
+}
+
+func (s *memStore) HGetAllStrMap(ctx context.Context, key string) (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	hash, err := s.hash(key, false)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(hash))
+	for field, v := range hash {
+		res[field] = v
+	}
+	return res, nil
+}
+
+func (s *memStore) HDel(ctx context.Context, key string, fields ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	hash, err := s.hash(key, false)
+	if err != nil || hash == nil {
+		return err
+	}
+	for _, field := range fields {
+		delete(hash, field)
+	}
+	if len(hash) == 0 {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *memStore) RPushBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.RPushBytesCapped(ctx, key, value, 0, ttl)
+}
+
+// a maxLen of 0 does not cap the list
+func (s *memStore) RPushBytesCapped(ctx context.Context, key string, value []byte, maxLen int64, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	list, err := s.list(key)
+	if err != nil {
+		return err
+	}
+	list = append(list, string(value))
+	if maxLen > 0 && int64(len(list)) > maxLen {
+		list = append([]string(nil), list[int64(len(list))-maxLen:]...)
+	}
+	s.setList(key, list)
+	if ttl > 0 {
+		s.expire(key, ttl)
+	}
+	return nil
+}
+
+func (s *memStore) LRangeBytes(ctx context.Context, key string) ([][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	list, err := s.list(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, 0, len(list))
+	for _, v := range list {
+		res = append(res, []byte(v))
+	}
+	return res, nil
+}
+
+func (s *memStore) LSetBytes(ctx context.Context, key string, index int64, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	list, err := s.list(key)
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		return errNoSuchKey
+	}
+	if index < 0 {
+		index += int64(len(list))
+	}
+	if index < 0 || index >= int64(len(list)) {
+		return errIndexOutRange
+	}
+	list[index] = string(value)
+	return nil
+}
+
+func (s *memStore) LRemBytes(ctx context.Context, key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	list, err := s.list(key)
+	if err != nil || list == nil {
+		return err
+	}
+	kept := list[:0]
+	for _, v := range list {
+		if v != string(value) {
+			kept = append(kept, v)
+		}
+	}
+	s.setList(key, kept)
+	return nil
+}
+
+func (s *memStore) AcceptClientID(ctx context.Context, maxKey, dedupKey, indexKey string, clientID uint64, ttl time.Duration) (int64, uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, _, err := s.getString(maxKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, _ := strconv.ParseUint(v, 10, 64)
+	if clientID == max {
+		s.setString(maxKey, strconv.FormatUint(max+1, 10), ttl)
+		if err = s.hset(dedupKey, strconv.FormatUint(clientID, 10), clientIDPending); err != nil {
+			return 0, 0, err
+		}
+		s.expire(dedupKey, ttl)
+		if err = s.sadd(indexKey, maxKey, dedupKey); err != nil {
+			return 0, 0, err
+		}
+		s.expire(indexKey, ttl)
+		return 1, 0, nil
+	}
+	if clientID < max {
+		v, _, err = s.hget(dedupKey, strconv.FormatUint(clientID, 10))
+		if err != nil {
+			return 0, 0, err
+		}
+		if v == clientIDPending {
+			return 4, 0, nil
+		}
+		msgID, _ := strconv.ParseUint(v, 10, 64)
+		return 2, msgID, nil
+	}
+	return 3, max, nil
+}
+
+func (s *memStore) ReleaseClientID(ctx context.Context, maxKey, dedupKey string, clientID uint64, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, _, err := s.getString(maxKey)
+	if err != nil {
+		return false, err
+	}
+	if max, _ := strconv.ParseUint(v, 10, 64); max != clientID+1 {
+		return false, nil
+	}
+	s.setString(maxKey, strconv.FormatUint(clientID, 10), ttl)
+	if hash, err := s.hash(dedupKey, false); err != nil {
+		return false, err
+	} else if hash != nil {
+		delete(hash, strconv.FormatUint(clientID, 10))
+		if len(hash) == 0 {
+			delete(s.entries, dedupKey)
+		}
+	}
+	return true, nil
+}
+
+func (s *memStore) CleanupConnection(ctx context.Context, indexKey, loginSlotKey, loginSlotMeta string) error {
+	s.Lock()
+	defer s.Unlock()
+	if set, err := s.set(loginSlotKey, false); err != nil {
+		return err
+	} else if set != nil {
+		delete(set, loginSlotMeta)
+		if len(set) == 0 {
+			delete(s.entries, loginSlotKey)
+		}
+	}
+	keys, err := s.set(indexKey, false)
+	if err != nil {
+		return err
+	}
+	for key := range keys {
+		delete(s.entries, key)
+	}
+	delete(s.entries, indexKey)
+	return nil
+}
+
+func (s *memStore) UpdatePresence(ctx context.Context, key string, did uint64, online bool, lastActive int64, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	field := strconv.FormatUint(did, 10)
+	flag := memArg(online)
+	old, ok, err := s.hget(key, field)
+	if err != nil {
+		return false, err
+	}
+	if err = s.hset(key, field, flag+"|"+strconv.FormatInt(lastActive, 10)); err != nil {
+		return false, err
+	}
+	s.expire(key, ttl)
+	if !ok {
+		return online, nil
+	}
+	return !strings.HasPrefix(old, flag), nil
+}
+
+func (s *memStore) AdvanceCursor(ctx context.Context, key, field string, cursor uint64, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, _, err := s.hget(key, field)
+	if err != nil {
+		return false, err
+	}
+	old, _ := strconv.ParseUint(v, 10, 64)
+	if cursor <= old {
+		return false, nil
+	}
+	if err = s.hset(key, field, strconv.FormatUint(cursor, 10)); err != nil {
+		return false, err
+	}
+	s.expire(key, ttl)
+	return true, nil
+}
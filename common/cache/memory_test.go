@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// a memory store with a clock moved by the test
+func newTestMemStore() (*memStore, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore().(*memStore)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestMemStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestMemStore()
+	s.SetString(ctx, "k", "v", time.Second)
+	s.SetString(ctx, "forever", "v", 0)
+	s.HSet(ctx, "h", "f", 1, 2*time.Second)
+
+	*now = now.Add(time.Second)
+	if _, err := s.GetString(ctx, "k"); err != Nil {
+		t.Fatalf("expired key got err %v, want Nil", err)
+	}
+	if v, _ := s.GetString(ctx, "forever"); v != "v" {
+		t.Fatalf("key without ttl got %q", v)
+	}
+	if v, _ := s.HGetString(ctx, "h", "f"); v != "1" {
+		t.Fatalf("hash field got %q before expiration", v)
+	}
+	// incr keeps the ttl of the key
+	s.SetString(ctx, "n", "1", time.Second)
+	if n, _ := s.IncrUint64(ctx, "n"); n != 2 {
+		t.Fatalf("incr got %d", n)
+	}
+
+	*now = now.Add(time.Second)
+	if m, _ := s.HGetAllStrMap(ctx, "h"); len(m) != 0 {
+		t.Fatalf("expired hash got %v", m)
+	}
+	if n, _ := s.IncrUint64(ctx, "n"); n != 1 {
+		t.Fatalf("incr of an expired key got %d", n)
+	}
+	// a non positive ttl deletes the key like redis does
+	s.Expire(ctx, "forever", 0)
+	if _, err := s.GetString(ctx, "forever"); err != Nil {
+		t.Fatalf("key expired with 0 got err %v", err)
+	}
+}
+
+func TestMemStoreCollections(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestMemStore()
+	for _, v := range []string{"a", "b", "c", "b"} {
+		s.RPushBytesCapped(ctx, "l", []byte(v), 3, 0)
+	}
+	if err := s.LSetBytes(ctx, "l", -1, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LSetBytes(ctx, "l", 3, []byte("x")); err != errIndexOutRange {
+		t.Fatalf("LSetBytes out of range got err %v", err)
+	}
+	s.LRemBytes(ctx, "l", []byte("b"))
+	l, _ := s.LRangeBytes(ctx, "l")
+	if len(l) != 2 || string(l[0]) != "c" || string(l[1]) != "x" {
+		t.Fatalf("list got %q", l)
+	}
+
+	s.SADD(ctx, "s", uint64(7))
+	if ok, _ := s.SIsMember(ctx, "s", 7); !ok {
+		t.Fatal("member not found")
+	}
+	// emptied collections are deleted
+	s.SREM(ctx, "s", uint64(7))
+	if _, ok := s.entries["s"]; ok {
+		t.Fatal("empty set not deleted")
+	}
+	if err := s.SADD(ctx, "l", 1); err != errWrongType {
+		t.Fatalf("SADD on a list got err %v", err)
+	}
+}
+
+func TestMemStoreAcceptClientID(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestMemStore()
+	tests := []struct {
+		name     string
+		clientID uint64
+		code     int64
+		val      uint64
+		record   string // the dedup value of the clientID set after the step
+	}{
+		{"first", 0, 1, 0, ""},
+		{"in progress", 0, 4, 0, "100"},
+		{"next", 1, 1, 0, ""},
+		{"duplicate recorded", 0, 2, 100, ""},
+		{"ahead", 5, 3, 2, ""},
+		{"expected", 2, 1, 0, ""},
+	}
+	for _, tt := range tests {
+		code, val, err := s.AcceptClientID(ctx, "max", "dedup", "index", tt.clientID, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code || val != tt.val {
+			t.Fatalf("%s: got {%d, %d}, want {%d, %d}", tt.name, code, val, tt.code, tt.val)
+		}
+		if tt.record != "" {
+			s.HSet(ctx, "dedup", strconv.FormatUint(tt.clientID, 10), tt.record, time.Minute)
+		}
+	}
+	// the msgID of a clientID out of the dedup window is no longer known
+	s.HDel(ctx, "dedup", "0")
+	if code, val, _ := s.AcceptClientID(ctx, "max", "dedup", "index", 0, time.Minute); code != 2 || val != 0 {
+		t.Fatalf("duplicate forgotten: got {%d, %d}, want {2, 0}", code, val)
+	}
+
+	// only the latest accepted ClientID can be given back
+	for _, release := range []struct {
+		clientID uint64
+		released bool
+	}{{1, false}, {2, true}, {2, false}} {
+		released, err := s.ReleaseClientID(ctx, "max", "dedup", release.clientID, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released != release.released {
+			t.Fatalf("release %d got %v, want %v", release.clientID, released, release.released)
+		}
+	}
+	if code, _, _ := s.AcceptClientID(ctx, "max", "dedup", "index", 2, time.Minute); code != 1 {
+		t.Fatalf("released clientID got code %d, want accepted", code)
+	}
+
+	s.SADD(ctx, "slot", "meta")
+	s.SADD(ctx, "slot", "other")
+	if err := s.CleanupConnection(ctx, "index", "slot", "meta"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"max", "dedup", "index"} {
+		if _, ok := s.entries[key]; ok {
+			t.Fatalf("key %s left after cleanup", key)
+		}
+	}
+	if members, _ := s.SmembersStrSlice(ctx, "slot"); len(members) != 1 || members[0] != "other" {
+		t.Fatalf("login slot got %v", members)
+	}
+}
+
+func TestMemStorePresenceAndCursor(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestMemStore()
+	steps := []struct {
+		online  bool
+		changed bool
+	}{
+		{false, false}, // an unknown device going offline is not a change
+		{true, true},
+		{true, false},
+		{false, true},
+	}
+	for i, step := range steps {
+		changed, err := s.UpdatePresence(ctx, "p", 1, step.online, int64(i), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != step.changed {
+			t.Fatalf("step %d: changed %v, want %v", i, changed, step.changed)
+		}
+	}
+	if v, _ := s.HGetString(ctx, "p", "1"); v != "0|3" {
+		t.Fatalf("presence got %q", v)
+	}
+
+	for _, c := range []struct {
+		cursor   uint64
+		advanced bool
+	}{{5, true}, {5, false}, {3, false}, {6, true}} {
+		if advanced, _ := s.AdvanceCursor(ctx, "c", "s", c.cursor, time.Minute); advanced != c.advanced {
+			t.Fatalf("cursor %d: advanced %v, want %v", c.cursor, advanced, c.advanced)
+		}
+	}
+}
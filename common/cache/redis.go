@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,23 +11,67 @@ import (
 	"github.com/feichai0017/GoChat/common/config"
 )
 
-// Declare rdb variable，this is a singleton client
-var rdb *redis.Client
+// Declare rdb variable，this is a singleton client.
+// It is a standalone, cluster or sentinel client depending on cache.redis.mode
+var rdb redis.UniversalClient
 
-func InitRedis(ctx context.Context) {
-	if rdb != nil {
-		return
+// redisStore implements Store on the redis client
+type redisStore struct {
+	rdb redis.UniversalClient
+}
+
+var _ Store = (*redisStore)(nil)
+
+// InitRedis connects the singleton client and returns the store on it
+func InitRedis(ctx context.Context) Store {
+	if rdb == nil {
+		rdb = newRedisClient()
+		if _, err := rdb.Ping(ctx).Result(); err != nil {
+			panic(err)
+		}
+		initLuaScript(ctx)
 	}
+	return &redisStore{rdb: rdb}
+}
+
+func newRedisClient() redis.UniversalClient {
 	endpoints := config.GetCacheRedisEndpointList()
-	opt := &redis.Options{Addr: endpoints[0], PoolSize: 10000}
-	rdb = redis.NewClient(opt)
-	if _, err := rdb.Ping(ctx).Result(); err != nil {
-		panic(err)
+	switch config.GetCacheRedisMode() {
+	case config.RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    endpoints,
+			Password: config.GetCacheRedisPassword(),
+			PoolSize: config.GetCacheRedisPoolSize(),
+		})
+	case config.RedisModeSentinel:
+		// the endpoints are the sentinels, they resolve the current master
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    config.GetCacheRedisMasterName(),
+			SentinelAddrs: endpoints,
+			Password:      config.GetCacheRedisPassword(),
+			PoolSize:      config.GetCacheRedisPoolSize(),
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:     endpoints[0],
+			Password: config.GetCacheRedisPassword(),
+			PoolSize: config.GetCacheRedisPoolSize(),
+		})
+	}
+}
+
+// CloseRedis closes the client, InitRedis can be called again afterwards
+func CloseRedis() error {
+	if rdb == nil {
+		return nil
 	}
-	initLuaScript(ctx)
+	err := rdb.Close()
+	rdb = nil
+	return err
 }
-func GetBytes(ctx context.Context, key string) ([]byte, error) {
-	cmd := rdb.Conn().Get(ctx, key)
+
+func (s *redisStore) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	cmd := s.rdb.Get(ctx, key)
 	if cmd == nil {
 		return nil, errors.New("redis GetBytes cmd is nil")
 	}
@@ -39,108 +82,272 @@ func GetBytes(ctx context.Context, key string) ([]byte, error) {
 	return data, err
 }
 
-func GetUInt64(ctx context.Context, key string) (uint64, error) {
-	cmd := rdb.Conn().Get(ctx, key)
+func (s *redisStore) SetBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	cmd := s.rdb.Set(ctx, key, value, ttl)
 	if cmd == nil {
-		return 0, errors.New("redis GetUInt64 cmd is nil")
+		return errors.New("redis SetBytes cmd is nil")
 	}
-	return cmd.Uint64()
+	return cmd.Err()
 }
 
-func SetBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error {
-	cmd := rdb.Set(ctx, key, value, ttl)
-	if cmd == nil {
-		return errors.New("redis SetBytes cmd is nil")
+func (s *redisStore) SetBytesIndexed(ctx context.Context, key string, value []byte, ttl time.Duration, indexKey string) error {
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, key, value, ttl)
+		p.SAdd(ctx, indexKey, key)
+		p.Expire(ctx, indexKey, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) SAddIndex(ctx context.Context, indexKey string, ttl time.Duration, keys ...string) error {
+	members := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		members = append(members, key)
 	}
-	return cmd.Err()
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.SAdd(ctx, indexKey, members...)
+		p.Expire(ctx, indexKey, ttl)
+		return nil
+	})
+	return err
 }
 
-func Del(ctx context.Context, key string) error {
-	cmd := rdb.Conn().Del(ctx, key)
+func (s *redisStore) Del(ctx context.Context, key string) error {
+	cmd := s.rdb.Del(ctx, key)
 	if cmd == nil {
 		return errors.New("redis Del cmd is nil")
 	}
 	return cmd.Err()
 }
 
-func SADD(ctx context.Context, key string, member interface{}) error {
-	cmd := rdb.SAdd(ctx, key, member)
+func (s *redisStore) SADD(ctx context.Context, key string, member interface{}) error {
+	cmd := s.rdb.SAdd(ctx, key, member)
 	if cmd == nil {
 		return errors.New("redis SADD cmd is nil")
 	}
 	return cmd.Err()
 }
 
-func SREM(ctx context.Context, key string, members ...interface{}) error {
-	cmd := rdb.Conn().SRem(ctx, key, members...)
+func (s *redisStore) SREM(ctx context.Context, key string, members ...interface{}) error {
+	cmd := s.rdb.SRem(ctx, key, members...)
 	if cmd == nil {
 		return errors.New("redis SREM cmd is nil")
 	}
 	return cmd.Err()
 }
 
-func SmembersStrSlice(ctx context.Context, key string) ([]string, error) {
-	cmd := rdb.Conn().SMembers(ctx, key)
+func (s *redisStore) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	cmd := s.rdb.SIsMember(ctx, key, member)
+	if cmd == nil {
+		return false, errors.New("redis SIsMember cmd is nil")
+	}
+	return cmd.Result()
+}
+
+func (s *redisStore) SmembersStrSlice(ctx context.Context, key string) ([]string, error) {
+	cmd := s.rdb.SMembers(ctx, key)
 	if cmd == nil {
 		return nil, errors.New("redis SmembersUint64StructMap cmd is nil")
 	}
 	return cmd.Result()
 }
 
-func Incr(ctx context.Context, key string, ttl time.Duration) error {
-	_, err := rdb.Conn().Pipelined(ctx, func(p redis.Pipeliner) error {
-		p.Incr(ctx, key)
+func (s *redisStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	cmd := s.rdb.Expire(ctx, key, ttl)
+	if cmd == nil {
+		return errors.New("redis Expire cmd is nil")
+	}
+	return cmd.Err()
+}
+
+func (s *redisStore) HSet(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error {
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, field, value)
 		p.Expire(ctx, key, ttl)
 		return nil
 	})
 	return err
 }
 
-func SetString(ctx context.Context, key string, value string, ttl time.Duration) error {
-	cmd := rdb.Set(ctx, key, value, ttl)
+func (s *redisStore) RPushBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.RPush(ctx, key, value)
+		if ttl > 0 {
+			p.Expire(ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) RPushBytesCapped(ctx context.Context, key string, value []byte, maxLen int64, ttl time.Duration) error {
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.RPush(ctx, key, value)
+		p.LTrim(ctx, key, -maxLen, -1)
+		if ttl > 0 {
+			p.Expire(ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) LRangeBytes(ctx context.Context, key string) ([][]byte, error) {
+	cmd := s.rdb.LRange(ctx, key, 0, -1)
+	if cmd == nil {
+		return nil, errors.New("redis LRangeBytes cmd is nil")
+	}
+	vals, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, 0, len(vals))
+	for _, val := range vals {
+		res = append(res, []byte(val))
+	}
+	return res, nil
+}
+
+func (s *redisStore) LSetBytes(ctx context.Context, key string, index int64, value []byte) error {
+	cmd := s.rdb.LSet(ctx, key, index, value)
+	if cmd == nil {
+		return errors.New("redis LSetBytes cmd is nil")
+	}
+	return cmd.Err()
+}
+
+func (s *redisStore) LRemBytes(ctx context.Context, key string, value []byte) error {
+	cmd := s.rdb.LRem(ctx, key, 0, value)
+	if cmd == nil {
+		return errors.New("redis LRemBytes cmd is nil")
+	}
+	return cmd.Err()
+}
+
+func (s *redisStore) HDel(ctx context.Context, key string, fields ...string) error {
+	cmd := s.rdb.HDel(ctx, key, fields...)
+	if cmd == nil {
+		return errors.New("redis HDel cmd is nil")
+	}
+	return cmd.Err()
+}
+
+func (s *redisStore) HGetString(ctx context.Context, key, field string) (string, error) {
+	cmd := s.rdb.HGet(ctx, key, field)
+	if cmd == nil {
+		return "", errors.New("redis HGetString cmd is nil")
+	}
+	res, err := cmd.Result()
+	if redis.Nil == err {
+		return "", nil
+	}
+	return res, err
+}
+
+func (s *redisStore) HGetAllStrMap(ctx context.Context, key string) (map[string]string, error) {
+	cmd := s.rdb.HGetAll(ctx, key)
+	if cmd == nil {
+		return nil, errors.New("redis HGetAllStrMap cmd is nil")
+	}
+	return cmd.Result()
+}
+
+func (s *redisStore) IncrUint64(ctx context.Context, key string) (uint64, error) {
+	cmd := s.rdb.Incr(ctx, key)
+	if cmd == nil {
+		return 0, errors.New("redis IncrUint64 cmd is nil")
+	}
+	res, err := cmd.Result()
+	return uint64(res), err
+}
+
+func (s *redisStore) SetString(ctx context.Context, key string, value string, ttl time.Duration) error {
+	cmd := s.rdb.Set(ctx, key, value, ttl)
 	if cmd == nil {
 		return errors.New("redis SetString cmd is nil")
 	}
 	return cmd.Err()
 }
 
-func GetString(ctx context.Context, key string) (string, error) {
-	cmd := rdb.Get(ctx, key)
+func (s *redisStore) GetString(ctx context.Context, key string) (string, error) {
+	cmd := s.rdb.Get(ctx, key)
 	if cmd == nil {
 		return "", errors.New("redis GetString cmd is nil")
 	}
 	return cmd.String(), cmd.Err()
 }
 
-func RunLuaInt(ctx context.Context, name string, keys []string, args ...any) (int, error) {
-	if part, ok := luaScriptTable[name]; !ok {
-		return -1, errors.New("lua not registered")
-	} else {
-		cmd := rdb.EvalSha(ctx, part.Sha, keys, args...)
-		if cmd == nil {
-			return -1, errors.New("redis RunLua cmd is nil")
-		}
+func (s *redisStore) runLuaInt(ctx context.Context, name string, keys []string, args ...any) (int, error) {
+	cmd, err := s.runLua(ctx, name, keys, args...)
+	if err != nil {
+		return -1, err
+	}
+	return cmd.Int()
+}
 
-		return cmd.Int()
+func (s *redisStore) runLuaInt64Slice(ctx context.Context, name string, keys []string, args ...any) ([]int64, error) {
+	cmd, err := s.runLua(ctx, name, keys, args...)
+	if err != nil {
+		return nil, err
 	}
+	return cmd.Int64Slice()
 }
 
-// RunLua executes a pre-registered Lua script.
-// It handles NOSCRIPT errors by automatically reloading the script once.
-func RunLua(ctx context.Context, scriptName string, keys []string, args ...any) (*redis.Cmd, error) {
-	if part, ok := luaScriptTable[scriptName]; !ok {
+// runLua executes a pre-registered Lua script on the node owning the keys.
+// It falls back to EVAL on NOSCRIPT, which loads the script on that node for the next time.
+func (s *redisStore) runLua(ctx context.Context, scriptName string, keys []string, args ...any) (*redis.Cmd, error) {
+	part, ok := luaScriptTable[scriptName]
+	if !ok {
 		return nil, fmt.Errorf("lua script not registered: %s", scriptName)
-	} else {
-		cmd := rdb.EvalSha(ctx, part.Sha, keys, args...)
-		if cmd.Err() != nil && strings.HasPrefix(cmd.Err().Error(), "NOSCRIPT") {
-			// If the script is not loaded in Redis, load it and retry.
-			newSha, err := rdb.ScriptLoad(ctx, part.LuaScript).Result()
-			if err != nil {
-				return nil, fmt.Errorf("failed to load lua script %s: %w", scriptName, err)
-			}
-			part.Sha = newSha // Update the SHA in memory for next time
-			cmd = rdb.EvalSha(ctx, part.Sha, keys, args...)
-		}
-		return cmd, cmd.Err()
 	}
-}
\ No newline at end of file
+	cmd := part.script.Run(ctx, rdb, keys, args...)
+	return cmd, cmd.Err()
+}
+
+func (s *redisStore) AcceptClientID(ctx context.Context, maxKey, dedupKey, indexKey string, clientID uint64, ttl time.Duration) (int64, uint64, error) {
+	res, err := s.runLuaInt64Slice(ctx, LuaAcceptClientID, []string{maxKey, dedupKey, indexKey}, clientID, int64(ttl/time.Second))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) < 2 {
+		return 0, 0, errors.New("unexpected LuaAcceptClientID result")
+	}
+	return res[0], uint64(res[1]), nil
+}
+
+func (s *redisStore) ReleaseClientID(ctx context.Context, maxKey, dedupKey string, clientID uint64, ttl time.Duration) (bool, error) {
+	res, err := s.runLuaInt(ctx, LuaReleaseClientID, []string{maxKey, dedupKey}, clientID, int64(ttl/time.Second))
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func (s *redisStore) CleanupConnection(ctx context.Context, indexKey, loginSlotKey, loginSlotMeta string) error {
+	_, err := s.runLua(ctx, LuaCleanupConnection, []string{indexKey, loginSlotKey}, loginSlotMeta)
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+func (s *redisStore) UpdatePresence(ctx context.Context, key string, did uint64, online bool, lastActive int64, ttl time.Duration) (bool, error) {
+	flag := 0
+	if online {
+		flag = 1
+	}
+	res, err := s.runLuaInt(ctx, LuaUpdatePresence, []string{key}, did, flag, lastActive, int64(ttl/time.Second))
+	if err != nil {
+		return false, err
+	}
+	return res > 0, nil
+}
+
+func (s *redisStore) AdvanceCursor(ctx context.Context, key, field string, cursor uint64, ttl time.Duration) (bool, error) {
+	res, err := s.runLuaInt(ctx, LuaAdvanceCursor, []string{key}, field, cursor, int64(ttl/time.Second))
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
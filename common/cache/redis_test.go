@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+
+	"github.com/feichai0017/GoChat/common/config"
+)
+
+// The tests spawn local redis-server processes, they are skipped when redis-server is not installed.
+
+func TestRedisStandalone(t *testing.T) {
+	addr := startRedisServer(t)
+	setRedisConfig(config.RedisModeStandalone, addr)
+	exerciseRedis(t)
+}
+
+func TestRedisCluster(t *testing.T) {
+	const nodes = 3
+	ctx := context.Background()
+	addrs := make([]string, nodes)
+	for i := range addrs {
+		addrs[i] = startRedisServer(t, "--cluster-enabled", "yes", "--cluster-config-file", "nodes.conf")
+	}
+	// split the 16384 slots evenly and join the nodes into one cluster
+	for i, addr := range addrs {
+		node := redis.NewClient(&redis.Options{Addr: addr})
+		first, last := i*16384/nodes, (i+1)*16384/nodes-1
+		if err := node.ClusterAddSlotsRange(ctx, first, last).Err(); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			host, port, _ := net.SplitHostPort(addrs[0])
+			if err := node.ClusterMeet(ctx, host, port).Err(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		node.Close()
+	}
+	for _, addr := range addrs {
+		node := redis.NewClient(&redis.Options{Addr: addr})
+		waitFor(t, func() bool {
+			info, err := node.ClusterInfo(ctx).Result()
+			return err == nil && strings.Contains(info, "cluster_state:ok")
+		})
+		node.Close()
+	}
+	setRedisConfig(config.RedisModeCluster, addrs...)
+	exerciseRedis(t)
+}
+
+func TestRedisSentinel(t *testing.T) {
+	ctx := context.Background()
+	master := startRedisServer(t)
+	host, port, _ := net.SplitHostPort(master)
+	sentinelConf := filepath.Join(t.TempDir(), "sentinel.conf")
+	conf := fmt.Sprintf("sentinel monitor mymaster %s %s 1\n", host, port)
+	if err := os.WriteFile(sentinelConf, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sentinel := startRedisServer(t, sentinelConf, "--sentinel")
+	sc := redis.NewSentinelClient(&redis.Options{Addr: sentinel})
+	defer sc.Close()
+	waitFor(t, func() bool {
+		_, err := sc.GetMasterAddrByName(ctx, "mymaster").Result()
+		return err == nil
+	})
+	setRedisConfig(config.RedisModeSentinel, sentinel)
+	viper.Set("cache.redis.master_name", "mymaster")
+	exerciseRedis(t)
+}
+
+// runs the scripts through the client of the configured mode, including a reload after the scripts are flushed
+func exerciseRedis(t *testing.T) {
+	ctx := context.Background()
+	store := InitRedis(ctx)
+	defer CloseRedis()
+
+	// built like the state server does, with a slot range not starting at 0
+	const connID = 42
+	slotRange := []int{5, 6, 7, 8}
+	keys := []string{
+		ConnKey(MaxClientIDKey, slotRange, connID),
+		ConnKey(UpMsgDedupKey, slotRange, connID),
+		ConnKey(ConnKeysKey, slotRange, connID),
+	}
+	expectAcceptClientID(t, store, keys, 0, []int64{1, 0})
+
+	// the script cache of every node is lost, e.g. after a failover or restart
+	var err error
+	if cluster, ok := rdb.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return node.ScriptFlush(ctx).Err()
+		})
+	} else {
+		err = rdb.ScriptFlush(ctx).Err()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAcceptClientID(t, store, keys, 0, []int64{4, 0})
+	expectAcceptClientID(t, store, keys, 1, []int64{1, 0})
+	expectAcceptClientID(t, store, keys, 5, []int64{3, 2})
+	if released, err := store.ReleaseClientID(ctx, keys[0], keys[1], 1, time.Minute); err != nil || !released {
+		t.Fatalf("release got %v, %v", released, err)
+	}
+	expectAcceptClientID(t, store, keys, 1, []int64{1, 0})
+
+	loginSlotKey := LoginSlotKey(slotRange, connID)
+	if err = store.SADD(ctx, loginSlotKey, "meta"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.CleanupConnection(ctx, keys[2], loginSlotKey, "meta"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := rdb.Exists(ctx, append(keys, loginSlotKey)...).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("%d keys of the connection left after cleanup", n)
+	}
+}
+
+func expectAcceptClientID(t *testing.T, store Store, keys []string, clientID uint64, want []int64) {
+	code, val, err := store.AcceptClientID(context.Background(), keys[0], keys[1], keys[2], clientID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != want[0] || int64(val) != want[1] {
+		t.Fatalf("clientID %d got {%d, %d}, want %v", clientID, code, val, want)
+	}
+}
+
+func setRedisConfig(mode string, endpoints ...string) {
+	viper.Reset()
+	viper.Set("cache.redis.mode", mode)
+	viper.Set("cache.redis.endpoints", endpoints)
+}
+
+// start a redis-server on a free port in a temporary directory, it is killed when the test ends
+func startRedisServer(t *testing.T, args ...string) string {
+	bin, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("redis-server not installed")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	// a config file must be the first argument
+	args = append(args, "--port", strconv.Itoa(port), "--save", "", "--appendonly", "no")
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = t.TempDir()
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	waitFor(t, func() bool {
+		return client.Ping(context.Background()).Err() == nil
+	})
+	return addr
+}
+
+func waitFor(t *testing.T, ready func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for redis")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Nil is returned by GetString when the key does not exist
+var Nil = redis.Nil
+
+// Store is the storage used by the state server, it is backed by redis in production
+// and by memory in tests
+type Store interface {
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	SetBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// set the value and add the key to the index set, so that it can be deleted with its owner
+	SetBytesIndexed(ctx context.Context, key string, value []byte, ttl time.Duration, indexKey string) error
+	GetString(ctx context.Context, key string) (string, error)
+	SetString(ctx context.Context, key string, value string, ttl time.Duration) error
+	IncrUint64(ctx context.Context, key string) (uint64, error)
+	Del(ctx context.Context, key string) error
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+
+	SADD(ctx context.Context, key string, member interface{}) error
+	SREM(ctx context.Context, key string, members ...interface{}) error
+	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
+	SmembersStrSlice(ctx context.Context, key string) ([]string, error)
+	// add the keys to the index set
+	SAddIndex(ctx context.Context, indexKey string, ttl time.Duration, keys ...string) error
+
+	HSet(ctx context.Context, key, field string, value interface{}, ttl time.Duration) error
+	HGetString(ctx context.Context, key, field string) (string, error)
+	HGetAllStrMap(ctx context.Context, key string) (map[string]string, error)
+	HDel(ctx context.Context, key string, fields ...string) error
+
+	RPushBytes(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// push the value and keep only the latest maxLen elements
+	RPushBytesCapped(ctx context.Context, key string, value []byte, maxLen int64, ttl time.Duration) error
+	LRangeBytes(ctx context.Context, key string) ([][]byte, error)
+	LSetBytes(ctx context.Context, key string, index int64, value []byte) error
+	LRemBytes(ctx context.Context, key string, value []byte) error
+
+	// the operations below are atomic, redis runs them as lua scripts
+
+	// accept the next ClientID of a connection, see LuaAcceptClientID
+	AcceptClientID(ctx context.Context, maxKey, dedupKey, indexKey string, clientID uint64, ttl time.Duration) (code int64, val uint64, err error)
+	// give back the latest accepted ClientID of a connection, see LuaReleaseClientID
+	ReleaseClientID(ctx context.Context, maxKey, dedupKey string, clientID uint64, ttl time.Duration) (bool, error)
+	// delete the keys indexed for a connection and remove it from the login slot, see LuaCleanupConnection
+	CleanupConnection(ctx context.Context, indexKey, loginSlotKey, loginSlotMeta string) error
+	// update the presence of a device and report whether its online status changed, see LuaUpdatePresence
+	UpdatePresence(ctx context.Context, key string, did uint64, online bool, lastActive int64, ttl time.Duration) (bool, error)
+	// move a cursor stored in a hash field forward only, see LuaAdvanceCursor
+	AdvanceCursor(ctx context.Context, key, field string, cursor uint64, ttl time.Duration) (bool, error)
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
@@ -14,6 +16,30 @@ func Init(path string) {
 	}
 }
 
+var (
+	watchOnce sync.Once
+	watchMu   sync.Mutex
+	onChanges []func()
+)
+
+// OnChange calls fn after the config file is modified, the getters return the new values from then on
+func OnChange(fn func()) {
+	watchMu.Lock()
+	onChanges = append(onChanges, fn)
+	watchMu.Unlock()
+	watchOnce.Do(func() {
+		viper.OnConfigChange(func(fsnotify.Event) {
+			watchMu.Lock()
+			fns := append([]func(){}, onChanges...)
+			watchMu.Unlock()
+			for _, fn := range fns {
+				fn()
+			}
+		})
+		viper.WatchConfig()
+	})
+}
+
 // get endpoints for discovery
 func GetEndpointsForDiscovery() []string {
 	return viper.GetStringSlice("discovery.endpoints")
@@ -32,6 +58,38 @@ func GetCacheRedisEndpointList() []string {
 	return viper.GetStringSlice("cache.redis.endpoints")
 }
 
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeCluster    = "cluster"
+	RedisModeSentinel   = "sentinel"
+)
+
+// standalone, cluster or sentinel, in sentinel mode the endpoints are the sentinels
+func GetCacheRedisMode() string {
+	mode := viper.GetString("cache.redis.mode")
+	if mode == "" {
+		mode = RedisModeStandalone
+	}
+	return mode
+}
+
+// name of the master monitored by the sentinels
+func GetCacheRedisMasterName() string {
+	return viper.GetString("cache.redis.master_name")
+}
+
+func GetCacheRedisPassword() string {
+	return viper.GetString("cache.redis.password")
+}
+
+func GetCacheRedisPoolSize() int {
+	size := viper.GetInt("cache.redis.pool_size")
+	if size <= 0 {
+		size = 10000
+	}
+	return size
+}
+
 // check if debug environment
 func IsDebug() bool {
 	env := viper.GetString("global.env")
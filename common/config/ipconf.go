@@ -0,0 +1,244 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// the strategy ranking the gateways, least_connections, headroom, multi_factor or random_top_k
+func GetIPConfDispatchStrategy() string {
+	strategy := viper.GetString("ip_conf.dispatch.strategy")
+	if strategy == "" {
+		strategy = "least_connections"
+	}
+	return strategy
+}
+
+// the strategy ranking the gateways before random_top_k picks one of the top k
+func GetIPConfDispatchBaseStrategy() string {
+	strategy := viper.GetString("ip_conf.dispatch.base_strategy")
+	if strategy == "" {
+		strategy = "least_connections"
+	}
+	return strategy
+}
+
+func GetIPConfDispatchTopK() int {
+	k := viper.GetInt("ip_conf.dispatch.top_k")
+	if k <= 0 {
+		k = 3
+	}
+	return k
+}
+
+// connection capacity of the gateways that do not report one
+func GetIPConfDispatchDefaultMaxConnectNum() float64 {
+	num := viper.GetFloat64("ip_conf.dispatch.default_max_connect_num")
+	if num <= 0 {
+		num = 10000
+	}
+	return num
+}
+
+// message bytes capacity of the gateways that do not report one
+func GetIPConfDispatchDefaultMaxMessageBytes() float64 {
+	num := viper.GetFloat64("ip_conf.dispatch.default_max_message_bytes")
+	if num <= 0 {
+		num = 1 << 30
+	}
+	return num
+}
+
+// weights of the cpu, connection and bandwidth usage in the multi_factor load, a factor can be disabled with 0
+func GetIPConfDispatchCPUWeight() float64 {
+	return getFloat64WithDefault("ip_conf.dispatch.cpu_weight", 0.4)
+}
+
+func GetIPConfDispatchConnWeight() float64 {
+	return getFloat64WithDefault("ip_conf.dispatch.conn_weight", 0.4)
+}
+
+func GetIPConfDispatchBandwidthWeight() float64 {
+	return getFloat64WithDefault("ip_conf.dispatch.bandwidth_weight", 0.2)
+}
+
+func getFloat64WithDefault(key string, def float64) float64 {
+	if !viper.IsSet(key) {
+		return def
+	}
+	return viper.GetFloat64(key)
+}
+
+// the proxies whose X-Forwarded-For header is trusted, ips or cidrs
+func GetIPConfTrustedProxies() []string {
+	return viper.GetStringSlice("ip_conf.trusted_proxies")
+}
+
+// the file mapping the client cidrs to their region and carrier, no region is resolved if empty
+func GetIPConfRegionDBPath() string {
+	return viper.GetString("ip_conf.region_db")
+}
+
+// virtual points of each gateway on the consistent hash ring of the devices
+func GetIPConfStickyReplicas() int {
+	replicas := viper.GetInt("ip_conf.sticky.replicas")
+	if replicas <= 0 {
+		replicas = 160
+	}
+	return replicas
+}
+
+// a gateway takes no more devices than (1+load_factor) times the average connections
+func GetIPConfStickyLoadFactor() float64 {
+	factor := viper.GetFloat64("ip_conf.sticky.load_factor")
+	if factor <= 0 {
+		factor = 0.25
+	}
+	return factor
+}
+
+// a gateway that has not reported its stats for longer is not dispatched to
+func GetIPConfHealthStaleAfter() time.Duration {
+	ms := viper.GetInt("ip_conf.health.stale_after")
+	if ms <= 0 {
+		ms = 15000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// whether the gateways are probed with a tcp connection
+func IsIPConfHealthProbeEnable() bool {
+	return viper.GetBool("ip_conf.health.probe.enable")
+}
+
+func GetIPConfHealthProbeInterval() time.Duration {
+	ms := viper.GetInt("ip_conf.health.probe.interval")
+	if ms <= 0 {
+		ms = 5000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func GetIPConfHealthProbeTimeout() time.Duration {
+	ms := viper.GetInt("ip_conf.health.probe.timeout")
+	if ms <= 0 {
+		ms = 1000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// consecutive failed probes before a gateway is not dispatched to
+func GetIPConfHealthProbeFailureThreshold() int32 {
+	num := viper.GetInt32("ip_conf.health.probe.failure_threshold")
+	if num <= 0 {
+		num = 3
+	}
+	return num
+}
+
+// the reported stats of a gateway decay by half in about 0.7 horizon
+func GetIPConfStatHorizon() time.Duration {
+	ms := viper.GetInt("ip_conf.stat.horizon")
+	if ms <= 0 {
+		ms = 10000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// address of the http server of ipconf
+func GetIPConfListenAddr() string {
+	addr := viper.GetString("ip_conf.listen_addr")
+	if addr == "" {
+		addr = ":6789"
+	}
+	return addr
+}
+
+// token of the /admin routes, they are not served if it is empty
+func GetIPConfAdminToken() string {
+	return viper.GetString("ip_conf.admin_token")
+}
+
+func GetIPConfPrometheusHost() string {
+	return viper.GetString("ip_conf.prometheus_host")
+}
+
+// port of the prometheus agent, the agent is not started if it is 0
+func GetIPConfPrometheusPort() int {
+	return viper.GetInt("ip_conf.prometheus_port")
+}
+
+// a ping to the registry every interval tells whether the gateways known may be stale
+func GetIPConfRegistryCheckInterval() time.Duration {
+	ms := viper.GetInt("ip_conf.registry.check_interval")
+	if ms <= 0 {
+		ms = 5000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// file of the candidate table loaded when the registry is unreachable at startup, no snapshot if empty
+func GetIPConfSnapshotPath() string {
+	return viper.GetString("ip_conf.registry.snapshot_path")
+}
+
+func GetIPConfSnapshotInterval() time.Duration {
+	ms := viper.GetInt("ip_conf.registry.snapshot_interval")
+	if ms <= 0 {
+		ms = 10000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// host:port of the gateways served when neither the registry nor the snapshot is available
+func GetIPConfStaticEndpoints() []string {
+	return viper.GetStringSlice("ip_conf.registry.static_endpoints")
+}
+
+// the ranked gateways are cached for the ttl, 0 disables the cache
+func GetIPConfCacheTTL() time.Duration {
+	if !viper.IsSet("ip_conf.cache.ttl") {
+		return 500 * time.Millisecond
+	}
+	return time.Duration(viper.GetInt("ip_conf.cache.ttl")) * time.Millisecond
+}
+
+func IsIPConfRateLimitEnable() bool {
+	return viper.GetBool("ip_conf.rate_limit.enable")
+}
+
+// requests a client ip may send per second
+func GetIPConfRateLimitRate() float64 {
+	rate := viper.GetFloat64("ip_conf.rate_limit.rate")
+	if rate <= 0 {
+		rate = 5
+	}
+	return rate
+}
+
+// requests a client ip may send in a burst
+func GetIPConfRateLimitBurst() int64 {
+	burst := viper.GetInt64("ip_conf.rate_limit.burst")
+	if burst <= 0 {
+		burst = 10
+	}
+	return burst
+}
+
+// the clients are told to retry after the base plus a random jitter, so they do not come back together
+func GetIPConfRetryBase() time.Duration {
+	ms := viper.GetInt("ip_conf.retry.base")
+	if ms <= 0 {
+		ms = 1000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func GetIPConfRetryJitter() time.Duration {
+	ms := viper.GetInt("ip_conf.retry.jitter")
+	if ms <= 0 {
+		ms = 2000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
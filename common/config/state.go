@@ -3,6 +3,7 @@ package config
 import (
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -10,6 +11,16 @@ import (
 func GetSateCmdChannelNum() int {
 	return viper.GetInt("state.cmd_channel_num")
 }
+
+// number of workers processing commands, the commands of a connection are always handled by the same worker
+func GetStateCmdWorkerNum() int {
+	num := viper.GetInt("state.cmd_worker_num")
+	if num <= 0 {
+		num = 16
+	}
+	return num
+}
+
 func GetSateServiceAddr() string {
 	return viper.GetString("state.servide_addr")
 }
@@ -41,12 +52,219 @@ func GetStateServerLoginSlotRange() []int {
 	}
 	res := make([]int, right-left+1)
 	for i := left; i <= right; i++ {
-		res[i] = i
+		res[i-left] = i
 	}
 	connStateSlotList = res
 	return connStateSlotList
 }
 
+// whether slot ownership is coordinated through etcd, otherwise the slot range is owned statically
+func IsStateSlotOwnershipEnable() bool {
+	return viper.GetBool("state.slot_ownership.enable")
+}
+
+func GetStateSlotOwnershipPath() string {
+	path := viper.GetString("state.slot_ownership.path")
+	if path == "" {
+		path = "/gochat/state"
+	}
+	return path
+}
+
+func GetStateSlotOwnershipLease() int64 {
+	lease := viper.GetInt64("state.slot_ownership.lease")
+	if lease <= 0 {
+		lease = 5
+	}
+	return lease
+}
+
 func GetStateServerGatewayServerEndpoint() string {
 	return viper.GetString("state.gateway_server_endpoint")
-}
\ No newline at end of file
+}
+// interval at which heartbeats refresh the presence last active time
+func GetStatePresenceActiveInterval() time.Duration {
+	interval := viper.GetDuration("state.presence_active_interval") * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return interval
+}
+
+// retransmission policy of down-stream messages, intervals are in milliseconds
+func GetStateRetransmitInitialInterval() time.Duration {
+	interval := viper.GetDuration("state.retransmit.initial_interval") * time.Millisecond
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+func GetStateRetransmitMaxInterval() time.Duration {
+	interval := viper.GetDuration("state.retransmit.max_interval") * time.Millisecond
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return interval
+}
+
+func GetStateRetransmitMultiplier() float64 {
+	multiplier := viper.GetFloat64("state.retransmit.multiplier")
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	return multiplier
+}
+
+// jitter ratio in [0, 1), the interval is randomized within +/- ratio
+func GetStateRetransmitJitter() float64 {
+	return viper.GetFloat64("state.retransmit.jitter")
+}
+
+func GetStateRetransmitMaxAttempts() int {
+	attempts := viper.GetInt("state.retransmit.max_attempts")
+	if attempts <= 0 {
+		attempts = 10
+	}
+	return attempts
+}
+
+// total deadline in seconds since the message was first pushed
+func GetStateRetransmitDeadline() time.Duration {
+	deadline := viper.GetDuration("state.retransmit.deadline") * time.Second
+	if deadline <= 0 {
+		deadline = 60 * time.Second
+	}
+	return deadline
+}
+
+// where messages exhausting their retries go, "offline" or "dead_letter"
+func GetStateRetransmitExhaustedPolicy() string {
+	return viper.GetString("state.retransmit.exhausted_policy")
+}
+
+// latest dead letters kept, the older ones are dropped
+func GetStateRetransmitDeadLetterSize() int64 {
+	size := viper.GetInt64("state.retransmit.dead_letter_size")
+	if size <= 0 {
+		size = 10000
+	}
+	return size
+}
+
+// port of the prometheus agent, the agent is not started if it is 0
+func GetStatePrometheusPort() int {
+	return viper.GetInt("state.prometheus_port")
+}
+
+// bounds of the heartbeat interval negotiated by clients, in seconds
+func GetStateHeartbeatMinInterval() time.Duration {
+	interval := viper.GetDuration("state.heartbeat.min_interval") * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return interval
+}
+
+func GetStateHeartbeatMaxInterval() time.Duration {
+	interval := viper.GetDuration("state.heartbeat.max_interval") * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return interval
+}
+
+// heartbeat interval used when the client does not ask for one, per network type if configured
+func GetStateHeartbeatDefaultInterval(networkType string) time.Duration {
+	if networkType != "" {
+		if interval := viper.GetDuration("state.heartbeat.network_interval." + networkType); interval > 0 {
+			return interval * time.Second
+		}
+	}
+	interval := viper.GetDuration("state.heartbeat.default_interval") * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return interval
+}
+
+// the connection is considered lost after this many heartbeat intervals without a heartbeat
+func GetStateHeartbeatTimeoutFactor() int {
+	factor := viper.GetInt("state.heartbeat.timeout_factor")
+	if factor <= 0 {
+		factor = 5
+	}
+	return factor
+}
+
+// the connection state is kept for re-connection for this many heartbeat intervals
+func GetStateReConnTimeoutFactor() int {
+	factor := viper.GetInt("state.heartbeat.reconn_factor")
+	if factor <= 0 {
+		factor = 10
+	}
+	return factor
+}
+
+// groups with more members than the threshold switch from write diffusion to read diffusion
+func GetStateGroupReadDiffusionThreshold() int {
+	threshold := viper.GetInt("state.group.read_diffusion_threshold")
+	if threshold <= 0 {
+		threshold = 500
+	}
+	return threshold
+}
+
+// number of the latest messages kept in the group timeline
+func GetStateGroupTimelineSize() int64 {
+	size := viper.GetInt64("state.group.timeline_size")
+	if size <= 0 {
+		size = 1000
+	}
+	return size
+}
+
+// number of the latest direct messages kept in the inbox of a user, the devices sync from it on login
+func GetStateInboxSize() int64 {
+	size := viper.GetInt64("state.inbox.size")
+	if size <= 0 {
+		size = 1000
+	}
+	return size
+}
+
+// signals a connection may send per second
+func GetStateSignalRate() float64 {
+	rate := viper.GetFloat64("state.signal.rate")
+	if rate <= 0 {
+		rate = 10
+	}
+	return rate
+}
+
+// signals a connection may send in a burst
+func GetStateSignalBurst() int64 {
+	burst := viper.GetInt64("state.signal.burst")
+	if burst <= 0 {
+		burst = 20
+	}
+	return burst
+}
+
+// a sent message can only be recalled or edited within the window
+func GetStateModifyWindow() time.Duration {
+	window := viper.GetInt("state.modify.window")
+	if window <= 0 {
+		window = 120
+	}
+	return time.Duration(window) * time.Second
+}
+
+// number of the latest accepted ClientIDs of a connection whose ack can be replayed
+func GetStateUpMsgDedupWindow() uint64 {
+	window := viper.GetUint64("state.up_msg_dedup_window")
+	if window == 0 {
+		window = 128
+	}
+	return window
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ipconf returns a signed ticket with the endpoints of the clients passing a device_id
+func IsTicketEnable() bool {
+	return viper.GetBool("ticket.enable")
+}
+
+// the gateway closes the connections whose first frame is not a login or re-connection with a valid ticket
+func IsTicketEnforce() bool {
+	return viper.GetBool("ticket.enforce")
+}
+
+func GetTicketTTL() time.Duration {
+	ms := viper.GetInt("ticket.ttl")
+	if ms <= 0 {
+		ms = 60000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// id of the key signing the new tickets
+func GetTicketActiveKey() string {
+	return viper.GetString("ticket.active_key")
+}
+
+// secrets by key id, the tickets signed by any of them are accepted
+func GetTicketKeys() map[string]string {
+	return viper.GetStringMapString("ticket.keys")
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryUnaryClientInterceptor retries the calls rejected with ResourceExhausted by a saturated server,
+// waiting backoff, 2*backoff, ... between the attempts, the last error is returned so the caller can shed the request
+func RetryUnaryClientInterceptor(attempts int, backoff time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var err error
+		wait := backoff
+		for i := 0; i < attempts; i++ {
+			if err = invoker(ctx, method, req, reply, cc, opts...); status.Code(err) != codes.ResourceExhausted {
+				return err
+			}
+			if i == attempts-1 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryUnaryClientInterceptor(t *testing.T) {
+	cc := new(grpc.ClientConn)
+	calls := 0
+	err := RetryUnaryClientInterceptor(3, time.Millisecond)(context.TODO(), "/push", nil, nil, cc,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+			opts ...grpc.CallOption) error {
+			calls++
+			if calls < 2 {
+				return status.Error(codes.ResourceExhausted, "full")
+			}
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = RetryUnaryClientInterceptor(3, time.Millisecond)(context.TODO(), "/push", nil, nil, cc,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+			opts ...grpc.CallOption) error {
+			calls++
+			return status.Error(codes.ResourceExhausted, "full")
+		},
+	)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = RetryUnaryClientInterceptor(3, time.Millisecond)(context.TODO(), "/push", nil, nil, cc,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+			opts ...grpc.CallOption) error {
+			calls++
+			return status.Error(codes.Internal, "failed")
+		},
+	)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, 1, calls)
+}
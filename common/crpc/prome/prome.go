@@ -19,3 +19,21 @@ func NewHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *promet
 
 	return histogramVec
 }
+
+// NewGaugeVec ...
+func NewGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *prometheus.GaugeVec {
+	gaugeVec := prometheus.NewGaugeVec(opts, labelNames)
+
+	prometheus.MustRegister(gaugeVec)
+
+	return gaugeVec
+}
+
+// NewGauge ...
+func NewGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	gauge := prometheus.NewGauge(opts)
+
+	prometheus.MustRegister(gauge)
+
+	return gauge
+}
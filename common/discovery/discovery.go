@@ -32,13 +32,24 @@ func NewServiceDiscovery(ctx *context.Context) *ServiceDiscovery {
 
 // WatchService initializes the service discovery and watches for changes
 func (s *ServiceDiscovery) WatchService(prefix string, set, del func(key, value string)) error {
-	resp, err := s.cli.Get(*s.ctx, prefix, clientv3.WithPrefix())
+	return s.WatchServiceSynced(prefix, set, del, nil)
+}
+
+// WatchServiceSynced is WatchService calling synced once the existing services have been set
+func (s *ServiceDiscovery) WatchServiceSynced(prefix string, set, del func(key, value string), synced func()) error {
+	// the client keeps retrying an unreachable registry, so the listing is bounded by the timeout
+	ctx, cancel := context.WithTimeout(*s.ctx, config.GetTimeoutForDiscovery())
+	resp, err := s.cli.Get(ctx, prefix, clientv3.WithPrefix())
+	cancel()
 	if err != nil {
 		return err
 	}
 	for _, kv := range resp.Kvs {
 		set(string(kv.Key), string(kv.Value))
 	}
+	if synced != nil {
+		synced()
+	}
 
 	s.watcher(prefix, resp.Header.Revision+1, set, del)
 	return nil
@@ -46,7 +57,8 @@ func (s *ServiceDiscovery) WatchService(prefix string, set, del func(key, value
 
 // watcher watches for prefix changes in the service discovery
 func (s *ServiceDiscovery) watcher(prefix string, rev int64, set, del func(key, value string)) {
-	rch := s.cli.Watch(*s.ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev))
+	// the value of a deleted key is empty, the previous one tells which service left
+	rch := s.cli.Watch(*s.ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev), clientv3.WithPrevKV())
 	logger.CtxInfof(*s.ctx, "Watching prefix: %v now...", prefix)
 	for wresp := range rch {
 		for _, ev := range wresp.Events {
@@ -54,12 +66,24 @@ func (s *ServiceDiscovery) watcher(prefix string, rev int64, set, del func(key,
 			case mvccpb.PUT:
 				set(string(ev.Kv.Key), string(ev.Kv.Value))
 			case mvccpb.DELETE:
-				del(string(ev.Kv.Key), string(ev.Kv.Value))
+				value := ev.Kv.Value
+				if ev.PrevKv != nil {
+					value = ev.PrevKv.Value
+				}
+				del(string(ev.Kv.Key), string(value))
 			}
 		}
 	}
 }
 
+// Ping checks the registry answers within the discovery timeout, a watch does not notice it is unreachable
+func (s *ServiceDiscovery) Ping(prefix string) error {
+	ctx, cancel := context.WithTimeout(*s.ctx, config.GetTimeoutForDiscovery())
+	defer cancel()
+	_, err := s.cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	return err
+}
+
 func (s *ServiceDiscovery) Close() error {
 	return s.cli.Close()
 }
@@ -0,0 +1,367 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/logger"
+	"github.com/feichai0017/GoChat/common/config"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	slotMembersDir = "/members/"
+	slotOwnersDir  = "/slots/"
+)
+
+// SlotManager coordinates slot ownership between servers through etcd leases.
+// Every server registers itself as a member, the target owner of each slot is chosen by
+// rendezvous hashing over the members, and a slot is held by a key bound to the owner's lease,
+// so the slots of a dead server are released as soon as its lease expires.
+type SlotManager struct {
+	cli       *clientv3.Client
+	ctx       *context.Context
+	prefix    string
+	endpoint  string
+	slots     []int
+	ttl       int64
+	leaseID   clientv3.LeaseID
+	owned     map[int]bool
+	onAcquire func(slot int)
+	onRelease func(slot int)
+	trigger   chan struct{}
+	closed    bool
+	lock      sync.Mutex
+}
+
+func NewSlotManager(ctx *context.Context, prefix, endpoint string, slots []int, ttl int64, onAcquire, onRelease func(slot int)) (*SlotManager, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.GetEndpointsForDiscovery(),
+		DialTimeout: config.GetTimeoutForDiscovery(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SlotManager{
+		cli:       cli,
+		ctx:       ctx,
+		prefix:    prefix,
+		endpoint:  endpoint,
+		slots:     slots,
+		ttl:       ttl,
+		owned:     make(map[int]bool),
+		onAcquire: onAcquire,
+		onRelease: onRelease,
+		trigger:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Start registers the member, takes over the target slots and keeps rebalancing in the background
+func (m *SlotManager) Start() error {
+	if err := m.register(); err != nil {
+		return err
+	}
+	m.reconcile()
+	go m.watch()
+	go m.loop()
+	return nil
+}
+
+// Owns reports whether the slot is currently held by this server
+func (m *SlotManager) Owns(slot int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.owned[slot]
+}
+
+// Close releases all owned slots and leaves the member list
+func (m *SlotManager) Close() error {
+	m.lock.Lock()
+	m.closed = true
+	for slot := range m.owned {
+		m.onRelease(slot)
+		delete(m.owned, slot)
+	}
+	leaseID := m.leaseID
+	m.lock.Unlock()
+	if _, err := m.cli.Revoke(context.Background(), leaseID); err != nil {
+		return err
+	}
+	return m.cli.Close()
+}
+
+func (m *SlotManager) register() error {
+	resp, err := m.cli.Grant(*m.ctx, m.ttl)
+	if err != nil {
+		return err
+	}
+	if _, err = m.cli.Put(*m.ctx, m.prefix+slotMembersDir+m.endpoint, m.endpoint, clientv3.WithLease(resp.ID)); err != nil {
+		return err
+	}
+	keepAliveChan, err := m.cli.KeepAlive(*m.ctx, resp.ID)
+	if err != nil {
+		return err
+	}
+	// the slots are acquired with the lease under the lock, and the member registers again when the lease is lost
+	m.lock.Lock()
+	m.leaseID = resp.ID
+	m.lock.Unlock()
+	go m.keepAlive(keepAliveChan)
+	return nil
+}
+
+// when the lease is lost, all slots are considered lost and the member registers again
+func (m *SlotManager) keepAlive(ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for range ch {
+	}
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	logger.CtxErrorf(*m.ctx, "SlotManager lease lost, leaseID:%d endpoint:%s", m.leaseID, m.endpoint)
+	for slot := range m.owned {
+		m.onRelease(slot)
+		delete(m.owned, slot)
+	}
+	m.lock.Unlock()
+	for (*m.ctx).Err() == nil {
+		if err := m.register(); err == nil {
+			m.notify()
+			return
+		}
+		time.Sleep(time.Duration(m.ttl) * time.Second)
+	}
+}
+
+func (m *SlotManager) watch() {
+	rch := m.cli.Watch(*m.ctx, m.prefix, clientv3.WithPrefix())
+	for range rch {
+		m.notify()
+	}
+}
+
+// reconcile on every change, and periodically to retry failed acquisitions
+func (m *SlotManager) loop() {
+	ticker := time.NewTicker(time.Duration(m.ttl) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-(*m.ctx).Done():
+			return
+		case <-ticker.C:
+		case <-m.trigger:
+		}
+		m.reconcile()
+	}
+}
+
+func (m *SlotManager) notify() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *SlotManager) reconcile() {
+	members, err := m.getMembers()
+	if err != nil {
+		logger.CtxErrorf(*m.ctx, "SlotManager.getMembers err: %v", err)
+		return
+	}
+	owners, err := getSlotOwners(*m.ctx, m.cli, m.prefix)
+	if err != nil {
+		logger.CtxErrorf(*m.ctx, "SlotManager.getSlotOwners err: %v", err)
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, slot := range m.slots {
+		owner, held := owners[slot]
+		if slotTarget(slot, members) == m.endpoint {
+			if held && owner == m.endpoint {
+				if !m.owned[slot] {
+					m.owned[slot] = true
+					m.onAcquire(slot)
+				}
+				continue
+			}
+			if held {
+				// wait for the old owner to release the slot
+				continue
+			}
+			if m.acquire(slot) {
+				m.owned[slot] = true
+				m.onAcquire(slot)
+			}
+			continue
+		}
+		if m.owned[slot] {
+			// stop serving the slot before handing it over
+			m.onRelease(slot)
+			delete(m.owned, slot)
+			m.release(slot)
+		}
+	}
+	// the key of an owned slot may be gone with an expired lease
+	for slot := range m.owned {
+		if owner, held := owners[slot]; !held || owner != m.endpoint {
+			m.onRelease(slot)
+			delete(m.owned, slot)
+		}
+	}
+}
+
+// acquire holds the slot with the lease of the member, the caller holds the lock
+func (m *SlotManager) acquire(slot int) bool {
+	key := m.slotKey(slot)
+	resp, err := m.cli.Txn(*m.ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, m.endpoint, clientv3.WithLease(m.leaseID))).
+		Commit()
+	if err != nil {
+		logger.CtxErrorf(*m.ctx, "SlotManager.acquire slot:%d err: %v", slot, err)
+		return false
+	}
+	return resp.Succeeded
+}
+
+func (m *SlotManager) release(slot int) {
+	key := m.slotKey(slot)
+	_, err := m.cli.Txn(*m.ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", m.endpoint)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		logger.CtxErrorf(*m.ctx, "SlotManager.release slot:%d err: %v", slot, err)
+	}
+}
+
+func (m *SlotManager) getMembers() ([]string, error) {
+	resp, err := m.cli.Get(*m.ctx, m.prefix+slotMembersDir, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		members = append(members, string(kv.Value))
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (m *SlotManager) slotKey(slot int) string {
+	return fmt.Sprintf("%s%s%d", m.prefix, slotOwnersDir, slot)
+}
+
+// rendezvous hashing, a membership change only moves the slots of the joining or leaving member
+func slotTarget(slot int, members []string) string {
+	var (
+		target string
+		max    uint64
+	)
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(strconv.Itoa(slot) + "|" + member))
+		if sum := h.Sum64(); target == "" || sum > max {
+			target, max = member, sum
+		}
+	}
+	return target
+}
+
+func getSlotOwners(ctx context.Context, cli *clientv3.Client, prefix string) (map[int]string, error) {
+	resp, err := cli.Get(ctx, prefix+slotOwnersDir, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[int]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if slot, ok := parseSlotKey(prefix, string(kv.Key)); ok {
+			owners[slot] = string(kv.Value)
+		}
+	}
+	return owners, nil
+}
+
+func parseSlotKey(prefix, key string) (int, bool) {
+	slot, err := strconv.Atoi(strings.TrimPrefix(key, prefix+slotOwnersDir))
+	if err != nil {
+		return 0, false
+	}
+	return slot, true
+}
+
+// SlotTable is a read-only view of the slot owners, used to route a connection to its state server
+type SlotTable struct {
+	cli    *clientv3.Client
+	ctx    *context.Context
+	prefix string
+	owners map[int]string
+	lock   sync.RWMutex
+}
+
+func NewSlotTable(ctx *context.Context, prefix string) (*SlotTable, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.GetEndpointsForDiscovery(),
+		DialTimeout: config.GetTimeoutForDiscovery(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := &SlotTable{
+		cli:    cli,
+		ctx:    ctx,
+		prefix: prefix,
+	}
+	resp, err := cli.Get(*ctx, prefix+slotOwnersDir, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	t.owners = make(map[int]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if slot, ok := parseSlotKey(prefix, string(kv.Key)); ok {
+			t.owners[slot] = string(kv.Value)
+		}
+	}
+	go t.watch(resp.Header.Revision + 1)
+	return t, nil
+}
+
+// Owner returns the endpoint currently holding the slot
+func (t *SlotTable) Owner(slot int) (string, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	owner, ok := t.owners[slot]
+	return owner, ok
+}
+
+func (t *SlotTable) Close() error {
+	return t.cli.Close()
+}
+
+func (t *SlotTable) watch(rev int64) {
+	rch := t.cli.Watch(*t.ctx, t.prefix+slotOwnersDir, clientv3.WithPrefix(), clientv3.WithRev(rev))
+	for wresp := range rch {
+		t.lock.Lock()
+		for _, ev := range wresp.Events {
+			slot, ok := parseSlotKey(t.prefix, string(ev.Kv.Key))
+			if !ok {
+				continue
+			}
+			switch ev.Type {
+			case clientv3.EventTypePut:
+				t.owners[slot] = string(ev.Kv.Value)
+			case clientv3.EventTypeDelete:
+				delete(t.owners, slot)
+			}
+		}
+		t.lock.Unlock()
+	}
+}
@@ -0,0 +1,240 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestSlotTarget(t *testing.T) {
+	members := []string{"127.0.0.1:8902", "127.0.0.1:8912", "127.0.0.1:8922"}
+	before := make(map[int]string)
+	for slot := 0; slot < 1024; slot++ {
+		before[slot] = slotTarget(slot, members)
+	}
+
+	// a joining member only takes slots over, no slot moves between the old members
+	joined := append(members, "127.0.0.1:8932")
+	moved := 0
+	for slot := 0; slot < 1024; slot++ {
+		target := slotTarget(slot, joined)
+		if target == before[slot] {
+			continue
+		}
+		if target != "127.0.0.1:8932" {
+			t.Fatalf("slot %d moved from %s to %s", slot, before[slot], target)
+		}
+		moved++
+	}
+	if moved == 0 || moved > 1024/2 {
+		t.Fatalf("unexpected moved slots %d", moved)
+	}
+
+	// a leaving member only hands its own slots over
+	left := members[1:]
+	for slot := 0; slot < 1024; slot++ {
+		if before[slot] != members[0] && slotTarget(slot, left) != before[slot] {
+			t.Fatalf("slot %d moved although its owner is alive", slot)
+		}
+	}
+
+	if target := slotTarget(1, nil); target != "" {
+		t.Fatalf("expected no target without members, got %s", target)
+	}
+}
+
+// The tests below spawn a local etcd process, they are skipped when etcd is not installed.
+
+func TestSlotManager(t *testing.T) {
+	setEtcdConfig(startEtcd(t))
+	slots := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	a := newTestSlotManager(t, "127.0.0.1:8902", slots)
+	// a single member acquires every slot
+	waitForSlots(t, map[string]*testSlotManager{"127.0.0.1:8902": a}, slots)
+
+	// a joining member takes its target slots over, the old owner releases them first
+	b := newTestSlotManager(t, "127.0.0.1:8912", slots)
+	members := map[string]*testSlotManager{"127.0.0.1:8902": a, "127.0.0.1:8912": b}
+	waitForSlots(t, members, slots)
+	for _, slot := range slots {
+		if slotTarget(slot, []string{"127.0.0.1:8902", "127.0.0.1:8912"}) == "127.0.0.1:8912" && a.released(slot) == 0 {
+			t.Fatalf("slot %d acquired by the new member without a release", slot)
+		}
+	}
+
+	// a leaving member releases its slots to the others
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForSlots(t, map[string]*testSlotManager{"127.0.0.1:8902": a}, slots)
+}
+
+func TestSlotManagerLeaseLost(t *testing.T) {
+	setEtcdConfig(startEtcd(t))
+	slots := []int{0, 1, 2, 3}
+	m := newTestSlotManager(t, "127.0.0.1:8902", slots)
+	members := map[string]*testSlotManager{"127.0.0.1:8902": m}
+	waitForSlots(t, members, slots)
+
+	// the lease expires, e.g. after a long pause, the slot keys bound to it are gone
+	m.lock.Lock()
+	leaseID := m.leaseID
+	m.lock.Unlock()
+	if _, err := m.cli.Revoke(context.Background(), leaseID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		for _, slot := range slots {
+			if m.released(slot) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	// the member registers with a new lease and takes its slots back
+	waitForSlots(t, members, slots)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.leaseID == leaseID {
+		t.Fatal("the lost lease is still used")
+	}
+}
+
+// testSlotManager counts the acquisitions and releases of each slot
+type testSlotManager struct {
+	*SlotManager
+	events sync.Mutex
+	acq    map[int]int
+	rel    map[int]int
+}
+
+func newTestSlotManager(t *testing.T, endpoint string, slots []int) *testSlotManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	tm := &testSlotManager{acq: make(map[int]int), rel: make(map[int]int)}
+	m, err := NewSlotManager(&ctx, "/test/state", endpoint, slots, 2,
+		func(slot int) {
+			tm.events.Lock()
+			defer tm.events.Unlock()
+			tm.acq[slot]++
+		},
+		func(slot int) {
+			tm.events.Lock()
+			defer tm.events.Unlock()
+			tm.rel[slot]++
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.SlotManager = m
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		m.Close()
+	})
+	return tm
+}
+
+func (tm *testSlotManager) released(slot int) int {
+	tm.events.Lock()
+	defer tm.events.Unlock()
+	return tm.rel[slot]
+}
+
+// wait until every slot is owned by its target member only, and the owner key in etcd agrees
+func waitForSlots(t *testing.T, members map[string]*testSlotManager, slots []int) {
+	endpoints := make([]string, 0, len(members))
+	var cli *clientv3.Client
+	for endpoint, m := range members {
+		endpoints = append(endpoints, endpoint)
+		cli = m.cli
+	}
+	waitFor(t, func() bool {
+		owners, err := getSlotOwners(context.Background(), cli, "/test/state")
+		if err != nil {
+			return false
+		}
+		for _, slot := range slots {
+			target := slotTarget(slot, endpoints)
+			if owners[slot] != target {
+				return false
+			}
+			for endpoint, m := range members {
+				if m.Owns(slot) != (endpoint == target) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+func setEtcdConfig(endpoint string) {
+	viper.Reset()
+	viper.Set("discovery.endpoints", []string{endpoint})
+	viper.Set("discovery.timeout", 5)
+}
+
+// start an etcd on free ports in a temporary directory, it is killed when the test ends
+func startEtcd(t *testing.T) string {
+	bin, err := exec.LookPath("etcd")
+	if err != nil {
+		t.Skip("etcd not installed")
+	}
+	client, peer := freePort(t), freePort(t)
+	clientURL := fmt.Sprintf("http://127.0.0.1:%d", client)
+	peerURL := fmt.Sprintf("http://127.0.0.1:%d", peer)
+	cmd := exec.Command(bin,
+		"--data-dir", t.TempDir(),
+		"--listen-client-urls", clientURL, "--advertise-client-urls", clientURL,
+		"--listen-peer-urls", peerURL, "--initial-advertise-peer-urls", peerURL,
+		"--initial-cluster", "default="+peerURL,
+		"--log-level", "error")
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL}, DialTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	waitFor(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := cli.Get(ctx, "health")
+		return err == nil
+	})
+	return clientURL
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func waitFor(t *testing.T, ready func() bool) {
+	deadline := time.Now().Add(20 * time.Second)
+	for !ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the slots")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
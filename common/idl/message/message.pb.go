@@ -26,31 +26,52 @@ const (
 type CmdType int32
 
 const (
-	CmdType_Login     CmdType = 0
-	CmdType_Heartbeat CmdType = 1
-	CmdType_ReConn    CmdType = 2
-	CmdType_ACK       CmdType = 3
-	CmdType_UP        CmdType = 4 // UP message
-	CmdType_Push      CmdType = 5 // Push message
+	CmdType_Login       CmdType = 0
+	CmdType_Heartbeat   CmdType = 1
+	CmdType_ReConn      CmdType = 2
+	CmdType_ACK         CmdType = 3
+	CmdType_UP          CmdType = 4  // UP message
+	CmdType_Push        CmdType = 5  // Push message
+	CmdType_PresenceSub CmdType = 6  // presence subscribe/unsubscribe
+	CmdType_Presence    CmdType = 7  // presence change notification
+	CmdType_Group       CmdType = 8  // group operation request and reply
+	CmdType_Delivered   CmdType = 9  // delivery receipt
+	CmdType_Read        CmdType = 10 // read receipt
+	CmdType_Signal      CmdType = 11 // ephemeral signal, neither persisted nor retransmitted
+	CmdType_Modify      CmdType = 12 // recall or edit a sent message, request, reply and event
 )
 
 // Enum value maps for CmdType.
 var (
 	CmdType_name = map[int32]string{
-		0: "Login",
-		1: "Heartbeat",
-		2: "ReConn",
-		3: "ACK",
-		4: "UP",
-		5: "Push",
+		0:  "Login",
+		1:  "Heartbeat",
+		2:  "ReConn",
+		3:  "ACK",
+		4:  "UP",
+		5:  "Push",
+		6:  "PresenceSub",
+		7:  "Presence",
+		8:  "Group",
+		9:  "Delivered",
+		10: "Read",
+		11: "Signal",
+		12: "Modify",
 	}
 	CmdType_value = map[string]int32{
-		"Login":     0,
-		"Heartbeat": 1,
-		"ReConn":    2,
-		"ACK":       3,
-		"UP":        4,
-		"Push":      5,
+		"Login":       0,
+		"Heartbeat":   1,
+		"ReConn":      2,
+		"ACK":         3,
+		"UP":          4,
+		"Push":        5,
+		"PresenceSub": 6,
+		"Presence":    7,
+		"Group":       8,
+		"Delivered":   9,
+		"Read":        10,
+		"Signal":      11,
+		"Modify":      12,
 	}
 )
 
@@ -81,6 +102,165 @@ func (CmdType) EnumDescriptor() ([]byte, []int) {
 	return file_message_proto_rawDescGZIP(), []int{0}
 }
 
+// Code of the ack of an UP message
+type ACKCode int32
+
+const (
+	ACKCode_OK         ACKCode = 0 // accepted, a duplicate gets the original ack replayed
+	ACKCode_OutOfOrder ACKCode = 1 // the ClientID is ahead of the expected one, resend from ExpectedClientID
+	ACKCode_Failed     ACKCode = 2 // not delivered, the ClientID is released, resend from ExpectedClientID
+	ACKCode_Rejected   ACKCode = 3 // not allowed, e.g. the sender is not a member of the group, the message must not be resent
+	ACKCode_InProgress ACKCode = 4 // a duplicate of a message still being delivered, its ack follows
+)
+
+// Enum value maps for ACKCode.
+var (
+	ACKCode_name = map[int32]string{
+		0: "OK",
+		1: "OutOfOrder",
+		2: "Failed",
+		3: "Rejected",
+		4: "InProgress",
+	}
+	ACKCode_value = map[string]int32{
+		"OK":         0,
+		"OutOfOrder": 1,
+		"Failed":     2,
+		"Rejected":   3,
+		"InProgress": 4,
+	}
+)
+
+func (x ACKCode) Enum() *ACKCode {
+	p := new(ACKCode)
+	*p = x
+	return p
+}
+
+func (x ACKCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ACKCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_message_proto_enumTypes[1].Descriptor()
+}
+
+func (ACKCode) Type() protoreflect.EnumType {
+	return &file_message_proto_enumTypes[1]
+}
+
+func (x ACKCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ACKCode.Descriptor instead.
+func (ACKCode) EnumDescriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{1}
+}
+
+// Group operation
+type GroupOp int32
+
+const (
+	GroupOp_Create  GroupOp = 0 // create a group with the sender and UserIDs as members
+	GroupOp_Join    GroupOp = 1
+	GroupOp_Leave   GroupOp = 2
+	GroupOp_Members GroupOp = 3 // list members
+	GroupOp_Sync    GroupOp = 4 // pull the messages after MsgID from the group timeline
+)
+
+// Enum value maps for GroupOp.
+var (
+	GroupOp_name = map[int32]string{
+		0: "Create",
+		1: "Join",
+		2: "Leave",
+		3: "Members",
+		4: "Sync",
+	}
+	GroupOp_value = map[string]int32{
+		"Create":  0,
+		"Join":    1,
+		"Leave":   2,
+		"Members": 3,
+		"Sync":    4,
+	}
+)
+
+func (x GroupOp) Enum() *GroupOp {
+	p := new(GroupOp)
+	*p = x
+	return p
+}
+
+func (x GroupOp) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (GroupOp) Descriptor() protoreflect.EnumDescriptor {
+	return file_message_proto_enumTypes[2].Descriptor()
+}
+
+func (GroupOp) Type() protoreflect.EnumType {
+	return &file_message_proto_enumTypes[2]
+}
+
+func (x GroupOp) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use GroupOp.Descriptor instead.
+func (GroupOp) EnumDescriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{2}
+}
+
+// Modify operation
+type ModifyOp int32
+
+const (
+	ModifyOp_Recall ModifyOp = 0
+	ModifyOp_Edit   ModifyOp = 1
+)
+
+// Enum value maps for ModifyOp.
+var (
+	ModifyOp_name = map[int32]string{
+		0: "Recall",
+		1: "Edit",
+	}
+	ModifyOp_value = map[string]int32{
+		"Recall": 0,
+		"Edit":   1,
+	}
+)
+
+func (x ModifyOp) Enum() *ModifyOp {
+	p := new(ModifyOp)
+	*p = x
+	return p
+}
+
+func (x ModifyOp) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ModifyOp) Descriptor() protoreflect.EnumDescriptor {
+	return file_message_proto_enumTypes[3].Descriptor()
+}
+
+func (ModifyOp) Type() protoreflect.EnumType {
+	return &file_message_proto_enumTypes[3]
+}
+
+func (x ModifyOp) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ModifyOp.Descriptor instead.
+func (ModifyOp) EnumDescriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{3}
+}
+
 // top-level cmd pb structure
 type MsgCmd struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -192,6 +372,8 @@ type UPMsgHead struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ClientID      uint64                 `protobuf:"varint,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
 	ConnID        uint64                 `protobuf:"varint,2,opt,name=ConnID,proto3" json:"ConnID,omitempty"`
+	ToUserID      uint64                 `protobuf:"varint,3,opt,name=ToUserID,proto3" json:"ToUserID,omitempty"` // deliver to every online device of the user, echo back if empty
+	GroupID       uint64                 `protobuf:"varint,4,opt,name=GroupID,proto3" json:"GroupID,omitempty"`   // fan out to every member of the group
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -240,11 +422,32 @@ func (x *UPMsgHead) GetConnID() uint64 {
 	return 0
 }
 
+func (x *UPMsgHead) GetToUserID() uint64 {
+	if x != nil {
+		return x.ToUserID
+	}
+	return 0
+}
+
+func (x *UPMsgHead) GetGroupID() uint64 {
+	if x != nil {
+		return x.GroupID
+	}
+	return 0
+}
+
 type PushMsg struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	MsgID         uint64                 `protobuf:"varint,1,opt,name=MsgID,proto3" json:"MsgID,omitempty"`
 	SessionID     uint64                 `protobuf:"varint,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
 	Content       []byte                 `protobuf:"bytes,3,opt,name=Content,proto3" json:"Content,omitempty"`
+	FromUserID    uint64                 `protobuf:"varint,4,opt,name=FromUserID,proto3" json:"FromUserID,omitempty"`
+	FromDeviceID  uint64                 `protobuf:"varint,5,opt,name=FromDeviceID,proto3" json:"FromDeviceID,omitempty"`
+	SentByMe      bool                   `protobuf:"varint,6,opt,name=SentByMe,proto3" json:"SentByMe,omitempty"` // synced from another device of the same user
+	Edited        bool                   `protobuf:"varint,7,opt,name=Edited,proto3" json:"Edited,omitempty"`
+	Recalled      bool                   `protobuf:"varint,8,opt,name=Recalled,proto3" json:"Recalled,omitempty"`  // the content is dropped
+	Seq           uint64                 `protobuf:"varint,9,opt,name=Seq,proto3" json:"Seq,omitempty"`            // position in the inbox of the receiver, the devices sync from the last acked one
+	ToUserID      uint64                 `protobuf:"varint,10,opt,name=ToUserID,proto3" json:"ToUserID,omitempty"` // receiver of a direct message, the session is the pair of FromUserID and ToUserID
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -300,18 +503,71 @@ func (x *PushMsg) GetContent() []byte {
 	return nil
 }
 
+func (x *PushMsg) GetFromUserID() uint64 {
+	if x != nil {
+		return x.FromUserID
+	}
+	return 0
+}
+
+func (x *PushMsg) GetFromDeviceID() uint64 {
+	if x != nil {
+		return x.FromDeviceID
+	}
+	return 0
+}
+
+func (x *PushMsg) GetSentByMe() bool {
+	if x != nil {
+		return x.SentByMe
+	}
+	return false
+}
+
+func (x *PushMsg) GetEdited() bool {
+	if x != nil {
+		return x.Edited
+	}
+	return false
+}
+
+func (x *PushMsg) GetRecalled() bool {
+	if x != nil {
+		return x.Recalled
+	}
+	return false
+}
+
+func (x *PushMsg) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *PushMsg) GetToUserID() uint64 {
+	if x != nil {
+		return x.ToUserID
+	}
+	return 0
+}
+
 // ACK message
 type ACKMsg struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Code          uint32                 `protobuf:"varint,1,opt,name=Code,proto3" json:"Code,omitempty"`
-	Msg           string                 `protobuf:"bytes,2,opt,name=Msg,proto3" json:"Msg,omitempty"`
-	Type          CmdType                `protobuf:"varint,3,opt,name=Type,proto3,enum=message.CmdType" json:"Type,omitempty"`
-	ConnID        uint64                 `protobuf:"varint,4,opt,name=ConnID,proto3" json:"ConnID,omitempty"`
-	ClientID      uint64                 `protobuf:"varint,5,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
-	SessionID     uint64                 `protobuf:"varint,6,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
-	MsgID         uint64                 `protobuf:"varint,7,opt,name=MsgID,proto3" json:"MsgID,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Code              uint32                 `protobuf:"varint,1,opt,name=Code,proto3" json:"Code,omitempty"`
+	Msg               string                 `protobuf:"bytes,2,opt,name=Msg,proto3" json:"Msg,omitempty"`
+	Type              CmdType                `protobuf:"varint,3,opt,name=Type,proto3,enum=message.CmdType" json:"Type,omitempty"`
+	ConnID            uint64                 `protobuf:"varint,4,opt,name=ConnID,proto3" json:"ConnID,omitempty"`
+	ClientID          uint64                 `protobuf:"varint,5,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	SessionID         uint64                 `protobuf:"varint,6,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	MsgID             uint64                 `protobuf:"varint,7,opt,name=MsgID,proto3" json:"MsgID,omitempty"`
+	HeartbeatInterval uint32                 `protobuf:"varint,8,opt,name=HeartbeatInterval,proto3" json:"HeartbeatInterval,omitempty"` // negotiated heartbeat interval in seconds, carried by login ack
+	ExpectedClientID  uint64                 `protobuf:"varint,9,opt,name=ExpectedClientID,proto3" json:"ExpectedClientID,omitempty"`   // carried by the out of order nack of an UP message
+	FromUserID        uint64                 `protobuf:"varint,10,opt,name=FromUserID,proto3" json:"FromUserID,omitempty"`              // echoed from the acked push, the msgIDs of a direct session are allocated per pair of users
+	ToUserID          uint64                 `protobuf:"varint,11,opt,name=ToUserID,proto3" json:"ToUserID,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
 func (x *ACKMsg) Reset() {
@@ -393,12 +649,44 @@ func (x *ACKMsg) GetMsgID() uint64 {
 	return 0
 }
 
+func (x *ACKMsg) GetHeartbeatInterval() uint32 {
+	if x != nil {
+		return x.HeartbeatInterval
+	}
+	return 0
+}
+
+func (x *ACKMsg) GetExpectedClientID() uint64 {
+	if x != nil {
+		return x.ExpectedClientID
+	}
+	return 0
+}
+
+func (x *ACKMsg) GetFromUserID() uint64 {
+	if x != nil {
+		return x.FromUserID
+	}
+	return 0
+}
+
+func (x *ACKMsg) GetToUserID() uint64 {
+	if x != nil {
+		return x.ToUserID
+	}
+	return 0
+}
+
 // Login message
 type LoginMsgHead struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	DeviceID      uint64                 `protobuf:"varint,1,opt,name=DeviceID,proto3" json:"DeviceID,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	DeviceID          uint64                 `protobuf:"varint,1,opt,name=DeviceID,proto3" json:"DeviceID,omitempty"`
+	UserID            uint64                 `protobuf:"varint,2,opt,name=UserID,proto3" json:"UserID,omitempty"`
+	HeartbeatInterval uint32                 `protobuf:"varint,3,opt,name=HeartbeatInterval,proto3" json:"HeartbeatInterval,omitempty"` // desired heartbeat interval in seconds, 0 means server default
+	NetworkType       string                 `protobuf:"bytes,4,opt,name=NetworkType,proto3" json:"NetworkType,omitempty"`              // e.g. wifi, cellular, used when no interval is desired
+	Ticket            string                 `protobuf:"bytes,5,opt,name=Ticket,proto3" json:"Ticket,omitempty"`                        // issued by ipconf, required by the gateways enforcing the tickets
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
 func (x *LoginMsgHead) Reset() {
@@ -438,6 +726,34 @@ func (x *LoginMsgHead) GetDeviceID() uint64 {
 	return 0
 }
 
+func (x *LoginMsgHead) GetUserID() uint64 {
+	if x != nil {
+		return x.UserID
+	}
+	return 0
+}
+
+func (x *LoginMsgHead) GetHeartbeatInterval() uint32 {
+	if x != nil {
+		return x.HeartbeatInterval
+	}
+	return 0
+}
+
+func (x *LoginMsgHead) GetNetworkType() string {
+	if x != nil {
+		return x.NetworkType
+	}
+	return ""
+}
+
+func (x *LoginMsgHead) GetTicket() string {
+	if x != nil {
+		return x.Ticket
+	}
+	return ""
+}
+
 type LoginMsg struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Head          *LoginMsgHead          `protobuf:"bytes,1,opt,name=Head,proto3" json:"Head,omitempty"`
@@ -583,6 +899,8 @@ func (x *HeartbeatMsg) GetHeartbeatMsgBody() []byte {
 type ReConnMsgHead struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ConnID        uint64                 `protobuf:"varint,1,opt,name=ConnID,proto3" json:"ConnID,omitempty"`
+	DeviceID      uint64                 `protobuf:"varint,2,opt,name=DeviceID,proto3" json:"DeviceID,omitempty"`
+	Ticket        string                 `protobuf:"bytes,3,opt,name=Ticket,proto3" json:"Ticket,omitempty"` // issued by ipconf, required by the gateways enforcing the tickets
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -624,6 +942,20 @@ func (x *ReConnMsgHead) GetConnID() uint64 {
 	return 0
 }
 
+func (x *ReConnMsgHead) GetDeviceID() uint64 {
+	if x != nil {
+		return x.DeviceID
+	}
+	return 0
+}
+
+func (x *ReConnMsgHead) GetTicket() string {
+	if x != nil {
+		return x.Ticket
+	}
+	return ""
+}
+
 type ReConnMsg struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Head          *ReConnMsgHead         `protobuf:"bytes,1,opt,name=Head,proto3" json:"Head,omitempty"`
@@ -676,54 +1008,655 @@ func (x *ReConnMsg) GetReConnMsgBody() []byte {
 	return nil
 }
 
-var File_message_proto protoreflect.FileDescriptor
+// Presence subscription message
+type PresenceSubMsg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserIDs       []uint64               `protobuf:"varint,1,rep,packed,name=UserIDs,proto3" json:"UserIDs,omitempty"`
+	Unsubscribe   bool                   `protobuf:"varint,2,opt,name=Unsubscribe,proto3" json:"Unsubscribe,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
 
-const file_message_proto_rawDesc = "" +
-	"\n" +
-	"\rmessage.proto\x12\amessage\"H\n" +
-	"\x06MsgCmd\x12$\n" +
-	"\x04Type\x18\x01 \x01(\x0e2\x10.message.CmdTypeR\x04Type\x12\x18\n" +
-	"\aPayload\x18\x02 \x01(\fR\aPayload\"M\n" +
-	"\x05UPMsg\x12&\n" +
-	"\x04Head\x18\x01 \x01(\v2\x12.message.UPMsgHeadR\x04Head\x12\x1c\n" +
-	"\tUPMsgBody\x18\x02 \x01(\fR\tUPMsgBody\"?\n" +
-	"\tUPMsgHead\x12\x1a\n" +
-	"\bClientID\x18\x01 \x01(\x04R\bClientID\x12\x16\n" +
-	"\x06ConnID\x18\x02 \x01(\x04R\x06ConnID\"W\n" +
-	"\aPushMsg\x12\x14\n" +
-	"\x05MsgID\x18\x01 \x01(\x04R\x05MsgID\x12\x1c\n" +
-	"\tSessionID\x18\x02 \x01(\x04R\tSessionID\x12\x18\n" +
-	"\aContent\x18\x03 \x01(\fR\aContent\"\xbc\x01\n" +
-	"\x06ACKMsg\x12\x12\n" +
-	"\x04Code\x18\x01 \x01(\rR\x04Code\x12\x10\n" +
-	"\x03Msg\x18\x02 \x01(\tR\x03Msg\x12$\n" +
-	"\x04Type\x18\x03 \x01(\x0e2\x10.message.CmdTypeR\x04Type\x12\x16\n" +
-	"\x06ConnID\x18\x04 \x01(\x04R\x06ConnID\x12\x1a\n" +
-	"\bClientID\x18\x05 \x01(\x04R\bClientID\x12\x1c\n" +
-	"\tSessionID\x18\x06 \x01(\x04R\tSessionID\x12\x14\n" +
-	"\x05MsgID\x18\a \x01(\x04R\x05MsgID\"*\n" +
-	"\fLoginMsgHead\x12\x1a\n" +
-	"\bDeviceID\x18\x01 \x01(\x04R\bDeviceID\"Y\n" +
-	"\bLoginMsg\x12)\n" +
-	"\x04Head\x18\x01 \x01(\v2\x15.message.LoginMsgHeadR\x04Head\x12\"\n" +
-	"\fLoginMsgBody\x18\x02 \x01(\fR\fLoginMsgBody\"\x12\n" +
-	"\x10HeartbeatMsgHead\"i\n" +
-	"\fHeartbeatMsg\x12-\n" +
-	"\x04Head\x18\x01 \x01(\v2\x19.message.HeartbeatMsgHeadR\x04Head\x12*\n" +
-	"\x10HeartbeatMsgBody\x18\x02 \x01(\fR\x10HeartbeatMsgBody\"'\n" +
-	"\rReConnMsgHead\x12\x16\n" +
-	"\x06ConnID\x18\x01 \x01(\x04R\x06ConnID\"]\n" +
-	"\tReConnMsg\x12*\n" +
-	"\x04Head\x18\x01 \x01(\v2\x16.message.ReConnMsgHeadR\x04Head\x12$\n" +
-	"\rReConnMsgBody\x18\x02 \x01(\fR\rReConnMsgBody*J\n" +
-	"\aCmdType\x12\t\n" +
-	"\x05Login\x10\x00\x12\r\n" +
-	"\tHeartbeat\x10\x01\x12\n" +
-	"\n" +
-	"\x06ReConn\x10\x02\x12\a\n" +
-	"\x03ACK\x10\x03\x12\x06\n" +
-	"\x02UP\x10\x04\x12\b\n" +
-	"\x04Push\x10\x05B\fZ\n" +
+func (x *PresenceSubMsg) Reset() {
+	*x = PresenceSubMsg{}
+	mi := &file_message_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PresenceSubMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PresenceSubMsg) ProtoMessage() {}
+
+func (x *PresenceSubMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PresenceSubMsg.ProtoReflect.Descriptor instead.
+func (*PresenceSubMsg) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *PresenceSubMsg) GetUserIDs() []uint64 {
+	if x != nil {
+		return x.UserIDs
+	}
+	return nil
+}
+
+func (x *PresenceSubMsg) GetUnsubscribe() bool {
+	if x != nil {
+		return x.Unsubscribe
+	}
+	return false
+}
+
+// Presence change notification
+type PresenceMsg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserID        uint64                 `protobuf:"varint,1,opt,name=UserID,proto3" json:"UserID,omitempty"`
+	DeviceID      uint64                 `protobuf:"varint,2,opt,name=DeviceID,proto3" json:"DeviceID,omitempty"`
+	Online        bool                   `protobuf:"varint,3,opt,name=Online,proto3" json:"Online,omitempty"` // user is online if any of the devices is online
+	DeviceOnline  bool                   `protobuf:"varint,4,opt,name=DeviceOnline,proto3" json:"DeviceOnline,omitempty"`
+	LastActive    int64                  `protobuf:"varint,5,opt,name=LastActive,proto3" json:"LastActive,omitempty"` // unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PresenceMsg) Reset() {
+	*x = PresenceMsg{}
+	mi := &file_message_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PresenceMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PresenceMsg) ProtoMessage() {}
+
+func (x *PresenceMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PresenceMsg.ProtoReflect.Descriptor instead.
+func (*PresenceMsg) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PresenceMsg) GetUserID() uint64 {
+	if x != nil {
+		return x.UserID
+	}
+	return 0
+}
+
+func (x *PresenceMsg) GetDeviceID() uint64 {
+	if x != nil {
+		return x.DeviceID
+	}
+	return 0
+}
+
+func (x *PresenceMsg) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+func (x *PresenceMsg) GetDeviceOnline() bool {
+	if x != nil {
+		return x.DeviceOnline
+	}
+	return false
+}
+
+func (x *PresenceMsg) GetLastActive() int64 {
+	if x != nil {
+		return x.LastActive
+	}
+	return 0
+}
+
+// Group message, the same structure is used for the request and the reply
+type GroupMsg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Op            GroupOp                `protobuf:"varint,1,opt,name=Op,proto3,enum=message.GroupOp" json:"Op,omitempty"`
+	GroupID       uint64                 `protobuf:"varint,2,opt,name=GroupID,proto3" json:"GroupID,omitempty"`
+	UserIDs       []uint64               `protobuf:"varint,3,rep,packed,name=UserIDs,proto3" json:"UserIDs,omitempty"`
+	MsgID         uint64                 `protobuf:"varint,4,opt,name=MsgID,proto3" json:"MsgID,omitempty"`
+	Code          uint32                 `protobuf:"varint,5,opt,name=Code,proto3" json:"Code,omitempty"`
+	Msg           string                 `protobuf:"bytes,6,opt,name=Msg,proto3" json:"Msg,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GroupMsg) Reset() {
+	*x = GroupMsg{}
+	mi := &file_message_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupMsg) ProtoMessage() {}
+
+func (x *GroupMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupMsg.ProtoReflect.Descriptor instead.
+func (*GroupMsg) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GroupMsg) GetOp() GroupOp {
+	if x != nil {
+		return x.Op
+	}
+	return GroupOp_Create
+}
+
+func (x *GroupMsg) GetGroupID() uint64 {
+	if x != nil {
+		return x.GroupID
+	}
+	return 0
+}
+
+func (x *GroupMsg) GetUserIDs() []uint64 {
+	if x != nil {
+		return x.UserIDs
+	}
+	return nil
+}
+
+func (x *GroupMsg) GetMsgID() uint64 {
+	if x != nil {
+		return x.MsgID
+	}
+	return 0
+}
+
+func (x *GroupMsg) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *GroupMsg) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+// Delivery or read receipt, sent by the receiver and routed back to every device of the sender.
+// Receipts are cumulative, every message of the session up to MsgID is covered.
+type ReceiptMsg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SessionID     uint64                 `protobuf:"varint,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"` // group id, 0 for direct messages
+	MsgID         uint64                 `protobuf:"varint,2,opt,name=MsgID,proto3" json:"MsgID,omitempty"`
+	FromUserID    uint64                 `protobuf:"varint,3,opt,name=FromUserID,proto3" json:"FromUserID,omitempty"`     // receiver of the message, filled by the server
+	FromDeviceID  uint64                 `protobuf:"varint,4,opt,name=FromDeviceID,proto3" json:"FromDeviceID,omitempty"` // filled by the server
+	ToUserID      uint64                 `protobuf:"varint,5,opt,name=ToUserID,proto3" json:"ToUserID,omitempty"`         // sender of the message
+	Timestamp     int64                  `protobuf:"varint,6,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`       // unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReceiptMsg) Reset() {
+	*x = ReceiptMsg{}
+	mi := &file_message_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReceiptMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReceiptMsg) ProtoMessage() {}
+
+func (x *ReceiptMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReceiptMsg.ProtoReflect.Descriptor instead.
+func (*ReceiptMsg) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ReceiptMsg) GetSessionID() uint64 {
+	if x != nil {
+		return x.SessionID
+	}
+	return 0
+}
+
+func (x *ReceiptMsg) GetMsgID() uint64 {
+	if x != nil {
+		return x.MsgID
+	}
+	return 0
+}
+
+func (x *ReceiptMsg) GetFromUserID() uint64 {
+	if x != nil {
+		return x.FromUserID
+	}
+	return 0
+}
+
+func (x *ReceiptMsg) GetFromDeviceID() uint64 {
+	if x != nil {
+		return x.FromDeviceID
+	}
+	return 0
+}
+
+func (x *ReceiptMsg) GetToUserID() uint64 {
+	if x != nil {
+		return x.ToUserID
+	}
+	return 0
+}
+
+func (x *ReceiptMsg) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// Ephemeral signal such as typing indicators, call ringing or recording events.
+// Signals are fire-and-forget, they are only pushed to the current connections of the target.
+type SignalMsg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ToUserID      uint64                 `protobuf:"varint,1,opt,name=ToUserID,proto3" json:"ToUserID,omitempty"`
+	GroupID       uint64                 `protobuf:"varint,2,opt,name=GroupID,proto3" json:"GroupID,omitempty"` // signal every member of the group instead of a single user
+	Event         string                 `protobuf:"bytes,3,opt,name=Event,proto3" json:"Event,omitempty"`      // e.g. typing, ringing, recording
+	Payload       []byte                 `protobuf:"bytes,4,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	FromUserID    uint64                 `protobuf:"varint,5,opt,name=FromUserID,proto3" json:"FromUserID,omitempty"`     // filled by the server
+	FromDeviceID  uint64                 `protobuf:"varint,6,opt,name=FromDeviceID,proto3" json:"FromDeviceID,omitempty"` // filled by the server
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SignalMsg) Reset() {
+	*x = SignalMsg{}
+	mi := &file_message_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignalMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignalMsg) ProtoMessage() {}
+
+func (x *SignalMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignalMsg.ProtoReflect.Descriptor instead.
+func (*SignalMsg) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *SignalMsg) GetToUserID() uint64 {
+	if x != nil {
+		return x.ToUserID
+	}
+	return 0
+}
+
+func (x *SignalMsg) GetGroupID() uint64 {
+	if x != nil {
+		return x.GroupID
+	}
+	return 0
+}
+
+func (x *SignalMsg) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *SignalMsg) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *SignalMsg) GetFromUserID() uint64 {
+	if x != nil {
+		return x.FromUserID
+	}
+	return 0
+}
+
+func (x *SignalMsg) GetFromDeviceID() uint64 {
+	if x != nil {
+		return x.FromDeviceID
+	}
+	return 0
+}
+
+// Modify message, the same structure is used for the request, the reply and the event pushed to the recipients.
+// The modified message is referenced by (SessionID, MsgID).
+type ModifyMsg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Op            ModifyOp               `protobuf:"varint,1,opt,name=Op,proto3,enum=message.ModifyOp" json:"Op,omitempty"`
+	SessionID     uint64                 `protobuf:"varint,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"` // group id, 0 for direct messages
+	MsgID         uint64                 `protobuf:"varint,3,opt,name=MsgID,proto3" json:"MsgID,omitempty"`
+	Content       []byte                 `protobuf:"bytes,4,opt,name=Content,proto3" json:"Content,omitempty"`        // new content of an edit
+	FromUserID    uint64                 `protobuf:"varint,5,opt,name=FromUserID,proto3" json:"FromUserID,omitempty"` // sender of the message, filled by the server
+	Code          uint32                 `protobuf:"varint,6,opt,name=Code,proto3" json:"Code,omitempty"`
+	Msg           string                 `protobuf:"bytes,7,opt,name=Msg,proto3" json:"Msg,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,8,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"` // unix milliseconds
+	ToUserID      uint64                 `protobuf:"varint,9,opt,name=ToUserID,proto3" json:"ToUserID,omitempty"`   // receiver of a direct message, the msgID is only unique in the session with the sender
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ModifyMsg) Reset() {
+	*x = ModifyMsg{}
+	mi := &file_message_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModifyMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModifyMsg) ProtoMessage() {}
+
+func (x *ModifyMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModifyMsg.ProtoReflect.Descriptor instead.
+func (*ModifyMsg) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ModifyMsg) GetOp() ModifyOp {
+	if x != nil {
+		return x.Op
+	}
+	return ModifyOp_Recall
+}
+
+func (x *ModifyMsg) GetSessionID() uint64 {
+	if x != nil {
+		return x.SessionID
+	}
+	return 0
+}
+
+func (x *ModifyMsg) GetMsgID() uint64 {
+	if x != nil {
+		return x.MsgID
+	}
+	return 0
+}
+
+func (x *ModifyMsg) GetContent() []byte {
+	if x != nil {
+		return x.Content
+	}
+	return nil
+}
+
+func (x *ModifyMsg) GetFromUserID() uint64 {
+	if x != nil {
+		return x.FromUserID
+	}
+	return 0
+}
+
+func (x *ModifyMsg) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *ModifyMsg) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+func (x *ModifyMsg) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *ModifyMsg) GetToUserID() uint64 {
+	if x != nil {
+		return x.ToUserID
+	}
+	return 0
+}
+
+var File_message_proto protoreflect.FileDescriptor
+
+const file_message_proto_rawDesc = "" +
+	"\n" +
+	"\rmessage.proto\x12\amessage\"H\n" +
+	"\x06MsgCmd\x12$\n" +
+	"\x04Type\x18\x01 \x01(\x0e2\x10.message.CmdTypeR\x04Type\x12\x18\n" +
+	"\aPayload\x18\x02 \x01(\fR\aPayload\"M\n" +
+	"\x05UPMsg\x12&\n" +
+	"\x04Head\x18\x01 \x01(\v2\x12.message.UPMsgHeadR\x04Head\x12\x1c\n" +
+	"\tUPMsgBody\x18\x02 \x01(\fR\tUPMsgBody\"u\n" +
+	"\tUPMsgHead\x12\x1a\n" +
+	"\bClientID\x18\x01 \x01(\x04R\bClientID\x12\x16\n" +
+	"\x06ConnID\x18\x02 \x01(\x04R\x06ConnID\x12\x1a\n" +
+	"\bToUserID\x18\x03 \x01(\x04R\bToUserID\x12\x18\n" +
+	"\aGroupID\x18\x04 \x01(\x04R\aGroupID\"\x99\x02\n" +
+	"\aPushMsg\x12\x14\n" +
+	"\x05MsgID\x18\x01 \x01(\x04R\x05MsgID\x12\x1c\n" +
+	"\tSessionID\x18\x02 \x01(\x04R\tSessionID\x12\x18\n" +
+	"\aContent\x18\x03 \x01(\fR\aContent\x12\x1e\n" +
+	"\n" +
+	"FromUserID\x18\x04 \x01(\x04R\n" +
+	"FromUserID\x12\"\n" +
+	"\fFromDeviceID\x18\x05 \x01(\x04R\fFromDeviceID\x12\x1a\n" +
+	"\bSentByMe\x18\x06 \x01(\bR\bSentByMe\x12\x16\n" +
+	"\x06Edited\x18\a \x01(\bR\x06Edited\x12\x1a\n" +
+	"\bRecalled\x18\b \x01(\bR\bRecalled\x12\x10\n" +
+	"\x03Seq\x18\t \x01(\x04R\x03Seq\x12\x1a\n" +
+	"\bToUserID\x18\n" +
+	" \x01(\x04R\bToUserID\"\xd2\x02\n" +
+	"\x06ACKMsg\x12\x12\n" +
+	"\x04Code\x18\x01 \x01(\rR\x04Code\x12\x10\n" +
+	"\x03Msg\x18\x02 \x01(\tR\x03Msg\x12$\n" +
+	"\x04Type\x18\x03 \x01(\x0e2\x10.message.CmdTypeR\x04Type\x12\x16\n" +
+	"\x06ConnID\x18\x04 \x01(\x04R\x06ConnID\x12\x1a\n" +
+	"\bClientID\x18\x05 \x01(\x04R\bClientID\x12\x1c\n" +
+	"\tSessionID\x18\x06 \x01(\x04R\tSessionID\x12\x14\n" +
+	"\x05MsgID\x18\a \x01(\x04R\x05MsgID\x12,\n" +
+	"\x11HeartbeatInterval\x18\b \x01(\rR\x11HeartbeatInterval\x12*\n" +
+	"\x10ExpectedClientID\x18\t \x01(\x04R\x10ExpectedClientID\x12\x1e\n" +
+	"\n" +
+	"FromUserID\x18\n" +
+	" \x01(\x04R\n" +
+	"FromUserID\x12\x1a\n" +
+	"\bToUserID\x18\v \x01(\x04R\bToUserID\"\xaa\x01\n" +
+	"\fLoginMsgHead\x12\x1a\n" +
+	"\bDeviceID\x18\x01 \x01(\x04R\bDeviceID\x12\x16\n" +
+	"\x06UserID\x18\x02 \x01(\x04R\x06UserID\x12,\n" +
+	"\x11HeartbeatInterval\x18\x03 \x01(\rR\x11HeartbeatInterval\x12 \n" +
+	"\vNetworkType\x18\x04 \x01(\tR\vNetworkType\x12\x16\n" +
+	"\x06Ticket\x18\x05 \x01(\tR\x06Ticket\"Y\n" +
+	"\bLoginMsg\x12)\n" +
+	"\x04Head\x18\x01 \x01(\v2\x15.message.LoginMsgHeadR\x04Head\x12\"\n" +
+	"\fLoginMsgBody\x18\x02 \x01(\fR\fLoginMsgBody\"\x12\n" +
+	"\x10HeartbeatMsgHead\"i\n" +
+	"\fHeartbeatMsg\x12-\n" +
+	"\x04Head\x18\x01 \x01(\v2\x19.message.HeartbeatMsgHeadR\x04Head\x12*\n" +
+	"\x10HeartbeatMsgBody\x18\x02 \x01(\fR\x10HeartbeatMsgBody\"[\n" +
+	"\rReConnMsgHead\x12\x16\n" +
+	"\x06ConnID\x18\x01 \x01(\x04R\x06ConnID\x12\x1a\n" +
+	"\bDeviceID\x18\x02 \x01(\x04R\bDeviceID\x12\x16\n" +
+	"\x06Ticket\x18\x03 \x01(\tR\x06Ticket\"]\n" +
+	"\tReConnMsg\x12*\n" +
+	"\x04Head\x18\x01 \x01(\v2\x16.message.ReConnMsgHeadR\x04Head\x12$\n" +
+	"\rReConnMsgBody\x18\x02 \x01(\fR\rReConnMsgBody\"L\n" +
+	"\x0ePresenceSubMsg\x12\x18\n" +
+	"\aUserIDs\x18\x01 \x03(\x04R\aUserIDs\x12 \n" +
+	"\vUnsubscribe\x18\x02 \x01(\bR\vUnsubscribe\"\x9d\x01\n" +
+	"\vPresenceMsg\x12\x16\n" +
+	"\x06UserID\x18\x01 \x01(\x04R\x06UserID\x12\x1a\n" +
+	"\bDeviceID\x18\x02 \x01(\x04R\bDeviceID\x12\x16\n" +
+	"\x06Online\x18\x03 \x01(\bR\x06Online\x12\"\n" +
+	"\fDeviceOnline\x18\x04 \x01(\bR\fDeviceOnline\x12\x1e\n" +
+	"\n" +
+	"LastActive\x18\x05 \x01(\x03R\n" +
+	"LastActive\"\x9c\x01\n" +
+	"\bGroupMsg\x12 \n" +
+	"\x02Op\x18\x01 \x01(\x0e2\x10.message.GroupOpR\x02Op\x12\x18\n" +
+	"\aGroupID\x18\x02 \x01(\x04R\aGroupID\x12\x18\n" +
+	"\aUserIDs\x18\x03 \x03(\x04R\aUserIDs\x12\x14\n" +
+	"\x05MsgID\x18\x04 \x01(\x04R\x05MsgID\x12\x12\n" +
+	"\x04Code\x18\x05 \x01(\rR\x04Code\x12\x10\n" +
+	"\x03Msg\x18\x06 \x01(\tR\x03Msg\"\xbe\x01\n" +
+	"\n" +
+	"ReceiptMsg\x12\x1c\n" +
+	"\tSessionID\x18\x01 \x01(\x04R\tSessionID\x12\x14\n" +
+	"\x05MsgID\x18\x02 \x01(\x04R\x05MsgID\x12\x1e\n" +
+	"\n" +
+	"FromUserID\x18\x03 \x01(\x04R\n" +
+	"FromUserID\x12\"\n" +
+	"\fFromDeviceID\x18\x04 \x01(\x04R\fFromDeviceID\x12\x1a\n" +
+	"\bToUserID\x18\x05 \x01(\x04R\bToUserID\x12\x1c\n" +
+	"\tTimestamp\x18\x06 \x01(\x03R\tTimestamp\"\xb5\x01\n" +
+	"\tSignalMsg\x12\x1a\n" +
+	"\bToUserID\x18\x01 \x01(\x04R\bToUserID\x12\x18\n" +
+	"\aGroupID\x18\x02 \x01(\x04R\aGroupID\x12\x14\n" +
+	"\x05Event\x18\x03 \x01(\tR\x05Event\x12\x18\n" +
+	"\aPayload\x18\x04 \x01(\fR\aPayload\x12\x1e\n" +
+	"\n" +
+	"FromUserID\x18\x05 \x01(\x04R\n" +
+	"FromUserID\x12\"\n" +
+	"\fFromDeviceID\x18\x06 \x01(\x04R\fFromDeviceID\"\xfc\x01\n" +
+	"\tModifyMsg\x12!\n" +
+	"\x02Op\x18\x01 \x01(\x0e2\x11.message.ModifyOpR\x02Op\x12\x1c\n" +
+	"\tSessionID\x18\x02 \x01(\x04R\tSessionID\x12\x14\n" +
+	"\x05MsgID\x18\x03 \x01(\x04R\x05MsgID\x12\x18\n" +
+	"\aContent\x18\x04 \x01(\fR\aContent\x12\x1e\n" +
+	"\n" +
+	"FromUserID\x18\x05 \x01(\x04R\n" +
+	"FromUserID\x12\x12\n" +
+	"\x04Code\x18\x06 \x01(\rR\x04Code\x12\x10\n" +
+	"\x03Msg\x18\a \x01(\tR\x03Msg\x12\x1c\n" +
+	"\tTimestamp\x18\b \x01(\x03R\tTimestamp\x12\x1a\n" +
+	"\bToUserID\x18\t \x01(\x04R\bToUserID*\xa5\x01\n" +
+	"\aCmdType\x12\t\n" +
+	"\x05Login\x10\x00\x12\r\n" +
+	"\tHeartbeat\x10\x01\x12\n" +
+	"\n" +
+	"\x06ReConn\x10\x02\x12\a\n" +
+	"\x03ACK\x10\x03\x12\x06\n" +
+	"\x02UP\x10\x04\x12\b\n" +
+	"\x04Push\x10\x05\x12\x0f\n" +
+	"\vPresenceSub\x10\x06\x12\f\n" +
+	"\bPresence\x10\a\x12\t\n" +
+	"\x05Group\x10\b\x12\r\n" +
+	"\tDelivered\x10\t\x12\b\n" +
+	"\x04Read\x10\n" +
+	"\x12\n" +
+	"\n" +
+	"\x06Signal\x10\v\x12\n" +
+	"\n" +
+	"\x06Modify\x10\f*K\n" +
+	"\aACKCode\x12\x06\n" +
+	"\x02OK\x10\x00\x12\x0e\n" +
+	"\n" +
+	"OutOfOrder\x10\x01\x12\n" +
+	"\n" +
+	"\x06Failed\x10\x02\x12\f\n" +
+	"\bRejected\x10\x03\x12\x0e\n" +
+	"\n" +
+	"InProgress\x10\x04*A\n" +
+	"\aGroupOp\x12\n" +
+	"\n" +
+	"\x06Create\x10\x00\x12\b\n" +
+	"\x04Join\x10\x01\x12\t\n" +
+	"\x05Leave\x10\x02\x12\v\n" +
+	"\aMembers\x10\x03\x12\b\n" +
+	"\x04Sync\x10\x04* \n" +
+	"\bModifyOp\x12\n" +
+	"\n" +
+	"\x06Recall\x10\x00\x12\b\n" +
+	"\x04Edit\x10\x01B\fZ\n" +
 	"./;messageb\x06proto3"
 
 var (
@@ -738,34 +1671,45 @@ func file_message_proto_rawDescGZIP() []byte {
 	return file_message_proto_rawDescData
 }
 
-var file_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_message_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
+var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_message_proto_goTypes = []any{
 	(CmdType)(0),             // 0: message.CmdType
-	(*MsgCmd)(nil),           // 1: message.MsgCmd
-	(*UPMsg)(nil),            // 2: message.UPMsg
-	(*UPMsgHead)(nil),        // 3: message.UPMsgHead
-	(*PushMsg)(nil),          // 4: message.PushMsg
-	(*ACKMsg)(nil),           // 5: message.ACKMsg
-	(*LoginMsgHead)(nil),     // 6: message.LoginMsgHead
-	(*LoginMsg)(nil),         // 7: message.LoginMsg
-	(*HeartbeatMsgHead)(nil), // 8: message.HeartbeatMsgHead
-	(*HeartbeatMsg)(nil),     // 9: message.HeartbeatMsg
-	(*ReConnMsgHead)(nil),    // 10: message.ReConnMsgHead
-	(*ReConnMsg)(nil),        // 11: message.ReConnMsg
+	(ACKCode)(0),             // 1: message.ACKCode
+	(GroupOp)(0),             // 2: message.GroupOp
+	(ModifyOp)(0),            // 3: message.ModifyOp
+	(*MsgCmd)(nil),           // 4: message.MsgCmd
+	(*UPMsg)(nil),            // 5: message.UPMsg
+	(*UPMsgHead)(nil),        // 6: message.UPMsgHead
+	(*PushMsg)(nil),          // 7: message.PushMsg
+	(*ACKMsg)(nil),           // 8: message.ACKMsg
+	(*LoginMsgHead)(nil),     // 9: message.LoginMsgHead
+	(*LoginMsg)(nil),         // 10: message.LoginMsg
+	(*HeartbeatMsgHead)(nil), // 11: message.HeartbeatMsgHead
+	(*HeartbeatMsg)(nil),     // 12: message.HeartbeatMsg
+	(*ReConnMsgHead)(nil),    // 13: message.ReConnMsgHead
+	(*ReConnMsg)(nil),        // 14: message.ReConnMsg
+	(*PresenceSubMsg)(nil),   // 15: message.PresenceSubMsg
+	(*PresenceMsg)(nil),      // 16: message.PresenceMsg
+	(*GroupMsg)(nil),         // 17: message.GroupMsg
+	(*ReceiptMsg)(nil),       // 18: message.ReceiptMsg
+	(*SignalMsg)(nil),        // 19: message.SignalMsg
+	(*ModifyMsg)(nil),        // 20: message.ModifyMsg
 }
 var file_message_proto_depIdxs = []int32{
 	0,  // 0: message.MsgCmd.Type:type_name -> message.CmdType
-	3,  // 1: message.UPMsg.Head:type_name -> message.UPMsgHead
+	6,  // 1: message.UPMsg.Head:type_name -> message.UPMsgHead
 	0,  // 2: message.ACKMsg.Type:type_name -> message.CmdType
-	6,  // 3: message.LoginMsg.Head:type_name -> message.LoginMsgHead
-	8,  // 4: message.HeartbeatMsg.Head:type_name -> message.HeartbeatMsgHead
-	10, // 5: message.ReConnMsg.Head:type_name -> message.ReConnMsgHead
-	6,  // [6:6] is the sub-list for method output_type
-	6,  // [6:6] is the sub-list for method input_type
-	6,  // [6:6] is the sub-list for extension type_name
-	6,  // [6:6] is the sub-list for extension extendee
-	0,  // [0:6] is the sub-list for field type_name
+	9,  // 3: message.LoginMsg.Head:type_name -> message.LoginMsgHead
+	11, // 4: message.HeartbeatMsg.Head:type_name -> message.HeartbeatMsgHead
+	13, // 5: message.ReConnMsg.Head:type_name -> message.ReConnMsgHead
+	2,  // 6: message.GroupMsg.Op:type_name -> message.GroupOp
+	3,  // 7: message.ModifyMsg.Op:type_name -> message.ModifyOp
+	8,  // [8:8] is the sub-list for method output_type
+	8,  // [8:8] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_message_proto_init() }
@@ -778,8 +1722,8 @@ func file_message_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_message_proto_rawDesc), len(file_message_proto_rawDesc)),
-			NumEnums:      1,
-			NumMessages:   11,
+			NumEnums:      4,
+			NumMessages:   17,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -12,6 +12,7 @@ import (
 
 const (
 	gatewayRotuerKey = "gateway_rotuer_%d"
+	userDevicesKey   = "user_devices_{%d}" // set of deviceIDs owned by the user
 	ttl7D            = 7 * 24 * 60 * 60
 )
 
@@ -20,22 +21,27 @@ type Record struct {
 	ConndID  uint64
 }
 
-func Init(ctx context.Context) {
-	cache.InitRedis(ctx)
+// Table routes a device to the gateway connection holding it
+type Table struct {
+	store cache.Store
 }
 
-func AddRecord(ctx context.Context, did uint64, endpoint string, conndID uint64) error {
+func NewTable(store cache.Store) *Table {
+	return &Table{store: store}
+}
+
+func (t *Table) AddRecord(ctx context.Context, did uint64, endpoint string, conndID uint64) error {
 	key := fmt.Sprintf(gatewayRotuerKey, did)
 	value := fmt.Sprintf("%s-%d", endpoint, conndID)
-	return cache.SetString(ctx, key, value, ttl7D*time.Second)
+	return t.store.SetString(ctx, key, value, ttl7D*time.Second)
 }
-func DelRecord(ctx context.Context, did uint64) error {
+func (t *Table) DelRecord(ctx context.Context, did uint64) error {
 	key := fmt.Sprintf(gatewayRotuerKey, did)
-	return cache.Del(ctx, key)
+	return t.store.Del(ctx, key)
 }
-func QueryRecord(ctx context.Context, did uint64) (*Record, error) {
+func (t *Table) QueryRecord(ctx context.Context, did uint64) (*Record, error) {
 	key := fmt.Sprintf(gatewayRotuerKey, did)
-	data, err := cache.GetString(ctx, key)
+	data, err := t.store.GetString(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -49,3 +55,31 @@ func QueryRecord(ctx context.Context, did uint64) (*Record, error) {
 		ConndID:  conndID,
 	}, nil
 }
+
+func (t *Table) AddUserDevice(ctx context.Context, uid, did uint64) error {
+	key := fmt.Sprintf(userDevicesKey, uid)
+	if err := t.store.SADD(ctx, key, did); err != nil {
+		return err
+	}
+	return t.store.Expire(ctx, key, ttl7D*time.Second)
+}
+func (t *Table) DelUserDevice(ctx context.Context, uid, did uint64) error {
+	key := fmt.Sprintf(userDevicesKey, uid)
+	return t.store.SREM(ctx, key, did)
+}
+func (t *Table) QueryUserDevices(ctx context.Context, uid uint64) ([]uint64, error) {
+	key := fmt.Sprintf(userDevicesKey, uid)
+	members, err := t.store.SmembersStrSlice(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	dids := make([]uint64, 0, len(members))
+	for _, member := range members {
+		did, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		dids = append(dids, did)
+	}
+	return dids, nil
+}
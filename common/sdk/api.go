@@ -3,6 +3,8 @@ package sdk
 import (
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,18 +21,51 @@ const (
 	MsgTypeReConn    = "reConn"
 	MsgTypeHeartbeat = "heartbeat"
 	MsgLogin         = "loginMsg"
+	MsgTypePresence  = "presence"
+	MsgTypeGroup     = "group"
+	MsgTypeDelivered = "delivered"
+	MsgTypeRead      = "read"
+	MsgTypeSignal    = "signal"
+	MsgTypeRecall    = "recall"
+	MsgTypeEdit      = "edit"
 )
 
 type Chat struct {
-	Nick             string
-	UserID           string
-	SessionID        string
-	conn             *connect
-	closeChan        chan struct{}
-	MsgClientIDTable map[string]uint64
+	Nick              string
+	UserID            string
+	SessionID         string
+	conn              *connect
+	closeChan         chan struct{}
+	MsgClientIDTable  map[string]uint64
+	pendingUpMsgs     map[string]map[uint64]*message.UPMsg // the up-stream messages not acked yet, by connID and ClientID
+	presenceSubs      map[uint64]struct{} // resubscribed after re-connection
+	heartbeatInterval time.Duration       // desired heartbeat interval, 0 means server default
+	networkType       string
+	deviceID          uint64
+	ticket            string // issued by ipconf for the device, presented on login and re-connection
 	sync.RWMutex
 }
 
+// ChatOption configures the chat before login
+type ChatOption func(chat *Chat)
+
+// WithHeartbeat asks the server for a heartbeat interval, the server may clamp it.
+// Mobile clients should ask for a long interval, or only report the network type and let the server decide.
+func WithHeartbeat(interval time.Duration, networkType string) ChatOption {
+	return func(chat *Chat) {
+		chat.heartbeatInterval = interval
+		chat.networkType = networkType
+	}
+}
+
+// WithTicket logs in as the device with the ticket returned by ipconf, required by the gateways enforcing tickets
+func WithTicket(deviceID uint64, ticket string) ChatOption {
+	return func(chat *Chat) {
+		chat.deviceID = deviceID
+		chat.ticket = ticket
+	}
+}
+
 type Message struct {
 	Type       string
 	Name       string
@@ -38,9 +73,12 @@ type Message struct {
 	ToUserID   string
 	Content    string
 	Session    string
+	SentByMe   bool   // synced from another device of the current user
+	GroupID    string // set to send the message to a group session
+	MsgID      uint64 // assigned by the server, referenced by receipts
 }
 
-func NewChat(ip net.IP, port int, nick, userID, sessionID string) *Chat {
+func NewChat(ip net.IP, port int, nick, userID, sessionID string, opts ...ChatOption) *Chat {
 	chat := &Chat{
 		Nick:             nick,
 		UserID:           userID,
@@ -48,6 +86,12 @@ func NewChat(ip net.IP, port int, nick, userID, sessionID string) *Chat {
 		conn:             newConnet(ip, port),
 		closeChan:        make(chan struct{}),
 		MsgClientIDTable: make(map[string]uint64),
+		pendingUpMsgs:    make(map[string]map[uint64]*message.UPMsg),
+		presenceSubs:     make(map[uint64]struct{}),
+		deviceID:         123,
+	}
+	for _, opt := range opts {
+		opt(chat)
 	}
 	go chat.loop()
 	chat.login()
@@ -57,13 +101,28 @@ func NewChat(ip net.IP, port int, nick, userID, sessionID string) *Chat {
 func (chat *Chat) Send(msg *Message) {
 	data, _ := json.Marshal(msg)
 	key := fmt.Sprintf("%d", chat.conn.connID)
+	toUserID, _ := strconv.ParseUint(msg.ToUserID, 10, 64)
+	groupID, _ := strconv.ParseUint(msg.GroupID, 10, 64)
 	upMsg := &message.UPMsg{
 		Head: &message.UPMsgHead{
-			ClientID: chat.getClientID(key),
 			ConnID:   chat.conn.connID,
+			ToUserID: toUserID,
+			GroupID:  groupID,
 		},
 		UPMsgBody: data,
 	}
+	chat.Lock()
+	defer chat.Unlock()
+	upMsg.Head.ClientID = chat.MsgClientIDTable[key]
+	chat.MsgClientIDTable[key] = upMsg.Head.ClientID + 1
+	if chat.pendingUpMsgs[key] == nil {
+		chat.pendingUpMsgs[key] = make(map[uint64]*message.UPMsg)
+	}
+	chat.pendingUpMsgs[key][upMsg.Head.ClientID] = upMsg
+	chat.sendUpMsg(upMsg)
+}
+
+func (chat *Chat) sendUpMsg(upMsg *message.UPMsg) {
 	palyload, _ := proto.Marshal(upMsg)
 	chat.conn.send(message.CmdType_UP, palyload)
 }
@@ -84,11 +143,108 @@ func (chat *Chat) Close() {
 	close(chat.conn.sendChan)
 }
 
+// SetTicket replaces the ticket before ReConn, the tickets expire shortly after ipconf issues them
+func (chat *Chat) SetTicket(ticket string) {
+	chat.Lock()
+	defer chat.Unlock()
+	chat.ticket = ticket
+}
+
 func (chat *Chat) ReConn() {
 	chat.Lock()
 	defer chat.Unlock()
 	chat.conn.reConn()
 	chat.reConn()
+	// subscriptions belong to the connection, so they are restored on the new one
+	userIDs := make([]uint64, 0, len(chat.presenceSubs))
+	for userID := range chat.presenceSubs {
+		userIDs = append(userIDs, userID)
+	}
+	if len(userIDs) > 0 {
+		chat.presenceSub(userIDs, false)
+	}
+}
+
+// SubscribePresence receive online status changes of the given users
+func (chat *Chat) SubscribePresence(userIDs ...uint64) {
+	chat.Lock()
+	defer chat.Unlock()
+	for _, userID := range userIDs {
+		chat.presenceSubs[userID] = struct{}{}
+	}
+	chat.presenceSub(userIDs, false)
+}
+
+// UnsubscribePresence stop receiving online status changes of the given users
+func (chat *Chat) UnsubscribePresence(userIDs ...uint64) {
+	chat.Lock()
+	defer chat.Unlock()
+	for _, userID := range userIDs {
+		delete(chat.presenceSubs, userID)
+	}
+	chat.presenceSub(userIDs, true)
+}
+
+// CreateGroup create a group session with the given members, the group id is returned in a group message
+func (chat *Chat) CreateGroup(userIDs ...uint64) {
+	chat.conn.sendGroupMsg(&message.GroupMsg{Op: message.GroupOp_Create, UserIDs: userIDs})
+}
+
+// JoinGroup join the group session
+func (chat *Chat) JoinGroup(groupID uint64) {
+	chat.conn.sendGroupMsg(&message.GroupMsg{Op: message.GroupOp_Join, GroupID: groupID})
+}
+
+// LeaveGroup leave the group session
+func (chat *Chat) LeaveGroup(groupID uint64) {
+	chat.conn.sendGroupMsg(&message.GroupMsg{Op: message.GroupOp_Leave, GroupID: groupID})
+}
+
+// GroupMembers query the members of the group session
+func (chat *Chat) GroupMembers(groupID uint64) {
+	chat.conn.sendGroupMsg(&message.GroupMsg{Op: message.GroupOp_Members, GroupID: groupID})
+}
+
+// SyncGroup pull the group messages after the given msgID
+func (chat *Chat) SyncGroup(groupID, afterMsgID uint64) {
+	chat.conn.sendGroupMsg(&message.GroupMsg{Op: message.GroupOp_Sync, GroupID: groupID, MsgID: afterMsgID})
+}
+
+// MarkRead tell the sender that the message and every message before it in the session have been read
+func (chat *Chat) MarkRead(msg *Message) {
+	fromUserID, _ := strconv.ParseUint(msg.FormUserID, 10, 64)
+	groupID, _ := strconv.ParseUint(msg.GroupID, 10, 64)
+	if msg.SentByMe || fromUserID == 0 || msg.MsgID == 0 {
+		return
+	}
+	chat.conn.sendReceipt(message.CmdType_Read, groupID, msg.MsgID, fromUserID)
+}
+
+// SendSignal send an ephemeral event such as typing to a user, or to a group when groupID is not 0.
+// Signals are best effort, they are dropped if the target is offline or the sender sends too fast.
+func (chat *Chat) SendSignal(toUserID, groupID uint64, event string, payload []byte) {
+	palyload, err := proto.Marshal(&message.SignalMsg{
+		ToUserID: toUserID,
+		GroupID:  groupID,
+		Event:    event,
+		Payload:  payload,
+	})
+	if err != nil {
+		panic(err)
+	}
+	chat.conn.send(message.CmdType_Signal, palyload)
+}
+
+// Recall recall a message sent by the current user, it is only allowed within the window configured on the server.
+// The message is in the group session, or in the direct session with toUserID when sessionID is 0
+func (chat *Chat) Recall(sessionID, toUserID, msgID uint64) {
+	chat.conn.sendModify(&message.ModifyMsg{Op: message.ModifyOp_Recall, SessionID: sessionID, ToUserID: toUserID, MsgID: msgID})
+}
+
+// Edit replace the content of a message sent by the current user
+func (chat *Chat) Edit(sessionID, toUserID, msgID uint64, msg *Message) {
+	data, _ := json.Marshal(msg)
+	chat.conn.sendModify(&message.ModifyMsg{Op: message.ModifyOp_Edit, SessionID: sessionID, ToUserID: toUserID, MsgID: msgID, Content: data})
 }
 
 // Recv receive message
@@ -116,30 +272,81 @@ Loop:
 			switch mc.Type {
 			case message.CmdType_ACK:
 				msg = handAckMsg(chat.conn, mc.Payload)
+				chat.handUpAck(mc.Payload)
 			case message.CmdType_Push:
 				msg = handPushMsg(chat.conn, mc.Payload)
-
+			case message.CmdType_Presence:
+				msg = handPresenceMsg(chat.conn, mc.Payload)
+			case message.CmdType_Group:
+				msg = handGroupMsg(chat.conn, mc.Payload)
+			case message.CmdType_Delivered, message.CmdType_Read:
+				msg = handReceiptMsg(mc.Type, mc.Payload)
+			case message.CmdType_Signal:
+				msg = handSignalMsg(mc.Payload)
+			case message.CmdType_Modify:
+				msg = handModifyMsg(mc.Payload)
+			}
+			if msg != nil {
+				chat.conn.recvChan <- msg
 			}
-			chat.conn.recvChan <- msg
 		}
 	}
 }
 
-func (chat *Chat) getClientID(sessionID string) uint64 {
+// an accepted or rejected message is done. Otherwise the server expects an earlier ClientID,
+// the messages from it on are lost or were not delivered, so they are renumbered from the expected one and resent.
+// A duplicate of a message in progress is acked later.
+func (chat *Chat) handUpAck(data []byte) {
+	ackMsg := &message.ACKMsg{}
+	proto.Unmarshal(data, ackMsg)
+	if ackMsg.Type != message.CmdType_UP {
+		return
+	}
 	chat.Lock()
 	defer chat.Unlock()
-	var res uint64
-	if id, ok := chat.MsgClientIDTable[sessionID]; ok {
-		res = id
+	key := fmt.Sprintf("%d", ackMsg.ConnID)
+	switch ackMsg.Code {
+	case uint32(message.ACKCode_OK), uint32(message.ACKCode_Rejected):
+		delete(chat.pendingUpMsgs[key], ackMsg.ClientID)
+	case uint32(message.ACKCode_OutOfOrder), uint32(message.ACKCode_Failed):
+		chat.resendUpMsgs(key, ackMsg.ExpectedClientID)
+	}
+}
+
+// the server accepted every ClientID before the expected one, the pending messages from it on are resent in order
+func (chat *Chat) resendUpMsgs(key string, expected uint64) {
+	pending := chat.pendingUpMsgs[key]
+	clientIDs := make([]uint64, 0, len(pending))
+	for clientID := range pending {
+		if clientID >= expected {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+	slices.Sort(clientIDs)
+	renumbered := make(map[uint64]*message.UPMsg, len(clientIDs))
+	next := expected
+	for _, clientID := range clientIDs {
+		upMsg := pending[clientID]
+		upMsg.Head.ClientID = next
+		renumbered[next] = upMsg
+		next++
+	}
+	chat.pendingUpMsgs[key] = renumbered
+	chat.MsgClientIDTable[key] = next
+	for clientID := expected; clientID < next; clientID++ {
+		chat.sendUpMsg(renumbered[clientID])
 	}
-	chat.MsgClientIDTable[sessionID] = res + 1
-	return res
 }
 
 func (chat *Chat) login() {
+	userID, _ := strconv.ParseUint(chat.UserID, 10, 64)
 	loginMsg := message.LoginMsg{
 		Head: &message.LoginMsgHead{
-			DeviceID: 123,
+			DeviceID:          chat.deviceID,
+			UserID:            userID,
+			HeartbeatInterval: uint32(chat.heartbeatInterval / time.Second),
+			NetworkType:       chat.networkType,
+			Ticket:            chat.ticket,
 		},
 	}
 	palyload, err := proto.Marshal(&loginMsg)
@@ -152,7 +359,9 @@ func (chat *Chat) login() {
 func (chat *Chat) reConn() {
 	reConn := message.ReConnMsg{
 		Head: &message.ReConnMsgHead{
-			ConnID: chat.conn.connID,
+			ConnID:   chat.conn.connID,
+			DeviceID: chat.deviceID,
+			Ticket:   chat.ticket,
 		},
 	}
 	palyload, err := proto.Marshal(&reConn)
@@ -162,14 +371,32 @@ func (chat *Chat) reConn() {
 	chat.conn.send(message.CmdType_ReConn, palyload)
 }
 
+func (chat *Chat) presenceSub(userIDs []uint64, unsubscribe bool) {
+	subMsg := message.PresenceSubMsg{
+		UserIDs:     userIDs,
+		Unsubscribe: unsubscribe,
+	}
+	palyload, err := proto.Marshal(&subMsg)
+	if err != nil {
+		panic(err)
+	}
+	chat.conn.send(message.CmdType_PresenceSub, palyload)
+}
+
 func (chat *Chat) heartbeat() {
-	tc := time.NewTicker(1 * time.Second)
+	interval := chat.conn.getHeartbeat()
+	tc := time.NewTicker(interval)
 	defer tc.Stop()
 	for {
 		select {
 		case <-chat.closeChan:
 			return
 		case <-tc.C:
+			// follow the interval negotiated in the login ack
+			if negotiated := chat.conn.getHeartbeat(); negotiated != interval {
+				interval = negotiated
+				tc.Reset(interval)
+			}
 			hearbeat := message.HeartbeatMsg{
 				Head: &message.HeartbeatMsgHead{},
 			}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"sync/atomic"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
@@ -16,6 +17,7 @@ type connect struct {
 	sendChan, recvChan chan *Message
 	conn               *net.TCPConn
 	connID             uint64
+	heartbeat          int64 // negotiated heartbeat interval in seconds
 	ip                 net.IP
 	port               int
 }
@@ -43,6 +45,9 @@ func handAckMsg(c *connect, data []byte) *Message {
 	switch ackMsg.Type {
 	case message.CmdType_Login, message.CmdType_ReConn:
 		atomic.StoreUint64(&c.connID, ackMsg.ConnID)
+		if ackMsg.HeartbeatInterval > 0 {
+			atomic.StoreInt64(&c.heartbeat, int64(ackMsg.HeartbeatInterval))
+		}
 	}
 	return &Message{
 		Type:       MsgTypeAck,
@@ -57,18 +62,173 @@ func handPushMsg(c *connect, data []byte) *Message {
 	proto.Unmarshal(data, pushMsg)
 	// if pushMsg.MsgID == c.maxMsgID+1 {
 	// 	c.maxMsgID++
+	if len(pushMsg.Content) == 0 && pushMsg.SessionID != 0 && !pushMsg.Recalled {
+		// large groups only notify the new message, it is pulled from the group timeline
+		c.sendGroupMsg(&message.GroupMsg{Op: message.GroupOp_Sync, GroupID: pushMsg.SessionID, MsgID: pushMsg.MsgID - 1})
+		return nil
+	}
 	msg := &Message{}
 	json.Unmarshal(pushMsg.Content, msg)
+	if pushMsg.Recalled {
+		msg.Type, msg.Content = MsgTypeText, "[recalled]"
+	} else if pushMsg.Edited {
+		msg.Content += " (edited)"
+	}
+	msg.SentByMe = pushMsg.SentByMe
+	msg.MsgID = pushMsg.MsgID
+	if pushMsg.FromUserID != 0 {
+		msg.FormUserID = fmt.Sprintf("%d", pushMsg.FromUserID)
+	}
+	if pushMsg.ToUserID != 0 {
+		msg.ToUserID = fmt.Sprintf("%d", pushMsg.ToUserID)
+	}
+	if pushMsg.SessionID != 0 {
+		msg.GroupID = fmt.Sprintf("%d", pushMsg.SessionID)
+	}
 	ackMsg := &message.ACKMsg{
-		Type:   message.CmdType_UP,
-		ConnID: c.connID,
+		Type:       message.CmdType_UP,
+		ConnID:     c.connID,
+		SessionID:  pushMsg.SessionID,
+		MsgID:      pushMsg.MsgID,
+		FromUserID: pushMsg.FromUserID,
+		ToUserID:   pushMsg.ToUserID,
 	}
 	ackData, _ := proto.Marshal(ackMsg)
 	c.send(message.CmdType_ACK, ackData)
+	if !pushMsg.SentByMe && pushMsg.FromUserID != 0 {
+		c.sendReceipt(message.CmdType_Delivered, pushMsg.SessionID, pushMsg.MsgID, pushMsg.FromUserID)
+	}
 	return msg
 	// }
 }
 
+func handPresenceMsg(c *connect, data []byte) *Message {
+	presenceMsg := &message.PresenceMsg{}
+	proto.Unmarshal(data, presenceMsg)
+	status := "offline"
+	if presenceMsg.Online {
+		status = "online"
+	}
+	return &Message{
+		Type:       MsgTypePresence,
+		Name:       "gochat",
+		FormUserID: fmt.Sprintf("%d", presenceMsg.UserID),
+		Content:    fmt.Sprintf("%s, last seen %s", status, time.UnixMilli(presenceMsg.LastActive).Format(time.DateTime)),
+	}
+}
+
+func handGroupMsg(c *connect, data []byte) *Message {
+	groupMsg := &message.GroupMsg{}
+	proto.Unmarshal(data, groupMsg)
+	content := fmt.Sprintf("%s %s", groupMsg.Op, groupMsg.Msg)
+	if groupMsg.Op == message.GroupOp_Members {
+		content = fmt.Sprintf("%s %v", content, groupMsg.UserIDs)
+	}
+	return &Message{
+		Type:    MsgTypeGroup,
+		Name:    "gochat",
+		GroupID: fmt.Sprintf("%d", groupMsg.GroupID),
+		Content: content,
+	}
+}
+
+func handReceiptMsg(ty message.CmdType, data []byte) *Message {
+	receipt := &message.ReceiptMsg{}
+	proto.Unmarshal(data, receipt)
+	msgType := MsgTypeDelivered
+	if ty == message.CmdType_Read {
+		msgType = MsgTypeRead
+	}
+	msg := &Message{
+		Type:       msgType,
+		Name:       "gochat",
+		FormUserID: fmt.Sprintf("%d", receipt.FromUserID),
+		MsgID:      receipt.MsgID,
+		Content:    fmt.Sprintf("%s #%d at %s", msgType, receipt.MsgID, time.UnixMilli(receipt.Timestamp).Format(time.DateTime)),
+	}
+	if receipt.SessionID != 0 {
+		msg.GroupID = fmt.Sprintf("%d", receipt.SessionID)
+	}
+	return msg
+}
+
+func handSignalMsg(data []byte) *Message {
+	signal := &message.SignalMsg{}
+	proto.Unmarshal(data, signal)
+	msg := &Message{
+		Type:       MsgTypeSignal,
+		Name:       "gochat",
+		FormUserID: fmt.Sprintf("%d", signal.FromUserID),
+		Content:    signal.Event,
+	}
+	if signal.GroupID != 0 {
+		msg.GroupID = fmt.Sprintf("%d", signal.GroupID)
+	}
+	return msg
+}
+
+func handModifyMsg(data []byte) *Message {
+	modifyMsg := &message.ModifyMsg{}
+	proto.Unmarshal(data, modifyMsg)
+	msg := &Message{
+		Type:       MsgTypeRecall,
+		Name:       "gochat",
+		FormUserID: fmt.Sprintf("%d", modifyMsg.FromUserID),
+		MsgID:      modifyMsg.MsgID,
+	}
+	if modifyMsg.Op == message.ModifyOp_Edit {
+		msg.Type = MsgTypeEdit
+		edited := &Message{}
+		json.Unmarshal(modifyMsg.Content, edited)
+		msg.Content = edited.Content
+	}
+	if modifyMsg.SessionID != 0 {
+		msg.GroupID = fmt.Sprintf("%d", modifyMsg.SessionID)
+	} else {
+		msg.ToUserID = fmt.Sprintf("%d", modifyMsg.ToUserID)
+	}
+	if modifyMsg.Code != 0 {
+		msg.Content = modifyMsg.Msg
+	}
+	return msg
+}
+
+func (c *connect) sendModify(modifyMsg *message.ModifyMsg) {
+	palyload, err := proto.Marshal(modifyMsg)
+	if err != nil {
+		panic(err)
+	}
+	c.send(message.CmdType_Modify, palyload)
+}
+
+func (c *connect) sendReceipt(ty message.CmdType, sessionID, msgID, toUserID uint64) {
+	palyload, err := proto.Marshal(&message.ReceiptMsg{
+		SessionID: sessionID,
+		MsgID:     msgID,
+		ToUserID:  toUserID,
+	})
+	if err != nil {
+		panic(err)
+	}
+	c.send(ty, palyload)
+}
+
+func (c *connect) sendGroupMsg(groupMsg *message.GroupMsg) {
+	palyload, err := proto.Marshal(groupMsg)
+	if err != nil {
+		panic(err)
+	}
+	c.send(message.CmdType_Group, palyload)
+}
+
+// heartbeat interval negotiated with the server, 1s before the login ack arrives
+func (c *connect) getHeartbeat() time.Duration {
+	if seconds := atomic.LoadInt64(&c.heartbeat); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Second
+}
+
 func (c *connect) reConn() {
 	c.conn.Close()
 	addr := &net.TCPAddr{IP: c.ip, Port: c.port}
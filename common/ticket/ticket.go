@@ -0,0 +1,177 @@
+package ticket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/feichai0017/GoChat/common/config"
+)
+
+var (
+	ErrMalformed    = errors.New("ticket malformed")
+	ErrUnknownKey   = errors.New("ticket signed by an unknown key")
+	ErrBadSignature = errors.New("ticket signature mismatch")
+	ErrExpired      = errors.New("ticket expired")
+	ErrNoActiveKey  = errors.New("no active ticket key")
+	ErrNoKeys       = errors.New("ticket keys not loaded")
+)
+
+// Claims bind a device to the gateways chosen by ipconf until the expiry
+type Claims struct {
+	DeviceID string   `json:"did"`
+	Gateways []string `json:"gw"`  // ip:port of the gateways the device may connect to
+	ExpireAt int64    `json:"exp"` // unix seconds
+}
+
+// Allows reports whether the device may connect to the gateway
+func (c *Claims) Allows(deviceID, gateway string) bool {
+	if c.DeviceID != deviceID {
+		return false
+	}
+	for _, gw := range c.Gateways {
+		if gw == gateway {
+			return true
+		}
+	}
+	return false
+}
+
+// Keyring signs the tickets with the active key and verifies them with any of its keys,
+// so a key is rotated by adding the new one everywhere, making it active on ipconf,
+// and removing the old one once the tickets it signed have expired.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+func NewKeyring(active string, keys map[string]string) (*Keyring, error) {
+	k := &Keyring{active: active, keys: make(map[string][]byte, len(keys))}
+	for id, secret := range keys {
+		if id == "" || strings.Contains(id, ".") {
+			return nil, fmt.Errorf("invalid ticket key id %q", id)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("empty secret of ticket key %q", id)
+		}
+		k.keys[id] = []byte(secret)
+	}
+	if _, ok := k.keys[active]; active != "" && !ok {
+		return nil, fmt.Errorf("active ticket key %q not found", active)
+	}
+	return k, nil
+}
+
+// Sign returns the ticket "keyID.payload.signature" of the claims
+func (k *Keyring) Sign(c *Claims) (string, error) {
+	key, ok := k.keys[k.active]
+	if !ok {
+		return "", ErrNoActiveKey
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := k.active + "." + base64.RawURLEncoding.EncodeToString(data)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(key, signed)), nil
+}
+
+// Verify checks the signature and the expiry of the ticket and returns its claims
+func (k *Keyring) Verify(ticket string, now time.Time) (*Claims, error) {
+	parts := strings.Split(ticket, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal(sig, sign(key, parts[0]+"."+parts[1])) {
+		return nil, ErrBadSignature
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	c := &Claims{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, ErrMalformed
+	}
+	if now.Unix() >= c.ExpireAt {
+		return nil, ErrExpired
+	}
+	return c, nil
+}
+
+func sign(key []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+var keyring atomic.Pointer[Keyring]
+
+// Init loads the keys from the config and reloads them when the config file changes,
+// so the keys rotate without restarting ipconf or the gateways
+func Init() error {
+	k, err := loadKeyring()
+	if err != nil {
+		return err
+	}
+	keyring.Store(k)
+	config.OnChange(func() {
+		k, err := loadKeyring()
+		if err != nil {
+			// the previous keys stay in use
+			fmt.Printf("[ERROR] reload ticket keys: %v\n", err)
+			return
+		}
+		keyring.Store(k)
+		fmt.Printf("[INFO] ticket keys reloaded, active key %q\n", config.GetTicketActiveKey())
+	})
+	return nil
+}
+
+func loadKeyring() (*Keyring, error) {
+	return NewKeyring(config.GetTicketActiveKey(), config.GetTicketKeys())
+}
+
+// Issue signs a ticket of the device for the gateways, valid for the configured ttl
+func Issue(deviceID string, gateways []string) (string, error) {
+	k := keyring.Load()
+	if k == nil {
+		return "", ErrNoKeys
+	}
+	return k.Sign(&Claims{
+		DeviceID: deviceID,
+		Gateways: gateways,
+		ExpireAt: time.Now().Add(config.GetTicketTTL()).Unix(),
+	})
+}
+
+// Check verifies the ticket presented by the device connecting to the gateway
+func Check(ticket, deviceID, gateway string) error {
+	k := keyring.Load()
+	if k == nil {
+		return ErrNoKeys
+	}
+	c, err := k.Verify(ticket, time.Now())
+	if err != nil {
+		return err
+	}
+	if !c.Allows(deviceID, gateway) {
+		return fmt.Errorf("ticket of device %s for %v does not allow device %s on %s", c.DeviceID, c.Gateways, deviceID, gateway)
+	}
+	return nil
+}
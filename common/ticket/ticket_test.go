@@ -0,0 +1,121 @@
+package ticket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyringVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	k1, _ := NewKeyring("k1", map[string]string{"k1": "secret1"})
+	claims := &Claims{DeviceID: "42", Gateways: []string{"10.0.0.1:8900"}, ExpireAt: now.Add(time.Minute).Unix()}
+	tk, err := k1.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(tk, ".")
+	other, _ := NewKeyring("k1", map[string]string{"k1": "secret2"})
+	forged, _ := other.Sign(&Claims{DeviceID: "42", Gateways: []string{"10.0.0.9:8900"}, ExpireAt: now.Add(time.Hour).Unix()})
+	tests := []struct {
+		name   string
+		keys   *Keyring
+		ticket string
+		now    time.Time
+		err    error
+	}{
+		{"valid", k1, tk, now, nil},
+		{"expired", k1, tk, now.Add(time.Minute), ErrExpired},
+		{"tampered payload", k1, parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2], now, ErrBadSignature},
+		{"signed by another secret", k1, forged, now, ErrBadSignature},
+		{"unknown key", k1, "k9." + parts[1] + "." + parts[2], now, ErrUnknownKey},
+		{"malformed", k1, "garbage", now, ErrMalformed},
+		{"empty", k1, "", now, ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.keys.Verify(tt.ticket, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+			if err == nil && !c.Allows("42", "10.0.0.1:8900") {
+				t.Fatalf("claims %+v", c)
+			}
+		})
+	}
+}
+
+// during a rotation the tickets of the old key stay valid until it is removed
+func TestKeyringRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := &Claims{DeviceID: "42", Gateways: []string{"10.0.0.1:8900"}, ExpireAt: now.Add(time.Minute).Unix()}
+	before, _ := NewKeyring("k1", map[string]string{"k1": "secret1"})
+	added, _ := NewKeyring("k1", map[string]string{"k1": "secret1", "k2": "secret2"})
+	switched, _ := NewKeyring("k2", map[string]string{"k1": "secret1", "k2": "secret2"})
+	after, _ := NewKeyring("k2", map[string]string{"k2": "secret2"})
+	oldTicket, _ := before.Sign(claims)
+	newTicket, _ := switched.Sign(claims)
+	tests := []struct {
+		name   string
+		keys   *Keyring
+		ticket string
+		err    error
+	}{
+		{"old ticket after the new key is added", added, oldTicket, nil},
+		{"old ticket after the switch", switched, oldTicket, nil},
+		{"new ticket on a gateway with both keys", added, newTicket, nil},
+		{"new ticket on a gateway not updated yet", before, newTicket, ErrUnknownKey},
+		{"old ticket after the old key is removed", after, oldTicket, ErrUnknownKey},
+		{"new ticket after the old key is removed", after, newTicket, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.keys.Verify(tt.ticket, now); !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		active  string
+		keys    map[string]string
+		wantErr bool
+	}{
+		{"ok", "k1", map[string]string{"k1": "s"}, false},
+		{"verify only", "", map[string]string{"k1": "s"}, false},
+		{"active key missing", "k2", map[string]string{"k1": "s"}, true},
+		{"empty secret", "k1", map[string]string{"k1": ""}, true},
+		{"dot in the id", "k.1", map[string]string{"k.1": "s"}, true},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.active, tt.keys); (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err %v, want err %v", tt.name, err, tt.wantErr)
+		}
+	}
+	k, _ := NewKeyring("", map[string]string{"k1": "s"})
+	if _, err := k.Sign(&Claims{}); !errors.Is(err, ErrNoActiveKey) {
+		t.Fatalf("sign without an active key: %v", err)
+	}
+}
+
+func TestClaimsAllows(t *testing.T) {
+	c := &Claims{DeviceID: "42", Gateways: []string{"10.0.0.1:8900", "10.0.0.2:8900"}}
+	tests := []struct {
+		deviceID, gateway string
+		want              bool
+	}{
+		{"42", "10.0.0.2:8900", true},
+		{"43", "10.0.0.1:8900", false},
+		{"42", "10.0.0.3:8900", false},
+		{"42", "10.0.0.1:8901", false},
+	}
+	for _, tt := range tests {
+		if got := c.Allows(tt.deviceID, tt.gateway); got != tt.want {
+			t.Fatalf("device %s on %s: %v, want %v", tt.deviceID, tt.gateway, got, tt.want)
+		}
+	}
+}
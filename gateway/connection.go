@@ -34,6 +34,8 @@ type connection struct {
 	e       *epoller
 	conn    *net.TCPConn
 	readBuf bytes.Buffer // read buffer
+	// the first frame passed the ticket check, only used by the epoller goroutine of the connection
+	authorized bool
 }
 
 func init() {
@@ -2,37 +2,113 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/feichai0017/GoChat/common/cache"
 	"github.com/feichai0017/GoChat/common/config"
 	"github.com/feichai0017/GoChat/common/crpc"
+	clientinterceptor "github.com/feichai0017/GoChat/common/crpc/interceptor/client"
+	"github.com/feichai0017/GoChat/common/discovery"
 	"github.com/feichai0017/GoChat/state/rpc/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var stateClient service.StateClient
+const (
+	// a saturated state server rejects the command, it is retried before the command is shed
+	retryAttempts = 3
+	retryBackoff  = 10 * time.Millisecond
+)
+
+// errSlotNoOwner is returned while the slot of the connection is handed over between state servers
+var errSlotNoOwner = errors.New("slot of the connection has no owner")
+
+var (
+	stateClient  service.StateClient // the only state server when the slot ownership is disabled
+	statePCli    *crpc.CClient
+	slotTable    *discovery.SlotTable
+	stateClients sync.Map // state server endpoint -> service.StateClient
+)
 
 func initStateClient() {
-	pCli, err := crpc.NewCClient(config.GetStateServiceName())
+	var err error
+	statePCli, err = crpc.NewCClient(config.GetStateServiceName(), clientinterceptor.RetryUnaryClientInterceptor(retryAttempts, retryBackoff))
 	if err != nil {
 		panic(err)
 	}
-	cli, err := pCli.DialByEndPoint(config.GetGatewayStateServerEndPoint())
+	cli, err := statePCli.DialByEndPoint(config.GetGatewayStateServerEndPoint())
 	if err != nil {
 		panic(err)
 	}
 	stateClient = service.NewStateClient(cli)
+	if config.IsStateSlotOwnershipEnable() {
+		ctx := context.Background()
+		if slotTable, err = discovery.NewSlotTable(&ctx, config.GetStateSlotOwnershipPath()); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// route the connection to the state server which owns its slot
+func getStateClient(connID uint64) (service.StateClient, error) {
+	if slotTable == nil {
+		return stateClient, nil
+	}
+	endpoint, ok := slotTable.Owner(cache.ConnSlot(config.GetStateServerLoginSlotRange(), connID))
+	if !ok {
+		return nil, errSlotNoOwner
+	}
+	if cli, ok := stateClients.Load(endpoint); ok {
+		return cli.(service.StateClient), nil
+	}
+	conn, err := statePCli.DialByEndPoint(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("dial state server %s: %w", endpoint, err)
+	}
+	cli, _ := stateClients.LoadOrStore(endpoint, service.NewStateClient(conn))
+	return cli.(service.StateClient), nil
+}
+
+// call the owner of the slot of the connection. While the slot is handed over it has no owner, or the
+// old owner rejects the command, so the call is retried with the slot owners updated by the watch.
+// No other state server may serve the connection, the last error is returned when the attempts run out.
+func callStateServer(ctx context.Context, connID uint64, call func(cli service.StateClient) error) error {
+	var err error
+	wait := retryBackoff
+	for i := 0; i < retryAttempts; i++ {
+		var cli service.StateClient
+		if cli, err = getStateClient(connID); err == nil {
+			if err = call(cli); status.Code(err) != codes.FailedPrecondition {
+				return err
+			}
+		}
+		if i == retryAttempts-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+	return err
 }
 
 func CancelConn(ctx *context.Context, endpoint string, connID uint64, Payload []byte) error {
 	rpcCtx, cancel := context.WithTimeout(*ctx, 100*time.Millisecond)
 	defer cancel()
-	stateClient.CancelConn(rpcCtx, &service.StateRequest{
-		Endpoint: endpoint,
-		ConnID:   connID,
-		Data:     Payload,
+	return callStateServer(rpcCtx, connID, func(cli service.StateClient) error {
+		_, err := cli.CancelConn(rpcCtx, &service.StateRequest{
+			Endpoint: endpoint,
+			ConnID:   connID,
+			Data:     Payload,
+		})
+		return err
 	})
-	return nil
 }
 
 func SendMsg(ctx *context.Context, endpoint string, connID uint64, Payload []byte) error {
@@ -40,14 +116,12 @@ func SendMsg(ctx *context.Context, endpoint string, connID uint64, Payload []byt
 	defer cancel()
 
 	fmt.Println("[INFO] sendMsg", connID, string(Payload))
-	_, err := stateClient.SendMsg(rpcCtx, &service.StateRequest{
-		Endpoint: endpoint,
-		ConnID:   connID,
-		Data:     Payload,
+	return callStateServer(rpcCtx, connID, func(cli service.StateClient) error {
+		_, err := cli.SendMsg(rpcCtx, &service.StateRequest{
+			Endpoint: endpoint,
+			ConnID:   connID,
+			Data:     Payload,
+		})
+		return err
 	})
-	if err != nil {
-		fmt.Println("[ERROR] sendMsg error", err)
-		panic(err)
-	}
-	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/feichai0017/GoChat/state/rpc/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallStateServer(t *testing.T) {
+	// the old owner rejects the command until the slot is taken over
+	calls := 0
+	err := callStateServer(context.TODO(), 1, func(cli service.StateClient) error {
+		calls++
+		if calls < 2 {
+			return service.ErrSlotNotOwned
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	// the command is not served by another state server when the attempts run out
+	calls = 0
+	err = callStateServer(context.TODO(), 1, func(cli service.StateClient) error {
+		calls++
+		return service.ErrSlotNotOwned
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, retryAttempts, calls)
+
+	// other errors are returned at once, the rpc interceptors retry them
+	calls = 0
+	err = callStateServer(context.TODO(), 1, func(cli service.StateClient) error {
+		calls++
+		return status.Error(codes.ResourceExhausted, "full")
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 1, calls)
+}
@@ -73,6 +73,59 @@ func (x *GatewayRequest) GetData() []byte {
 	return nil
 }
 
+// push the same data to a batch of connections
+type BatchPushRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnIDs       []uint64               `protobuf:"varint,1,rep,packed,name=connIDs,proto3" json:"connIDs,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchPushRequest) Reset() {
+	*x = BatchPushRequest{}
+	mi := &file_gateway_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchPushRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchPushRequest) ProtoMessage() {}
+
+func (x *BatchPushRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gateway_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchPushRequest.ProtoReflect.Descriptor instead.
+func (*BatchPushRequest) Descriptor() ([]byte, []int) {
+	return file_gateway_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *BatchPushRequest) GetConnIDs() []uint64 {
+	if x != nil {
+		return x.ConnIDs
+	}
+	return nil
+}
+
+func (x *BatchPushRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 type GatewayResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Code          int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
@@ -83,7 +136,7 @@ type GatewayResponse struct {
 
 func (x *GatewayResponse) Reset() {
 	*x = GatewayResponse{}
-	mi := &file_gateway_proto_msgTypes[1]
+	mi := &file_gateway_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -95,7 +148,7 @@ func (x *GatewayResponse) String() string {
 func (*GatewayResponse) ProtoMessage() {}
 
 func (x *GatewayResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_gateway_proto_msgTypes[1]
+	mi := &file_gateway_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -108,7 +161,7 @@ func (x *GatewayResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GatewayResponse.ProtoReflect.Descriptor instead.
 func (*GatewayResponse) Descriptor() ([]byte, []int) {
-	return file_gateway_proto_rawDescGZIP(), []int{1}
+	return file_gateway_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *GatewayResponse) GetCode() int32 {
@@ -132,13 +185,17 @@ const file_gateway_proto_rawDesc = "" +
 	"\rgateway.proto\x12\aservice\"<\n" +
 	"\x0eGatewayRequest\x12\x16\n" +
 	"\x06connID\x18\x01 \x01(\x04R\x06connID\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"@\n" +
+	"\x10BatchPushRequest\x12\x18\n" +
+	"\aconnIDs\x18\x01 \x03(\x04R\aconnIDs\x12\x12\n" +
 	"\x04data\x18\x02 \x01(\fR\x04data\"7\n" +
 	"\x0fGatewayResponse\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x10\n" +
-	"\x03msg\x18\x02 \x01(\tR\x03msg2\x82\x01\n" +
+	"\x03msg\x18\x02 \x01(\tR\x03msg2\xc4\x01\n" +
 	"\aGateway\x12<\n" +
 	"\aDelConn\x12\x17.service.GatewayRequest\x1a\x18.service.GatewayResponse\x129\n" +
-	"\x04Push\x12\x17.service.GatewayRequest\x1a\x18.service.GatewayResponseB\fZ\n" +
+	"\x04Push\x12\x17.service.GatewayRequest\x1a\x18.service.GatewayResponse\x12@\n" +
+	"\tBatchPush\x12\x19.service.BatchPushRequest\x1a\x18.service.GatewayResponseB\fZ\n" +
 	"./;serviceb\x06proto3"
 
 var (
@@ -153,18 +210,21 @@ func file_gateway_proto_rawDescGZIP() []byte {
 	return file_gateway_proto_rawDescData
 }
 
-var file_gateway_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_gateway_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_gateway_proto_goTypes = []any{
-	(*GatewayRequest)(nil),  // 0: service.GatewayRequest
-	(*GatewayResponse)(nil), // 1: service.GatewayResponse
+	(*GatewayRequest)(nil),   // 0: service.GatewayRequest
+	(*BatchPushRequest)(nil), // 1: service.BatchPushRequest
+	(*GatewayResponse)(nil),  // 2: service.GatewayResponse
 }
 var file_gateway_proto_depIdxs = []int32{
 	0, // 0: service.Gateway.DelConn:input_type -> service.GatewayRequest
 	0, // 1: service.Gateway.Push:input_type -> service.GatewayRequest
-	1, // 2: service.Gateway.DelConn:output_type -> service.GatewayResponse
-	1, // 3: service.Gateway.Push:output_type -> service.GatewayResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
+	1, // 2: service.Gateway.BatchPush:input_type -> service.BatchPushRequest
+	2, // 3: service.Gateway.DelConn:output_type -> service.GatewayResponse
+	2, // 4: service.Gateway.Push:output_type -> service.GatewayResponse
+	2, // 5: service.Gateway.BatchPush:output_type -> service.GatewayResponse
+	3, // [3:6] is the sub-list for method output_type
+	0, // [0:3] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
 	0, // [0:0] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
@@ -181,7 +241,7 @@ func file_gateway_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gateway_proto_rawDesc), len(file_gateway_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,8 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Gateway_DelConn_FullMethodName = "/service.Gateway/DelConn"
-	Gateway_Push_FullMethodName    = "/service.Gateway/Push"
+	Gateway_DelConn_FullMethodName   = "/service.Gateway/DelConn"
+	Gateway_Push_FullMethodName      = "/service.Gateway/Push"
+	Gateway_BatchPush_FullMethodName = "/service.Gateway/BatchPush"
 )
 
 // GatewayClient is the client API for Gateway service.
@@ -32,6 +33,7 @@ const (
 type GatewayClient interface {
 	DelConn(ctx context.Context, in *GatewayRequest, opts ...grpc.CallOption) (*GatewayResponse, error)
 	Push(ctx context.Context, in *GatewayRequest, opts ...grpc.CallOption) (*GatewayResponse, error)
+	BatchPush(ctx context.Context, in *BatchPushRequest, opts ...grpc.CallOption) (*GatewayResponse, error)
 }
 
 type gatewayClient struct {
@@ -62,6 +64,16 @@ func (c *gatewayClient) Push(ctx context.Context, in *GatewayRequest, opts ...gr
 	return out, nil
 }
 
+func (c *gatewayClient) BatchPush(ctx context.Context, in *BatchPushRequest, opts ...grpc.CallOption) (*GatewayResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GatewayResponse)
+	err := c.cc.Invoke(ctx, Gateway_BatchPush_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GatewayServer is the server API for Gateway service.
 // All implementations must embed UnimplementedGatewayServer
 // for forward compatibility.
@@ -71,6 +83,7 @@ func (c *gatewayClient) Push(ctx context.Context, in *GatewayRequest, opts ...gr
 type GatewayServer interface {
 	DelConn(context.Context, *GatewayRequest) (*GatewayResponse, error)
 	Push(context.Context, *GatewayRequest) (*GatewayResponse, error)
+	BatchPush(context.Context, *BatchPushRequest) (*GatewayResponse, error)
 	mustEmbedUnimplementedGatewayServer()
 }
 
@@ -87,6 +100,9 @@ func (UnimplementedGatewayServer) DelConn(context.Context, *GatewayRequest) (*Ga
 func (UnimplementedGatewayServer) Push(context.Context, *GatewayRequest) (*GatewayResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
 }
+func (UnimplementedGatewayServer) BatchPush(context.Context, *BatchPushRequest) (*GatewayResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchPush not implemented")
+}
 func (UnimplementedGatewayServer) mustEmbedUnimplementedGatewayServer() {}
 func (UnimplementedGatewayServer) testEmbeddedByValue()                 {}
 
@@ -144,6 +160,24 @@ func _Gateway_Push_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Gateway_BatchPush_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchPushRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).BatchPush(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_BatchPush_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).BatchPush(ctx, req.(*BatchPushRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Gateway_ServiceDesc is the grpc.ServiceDesc for Gateway service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -159,6 +193,10 @@ var Gateway_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Push",
 			Handler:    _Gateway_Push_Handler,
 		},
+		{
+			MethodName: "BatchPush",
+			Handler:    _Gateway_BatchPush_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "gateway.proto",
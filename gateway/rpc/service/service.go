@@ -2,18 +2,38 @@ package service
 
 import (
 	context "context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
-	DelConnCmd = 1 // DelConn
-	PushCmd    = 2 // push
+	DelConnCmd   = 1 // DelConn
+	PushCmd      = 2 // push
+	BatchPushCmd = 3 // push to a batch of connections
 )
 
+var ErrConnNotFound = errors.New("connection not found")
+
 type CmdContext struct {
 	Ctx     *context.Context
 	Cmd     int32
 	ConnID  uint64
+	ConnIDs []uint64
 	Payload []byte
+	Result  chan error // outcome of the command, reported by the handler
+}
+
+// Done reports the outcome of the command back to the rpc caller
+func (c *CmdContext) Done(err error) {
+	if c.Result == nil {
+		return
+	}
+	select {
+	case c.Result <- err:
+	default:
+	}
 }
 
 type Service struct {
@@ -23,27 +43,55 @@ type Service struct {
 
 func (s *Service) DelConn(ctx context.Context, gr *GatewayRequest) (*GatewayResponse, error) {
 	c := context.TODO()
-	s.CmdChannel <- &CmdContext{
+	return s.admit(ctx, &CmdContext{
 		Ctx:    &c,
 		Cmd:    DelConnCmd,
 		ConnID: gr.ConnID,
-	}
-	return &GatewayResponse{
-		Code: 0,
-		Msg:  "success",
-	}, nil
+	})
 }
 
 func (s *Service) Push(ctx context.Context, gr *GatewayRequest) (*GatewayResponse, error) {
 	c := context.TODO()
-	s.CmdChannel <- &CmdContext{
+	return s.admit(ctx, &CmdContext{
 		Ctx:     &c,
 		Cmd:     PushCmd,
 		ConnID:  gr.ConnID,
 		Payload: gr.GetData(),
+	})
+}
+
+func (s *Service) BatchPush(ctx context.Context, br *BatchPushRequest) (*GatewayResponse, error) {
+	c := context.TODO()
+	return s.admit(ctx, &CmdContext{
+		Ctx:     &c,
+		Cmd:     BatchPushCmd,
+		ConnIDs: br.GetConnIDs(),
+		Payload: br.GetData(),
+	})
+}
+
+// admit the command without blocking, a saturated channel is rejected with ResourceExhausted,
+// otherwise wait for the outcome of the command until the rpc deadline
+func (s *Service) admit(ctx context.Context, cmdCtx *CmdContext) (*GatewayResponse, error) {
+	cmdCtx.Result = make(chan error, 1)
+	select {
+	case s.CmdChannel <- cmdCtx:
+	default:
+		return nil, status.Error(codes.ResourceExhausted, "gateway cmd channel is full")
+	}
+	select {
+	case err := <-cmdCtx.Result:
+		if err != nil {
+			return &GatewayResponse{
+				Code: 1,
+				Msg:  err.Error(),
+			}, nil
+		}
+		return &GatewayResponse{
+			Code: 0,
+			Msg:  "success",
+		}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
 	}
-	return &GatewayResponse{
-		Code: 0,
-		Msg:  "success",
-	}, nil
-}
\ No newline at end of file
+}
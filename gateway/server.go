@@ -16,6 +16,7 @@ import (
 	"github.com/feichai0017/GoChat/common/config"
 	"github.com/feichai0017/GoChat/common/crpc"
 	"github.com/feichai0017/GoChat/common/tcp"
+	"github.com/feichai0017/GoChat/common/ticket"
 	"github.com/feichai0017/GoChat/gateway/rpc/client"
 	"github.com/feichai0017/GoChat/gateway/rpc/service"
 )
@@ -30,6 +31,11 @@ func RunMain(path string) {
 		log.Fatalf("[FATAL] StartTCPEPollServer err:%s", err.Error())
 		panic(err)
 	}
+	if config.IsTicketEnforce() {
+		if err := ticket.Init(); err != nil {
+			log.Fatalf("[FATAL] load ticket keys err:%s", err.Error())
+		}
+	}
 	initWorkPool()
 	initEpoll(ln, runProc)
 	fmt.Println("-------------im gateway stated------------")
@@ -75,7 +81,9 @@ func runProc(c *connection, ep *epoller) {
 				fmt.Printf("[ERROR] Connection %d closed with error: %v", c.id, err)
 				ctx := context.Background()
 				ep.remove(c)
-				client.CancelConn(&ctx, getEndpoint(), c.id, nil)
+				if err := client.CancelConn(&ctx, getEndpoint(), c.id, nil); err != nil {
+					fmt.Printf("[ERROR] CancelConn connID=%d: %v\n", c.id, err)
+				}
 			}
 			
 			return // Stop handling this connection
@@ -94,31 +102,64 @@ func runProc(c *connection, ep *epoller) {
 func cmdHandler() {
 	for cmd := range cmdChannel {
 		// submit the task to the pool asynchronously
+		var task func() error
 		switch cmd.Cmd {
 		case service.DelConnCmd:
-			wPool.Submit(func() { closeConn(cmd) })
+			task = func() error { return closeConn(cmd) }
 		case service.PushCmd:
-			wPool.Submit(func() { sendMsgByCmd(cmd) })
+			task = func() error { return sendMsgByCmd(cmd) }
+		case service.BatchPushCmd:
+			task = func() error { return batchSendMsgByCmd(cmd) }
 		default:
 			panic("command undefined")
 		}
+		if err := wPool.Submit(func() { cmd.Done(task()) }); err != nil {
+			cmd.Done(err)
+		}
 	}
 }
-func closeConn(cmd *service.CmdContext) {
-	if connPtr, ok := ep.tables.Load(cmd.ConnID); ok {
-		conn, _ := connPtr.(*connection)
-		conn.Close()
+func closeConn(cmd *service.CmdContext) error {
+	connPtr, ok := ep.tables.Load(cmd.ConnID)
+	if !ok {
+		return service.ErrConnNotFound
+	}
+	conn, _ := connPtr.(*connection)
+	conn.Close()
+	return nil
+}
+func sendMsgByCmd(cmd *service.CmdContext) error {
+	connPtr, ok := ep.tables.Load(cmd.ConnID)
+	if !ok {
+		return service.ErrConnNotFound
+	}
+	conn, _ := connPtr.(*connection)
+	dp := tcp.DataPgk{
+		Len:  uint32(len(cmd.Payload)),
+		Data: cmd.Payload,
 	}
+	return tcp.SendData(conn.conn, dp.Marshal())
 }
-func sendMsgByCmd(cmd *service.CmdContext) {
-	if connPtr, ok := ep.tables.Load(cmd.ConnID); ok {
+
+// the connections that are gone or fail to write are skipped, the last error is reported
+func batchSendMsgByCmd(cmd *service.CmdContext) error {
+	dp := tcp.DataPgk{
+		Len:  uint32(len(cmd.Payload)),
+		Data: cmd.Payload,
+	}
+	data := dp.Marshal()
+	var lastErr error
+	for _, connID := range cmd.ConnIDs {
+		connPtr, ok := ep.tables.Load(connID)
+		if !ok {
+			lastErr = service.ErrConnNotFound
+			continue
+		}
 		conn, _ := connPtr.(*connection)
-		dp := tcp.DataPgk{
-			Len:  uint32(len(cmd.Payload)),
-			Data: cmd.Payload,
+		if err := tcp.SendData(conn.conn, data); err != nil {
+			lastErr = err
 		}
-		tcp.SendData(conn.conn, dp.Marshal())
 	}
+	return lastErr
 }
 
 func getEndpoint() string {
@@ -146,10 +187,23 @@ func parseAndForward(c *connection) {
 		fullMessage := make([]byte, dataLen)
 		c.readBuf.Read(fullMessage)
 
+		if !c.authorized && config.IsTicketEnforce() {
+			if err := authorize(fullMessage); err != nil {
+				fmt.Printf("[ERROR] connID=%d from %s rejected: %v\n", c.id, c.RemoteAddr(), err)
+				c.e.remove(c)
+				c.conn.Close()
+				return
+			}
+		}
+		c.authorized = true
+
 		// Asynchronously submit to the worker pool for processing
 		wPool.Submit(func() {
 			ctx := context.Background()
-			client.SendMsg(&ctx, getEndpoint(), c.id, fullMessage)
+			if err := client.SendMsg(&ctx, getEndpoint(), c.id, fullMessage); err != nil {
+				// the state server is still saturated after the retries or unreachable, the message is shed
+				fmt.Printf("[ERROR] SendMsg shed connID=%d: %v\n", c.id, err)
+			}
 		})
 	}
 }
@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"github.com/feichai0017/GoChat/common/ticket"
+)
+
+// authorize the first frame of a connection, it must be a login or re-connection
+// carrying a ticket issued by ipconf for this device and this gateway
+func authorize(frame []byte) error {
+	msgCmd := &message.MsgCmd{}
+	if err := proto.Unmarshal(frame, msgCmd); err != nil {
+		return err
+	}
+	var deviceID uint64
+	var tk string
+	switch msgCmd.Type {
+	case message.CmdType_Login:
+		loginMsg := &message.LoginMsg{}
+		if err := proto.Unmarshal(msgCmd.Payload, loginMsg); err != nil {
+			return err
+		}
+		deviceID, tk = loginMsg.GetHead().GetDeviceID(), loginMsg.GetHead().GetTicket()
+	case message.CmdType_ReConn:
+		reConnMsg := &message.ReConnMsg{}
+		if err := proto.Unmarshal(msgCmd.Payload, reConnMsg); err != nil {
+			return err
+		}
+		deviceID, tk = reConnMsg.GetHead().GetDeviceID(), reConnMsg.GetHead().GetTicket()
+	default:
+		return fmt.Errorf("first frame %s is not a login", msgCmd.Type)
+	}
+	return ticket.Check(tk, strconv.FormatUint(deviceID, 10), getTCPEndpoint())
+}
+
+// the address of the gateway handed out by ipconf
+func getTCPEndpoint() string {
+	return net.JoinHostPort(config.GetGatewayServiceAddr(), strconv.Itoa(config.GetGatewayTCPServerPort()))
+}
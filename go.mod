@@ -3,6 +3,7 @@ module github.com/feichai0017/GoChat
 go 1.24.2
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/cloudwego/hertz v0.9.7
 	github.com/gookit/color v1.5.1
 	github.com/panjf2000/ants/v2 v2.11.3
@@ -20,7 +21,6 @@ require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/bytedance/sonic/loader v0.2.4 // indirect
 	github.com/cenkalti/backoff/v4 v4.3.0 // indirect
-	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/cloudwego/base64x v0.1.5 // indirect
 	github.com/coreos/go-systemd/v22 v22.3.2 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
@@ -92,7 +92,7 @@ require (
 	github.com/bytedance/sonic v1.13.2 // indirect
 	github.com/cloudwego/netpoll v0.6.4 // indirect
 	github.com/coreos/go-semver v0.3.0 // indirect
-	github.com/fsnotify/fsnotify v1.5.4 // indirect
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/goccy/go-json v0.10.5
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.4 // indirect
@@ -2,11 +2,18 @@ package ipconf
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/goccy/go-json"
 
+	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/ticket"
 	"github.com/feichai0017/GoChat/ipconf/domain"
 )
 
@@ -14,6 +21,11 @@ type Response struct {
 	Message string `json:"message"`
 	Code    int    `json:"code"`
 	Data    any    `json:"data"`
+	Ticket  string `json:"ticket,omitempty"` // signed ticket for the endpoints in data, presented in the login frame
+	// the registry is unreachable or the endpoints come from a snapshot or the static list, they may be stale
+	Degraded bool `json:"degraded,omitempty"`
+	// ms the client waits before asking again, after being limited or losing its gateway, jittered to spread the reconnects
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
 }
 
 // GetIpInfoList API adapte application layer
@@ -26,8 +38,84 @@ func GetIpInfoList(c context.Context, ctx *app.RequestContext) {
 	}()
 	// build client request info
 	ipConfCtx := domain.BuildIpConfContext(&c, ctx)
+	if ipLimiter != nil {
+		if ok, wait := ipLimiter.allow(ipConfCtx.ClientCtx.IP, time.Now()); !ok {
+			wait = retryAfter(wait, config.GetIPConfRetryJitter())
+			// Retry-After is in whole seconds
+			ctx.Header("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+			ctx.JSON(consts.StatusTooManyRequests, Response{Message: "too many requests", Code: 1, RetryAfterMs: wait.Milliseconds()})
+			return
+		}
+	}
 	// dispatch request to different endport
 	eds := domain.Dispatch(ipConfCtx)
 	// pack response with top 5 endports
-	ipConfCtx.AppCtx.JSON(consts.StatusOK, packRes(top5Endports(eds)))
+	eds = top5Endports(eds)
+	res := packRes(eds, ipConfCtx.ClientCtx.Protocols)
+	res.Degraded = domain.Degraded()
+	res.RetryAfterMs = retryAfter(config.GetIPConfRetryBase(), config.GetIPConfRetryJitter()).Milliseconds()
+	if did := ipConfCtx.ClientCtx.DeviceID; config.IsTicketEnable() && did != "" {
+		// without a ticket the client can still connect to the gateways not enforcing them
+		tk, err := ticket.Issue(did, endportAddrs(eds))
+		if err != nil {
+			fmt.Printf("[ERROR] issue ticket device=%s: %v\n", did, err)
+		}
+		res.Ticket = tk
+	}
+	ipConfCtx.AppCtx.JSON(consts.StatusOK, res)
+}
+
+// Healthz reports the process is serving
+func Healthz(c context.Context, ctx *app.RequestContext) {
+	ctx.String(consts.StatusOK, "ok")
+}
+
+// Readyz reports the candidate table has been synced from etcd, or loaded from a fallback while etcd is unreachable
+func Readyz(c context.Context, ctx *app.RequestContext) {
+	if !domain.Ready() {
+		ctx.String(consts.StatusServiceUnavailable, "syncing")
+		return
+	}
+	if domain.Degraded() {
+		ctx.String(consts.StatusOK, "degraded")
+		return
+	}
+	ctx.String(consts.StatusOK, "ok")
+}
+
+// GetNodes lists the gateways with their raw and smoothed stats, scores and overrides
+func GetNodes(c context.Context, ctx *app.RequestContext) {
+	ctx.JSON(consts.StatusOK, Response{Message: "ok", Code: 0, Data: domain.Nodes()})
+}
+
+// UpdateNode overrides the weight of a gateway or disables it, the fields left out are unchanged
+// and a weight of 0 restores the reported one, e.g. {"weight": 2} or {"disabled": true}
+func UpdateNode(c context.Context, ctx *app.RequestContext) {
+	var req struct {
+		Weight   *float64 `json:"weight"`
+		Disabled *bool    `json:"disabled"`
+	}
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		ctx.JSON(consts.StatusBadRequest, Response{Message: err.Error(), Code: 1})
+		return
+	}
+	if req.Weight != nil && *req.Weight < 0 {
+		ctx.JSON(consts.StatusBadRequest, Response{Message: "weight must not be negative", Code: 1})
+		return
+	}
+	ip := ctx.Param("ip")
+	o, err := domain.UpdateOverride(ip, func(o *domain.Override) {
+		if req.Weight != nil {
+			o.Weight = *req.Weight
+		}
+		if req.Disabled != nil {
+			o.Disabled = *req.Disabled
+		}
+	})
+	if errors.Is(err, domain.ErrNodeNotFound) {
+		ctx.JSON(consts.StatusNotFound, Response{Message: err.Error(), Code: 1})
+		return
+	}
+	fmt.Printf("[INFO] node %s override %+v by %s\n", ip, o, ctx.RemoteAddr())
+	ctx.JSON(consts.StatusOK, Response{Message: "ok", Code: 0, Data: o})
 }
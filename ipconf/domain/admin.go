@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrNodeNotFound = errors.New("node not found")
+
+// Override is set by the operators, it is kept while the node leaves and rejoins
+type Override struct {
+	Weight   float64 `json:"weight,omitempty"`   // replaces the reported weight when above 0
+	Disabled bool    `json:"disabled,omitempty"` // the node is not dispatched to
+}
+
+// NodeInfo is the admin view of an endport
+type NodeInfo struct {
+	IP         string     `json:"ip"`
+	Port       string     `json:"port"`
+	Region     string     `json:"region,omitempty"`
+	Carrier    string     `json:"carrier,omitempty"`
+	Listeners  []Listener `json:"endpoints"`
+	Raw        *Stat      `json:"raw"`
+	Smoothed   *Stat      `json:"smoothed"`
+	Score      *float64   `json:"score,omitempty"` // by the dispatch strategy, a higher score ranks first
+	Healthy    bool       `json:"healthy"`
+	Override   Override   `json:"override"`
+	LastReport time.Time  `json:"last_report"`
+}
+
+// Ready reports whether the nodes existing at startup have been added
+func Ready() bool {
+	return dp.ready.Load()
+}
+
+// Nodes returns every endport with its stats, score and override, sorted by address
+func Nodes() []*NodeInfo {
+	dp.RLock()
+	defer dp.RUnlock()
+	now := time.Now()
+	sc, _ := dp.strategy.(scorer)
+	nodes := make([]*NodeInfo, 0, len(dp.candidateTable))
+	for _, ed := range dp.candidateTable {
+		node := &NodeInfo{
+			IP:         ed.IP,
+			Port:       ed.Port,
+			Region:     ed.Region,
+			Carrier:    ed.Carrier,
+			Listeners:  ed.Listeners,
+			Raw:        ed.RawStats(),
+			Smoothed:   ed.Stats(),
+			Healthy:    ed.health.healthy(now, dp.health),
+			Override:   ed.override,
+			LastReport: time.Unix(0, ed.health.lastReport.Load()),
+		}
+		if sc != nil {
+			score := sc.score(node.Smoothed)
+			node.Score = &score
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].IP != nodes[j].IP {
+			return nodes[i].IP < nodes[j].IP
+		}
+		return nodes[i].Port < nodes[j].Port
+	})
+	return nodes
+}
+
+// UpdateOverride changes the override of the node and returns it, the zero override is removed
+func UpdateOverride(ip string, update func(o *Override)) (Override, error) {
+	dp.Lock()
+	defer dp.Unlock()
+	ed, ok := dp.candidateTable[ip]
+	if !ok {
+		return Override{}, ErrNodeNotFound
+	}
+	o := ed.override
+	update(&o)
+	if o == (Override{}) {
+		delete(dp.overrides, ip)
+	} else {
+		dp.overrides[ip] = o
+	}
+	ed.setOverride(o)
+	dp.cache.reset()
+	return o, nil
+}
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/feichai0017/GoChat/ipconf/source"
+)
+
+// a dispatcher with the nodes 10.0.0.1 and 10.0.0.2, installed as the global one
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	d := &Dispatcher{
+		candidateTable: make(map[string]*Endport),
+		overrides:      make(map[string]Override),
+		replicas:       10,
+		health:         &healthPolicy{staleAfter: time.Minute},
+		statHorizon:    10 * time.Second,
+	}
+	d.setStrategy(&headroom{defaultMaxConnectNum: 1000})
+	old := dp
+	dp = d
+	t.Cleanup(func() { dp = old })
+	d.addNode(&source.Event{Type: source.AddNodeEvent, IP: "10.0.0.1", Port: "8900", ConnectNum: 100, Weight: 1})
+	d.addNode(&source.Event{Type: source.AddNodeEvent, IP: "10.0.0.2", Port: "8900", ConnectNum: 300, Weight: 1})
+	return d
+}
+
+func TestUpdateOverride(t *testing.T) {
+	d := newTestDispatcher(t)
+	first := func() string {
+		return Dispatch(&IpConfContext{ClientCtx: &ClientContext{}})[0].IP
+	}
+	if got := first(); got != "10.0.0.1" {
+		t.Fatalf("first %s before the override", got)
+	}
+	// (1000-300)*2 > (1000-100)*1
+	if _, err := UpdateOverride("10.0.0.2", func(o *Override) { o.Weight = 2 }); err != nil {
+		t.Fatal(err)
+	}
+	if got := first(); got != "10.0.0.2" {
+		t.Fatalf("first %s after raising the weight", got)
+	}
+	if w := d.candidateTable["10.0.0.2"].RawStats().Weight; w != 1 {
+		t.Fatalf("raw weight %v, want the reported 1", w)
+	}
+	if _, err := UpdateOverride("10.0.0.2", func(o *Override) { o.Disabled = true }); err != nil {
+		t.Fatal(err)
+	}
+	if eds := Dispatch(&IpConfContext{ClientCtx: &ClientContext{}}); len(eds) != 1 || eds[0].IP != "10.0.0.1" {
+		t.Fatalf("disabled node dispatched: %v", rankedIPs(eds))
+	}
+	// the override outlives the node leaving and rejoining
+	d.delNode(&source.Event{Type: source.DelNodeEvent, IP: "10.0.0.2"})
+	d.addNode(&source.Event{Type: source.AddNodeEvent, IP: "10.0.0.2", Port: "8900", ConnectNum: 300, Weight: 1})
+	if o := d.candidateTable["10.0.0.2"].override; o != (Override{Weight: 2, Disabled: true}) {
+		t.Fatalf("override %+v after rejoining", o)
+	}
+	// the zero override is removed
+	if _, err := UpdateOverride("10.0.0.2", func(o *Override) { *o = Override{} }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.overrides["10.0.0.2"]; ok {
+		t.Fatal("zero override kept")
+	}
+	if w := d.candidateTable["10.0.0.2"].Stats().Weight; w != 1 {
+		t.Fatalf("weight %v after clearing the override, want the reported 1", w)
+	}
+	if _, err := UpdateOverride("10.0.0.9", func(o *Override) {}); err != ErrNodeNotFound {
+		t.Fatalf("unknown node: %v", err)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	newTestDispatcher(t)
+	nodes := Nodes()
+	if len(nodes) != 2 || nodes[0].IP != "10.0.0.1" || nodes[1].IP != "10.0.0.2" {
+		t.Fatalf("nodes %+v", nodes)
+	}
+	for _, node := range nodes {
+		if node.Score == nil || node.Raw == nil || !node.Healthy {
+			t.Fatalf("node %+v", node)
+		}
+	}
+	if *nodes[0].Score != 900 || *nodes[1].Score != 700 {
+		t.Fatalf("scores %v and %v", *nodes[0].Score, *nodes[1].Score)
+	}
+}
@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// the clients choose the protocols in the key, so the number of keys is capped
+const maxRankCacheEntries = 1024
+
+// rankCache keeps the ranked candidates for a short time, so a reconnect storm does not rescan and
+// re-sort the candidate table for every request. The stats and the health changes show within the ttl,
+// the nodes joining, leaving or being overridden reset it right away. A nil cache caches nothing.
+type rankCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*rankEntry
+}
+
+type rankEntry struct {
+	eds      []*Endport // never modified, the requests reorder a copy
+	expireAt time.Time
+}
+
+func newRankCache(ttl time.Duration) *rankCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &rankCache{ttl: ttl, entries: make(map[string]*rankEntry)}
+}
+
+// rankKey is made of the inputs of the ranking, the device only matters to the sticky pick done after it
+func rankKey(ctx *IpConfContext) string {
+	if ctx == nil || ctx.ClientCtx == nil {
+		return ""
+	}
+	return ctx.ClientCtx.Region + "|" + ctx.ClientCtx.Carrier + "|" + strings.Join(ctx.ClientCtx.Protocols, ",")
+}
+
+func (c *rankCache) get(key string, now time.Time) ([]*Endport, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expireAt) {
+		return nil, false
+	}
+	return entry.eds, true
+}
+
+func (c *rankCache) put(key string, eds []*Endport, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxRankCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expireAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxRankCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = &rankEntry{eds: eds, expireAt: now.Add(c.ttl)}
+}
+
+func (c *rankCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/feichai0017/GoChat/ipconf/source"
+)
+
+func TestRankCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	eds := testEndports(&Stat{ConnectNum: 1}, &Stat{ConnectNum: 2})
+	c := newRankCache(time.Second)
+	c.put("a", eds, now)
+	tests := []struct {
+		name string
+		key  string
+		now  time.Time
+		hit  bool
+	}{
+		{"within the ttl", "a", now.Add(time.Second), true},
+		{"expired", "a", now.Add(time.Second + 1), false},
+		{"other key", "b", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.get(tt.key, tt.now)
+			if ok != tt.hit || (ok && !reflect.DeepEqual(got, eds)) {
+				t.Fatalf("get %v %v, want hit %v", rankedIPs(got), ok, tt.hit)
+			}
+		})
+	}
+	c.reset()
+	if _, ok := c.get("a", now); ok {
+		t.Fatal("hit after the reset")
+	}
+	// a ttl of 0 disables the cache
+	off := newRankCache(0)
+	off.put("a", eds, now)
+	if _, ok := off.get("a", now); ok {
+		t.Fatal("disabled cache hit")
+	}
+}
+
+func TestDispatchCache(t *testing.T) {
+	d := newTestDispatcher(t)
+	d.cache = newRankCache(time.Minute)
+	ctx := &IpConfContext{ClientCtx: &ClientContext{Region: "sh", DeviceID: "42"}}
+	key := rankKey(ctx)
+	Dispatch(ctx)
+	entry, ok := d.cache.entries[key]
+	if !ok {
+		t.Fatal("ranking not cached")
+	}
+	// the sticky pick reorders a copy, never the cached ranking
+	want := rankedIPs(entry.eds)
+	for i := 0; i < 10; i++ {
+		Dispatch(&IpConfContext{ClientCtx: &ClientContext{Region: "sh", DeviceID: string(rune('a' + i))}})
+	}
+	if got := rankedIPs(d.cache.entries[key].eds); !reflect.DeepEqual(got, want) {
+		t.Fatalf("cached ranking %v, want %v", got, want)
+	}
+	tests := []struct {
+		name   string
+		change func()
+	}{
+		{"node added", func() {
+			d.addNode(&source.Event{Type: source.AddNodeEvent, IP: "10.0.0.3", Port: "8900", ConnectNum: 0, Weight: 1})
+		}},
+		{"node deleted", func() { d.delNode(&source.Event{Type: source.DelNodeEvent, IP: "10.0.0.3"}) }},
+		{"override", func() {
+			if _, err := UpdateOverride("10.0.0.1", func(o *Override) { o.Disabled = true }); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Dispatch(ctx)
+			tt.change()
+			if _, ok := d.cache.entries[key]; ok {
+				t.Fatal("ranking kept")
+			}
+		})
+	}
+	// the override shows right away
+	if eds := Dispatch(ctx); len(eds) != 1 || eds[0].IP != "10.0.0.2" {
+		t.Fatalf("dispatched %v after disabling 10.0.0.1", rankedIPs(eds))
+	}
+}
+
+// random_top_k caches the ranking of its base and still shuffles it for each request
+func TestDispatchCacheRandomTopK(t *testing.T) {
+	d := newTestDispatcher(t)
+	d.cache = newRankCache(time.Minute)
+	r := rand.New(rand.NewSource(1))
+	d.setStrategy(&randomTopK{base: &headroom{defaultMaxConnectNum: 1000}, k: 2, shuffle: r.Shuffle})
+	ctx := &IpConfContext{ClientCtx: &ClientContext{}}
+	firsts := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		firsts[Dispatch(ctx)[0].IP] = true
+	}
+	if len(firsts) != 2 {
+		t.Fatalf("firsts %v, want both nodes", firsts)
+	}
+	if got := rankedIPs(d.cache.entries[rankKey(ctx)].eds); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("cached ranking %v, want the base one", got)
+	}
+}
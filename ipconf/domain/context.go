@@ -13,7 +13,13 @@ type IpConfContext struct {
 }
 
 type ClientContext struct {
-	IP string `json:"ip"`
+	IP      string `json:"ip"`
+	Region  string `json:"region"`
+	Carrier string `json:"carrier"`
+	// the gateway of a device is sticky when the client sends its device id
+	DeviceID string `json:"device_id"`
+	// protocols supported by the client, most preferred first, any protocol if empty
+	Protocols []string `json:"protocols"`
 }
 
 func BuildIpConfContext(c *context.Context, ctx *app.RequestContext) *IpConfContext {
@@ -22,5 +28,10 @@ func BuildIpConfContext(c *context.Context, ctx *app.RequestContext) *IpConfCont
 		AppCtx: 	ctx,
 		ClientCtx: 	&ClientContext{},
 	}
+	ip := resolveClientIP(ctx.RemoteAddr().String(), string(ctx.GetHeader("X-Forwarded-For")), dp.trustedProxies)
+	ipConfCtx.ClientCtx.IP = ip
+	ipConfCtx.ClientCtx.Region, ipConfCtx.ClientCtx.Carrier = dp.regions.lookup(ip)
+	ipConfCtx.ClientCtx.DeviceID = ctx.Query("device_id")
+	ipConfCtx.ClientCtx.Protocols = parseProtocols(ctx.Query("protocols"))
 	return ipConfCtx
 }
\ No newline at end of file
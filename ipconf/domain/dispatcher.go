@@ -1,14 +1,36 @@
 package domain
 
 import (
-	"sort"
+	"fmt"
+	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/feichai0017/GoChat/common/config"
 	"github.com/feichai0017/GoChat/ipconf/source"
 )
 
 type Dispatcher struct {
 	candidateTable map[string]*Endport
+	strategy       Strategy
+	ranker         Strategy                        // the strategy without its shuffle, its ranking is cached
+	shuffle        func(eds []*Endport) []*Endport // applied to the cached ranking for each request, nil if none
+	cache          *rankCache
+	regions        *regionDB
+	trustedProxies []netip.Prefix
+	ring           *hashRing // rebuilt when a node is added or deleted
+	replicas       int
+	loadFactor     float64
+	health         *healthPolicy
+	statHorizon    time.Duration
+	overrides      map[string]Override // by ip, kept while the node is away
+	ready          atomic.Bool         // the nodes existing at startup have been added, or a fallback loaded
+	registryDown   atomic.Bool         // the nodes may be stale
+	fallback       atomic.Bool         // the nodes come from the snapshot or the static endpoints
+	snapshotPath   string
+	static         []string // host:port of the last resort endpoints
 	sync.RWMutex
 }
 
@@ -17,39 +39,148 @@ var dp *Dispatcher
 func Init() {
 	dp = &Dispatcher{}
 	dp.candidateTable = make(map[string]*Endport)
+	dp.overrides = make(map[string]Override)
+	dp.replicas = config.GetIPConfStickyReplicas()
+	dp.loadFactor = config.GetIPConfStickyLoadFactor()
+	dp.statHorizon = config.GetIPConfStatHorizon()
+	dp.health = &healthPolicy{
+		staleAfter:       config.GetIPConfHealthStaleAfter(),
+		probe:            config.IsIPConfHealthProbeEnable(),
+		probeTimeout:     config.GetIPConfHealthProbeTimeout(),
+		failureThreshold: config.GetIPConfHealthProbeFailureThreshold(),
+	}
+	strategy, err := NewStrategy(config.GetIPConfDispatchStrategy())
+	if err != nil {
+		panic(err)
+	}
+	dp.setStrategy(strategy)
+	dp.cache = newRankCache(config.GetIPConfCacheTTL())
+	if dp.regions, err = loadRegionDB(config.GetIPConfRegionDBPath()); err != nil {
+		panic(err)
+	}
+	if dp.trustedProxies, err = parseTrustedProxies(config.GetIPConfTrustedProxies()); err != nil {
+		panic(err)
+	}
+	dp.snapshotPath = config.GetIPConfSnapshotPath()
+	dp.static = config.GetIPConfStaticEndpoints()
+	if _, err = staticSnapshot(dp.static); err != nil {
+		panic(err)
+	}
 	go func() {
 		for event := range source.EventChan() {
-			switch event.Type {
-			case source.AddNodeEvent:
-				dp.addNode(event)
-			case source.DelNodeEvent:
-				dp.delNode(event)
-			}
+			dp.handleEvent(event)
 		}
 	}()
+	if dp.health.probe {
+		go dp.runProber(config.GetIPConfHealthProbeInterval())
+	}
+	if dp.snapshotPath != "" {
+		go dp.runSnapshotter(dp.snapshotPath, config.GetIPConfSnapshotInterval())
+	}
 }
 
-func Dispatch(ctx *IpConfContext) []*Endport {
-	// step 1: get all candidate nodes
-	eds := dp.getCandidateEndport(ctx)
-
-	// step2: calculate the score for each node
-	for _, ed := range eds {
-		ed.CalculateScore(ctx)
+func (dp *Dispatcher) handleEvent(event *source.Event) {
+	switch event.Type {
+	case source.AddNodeEvent:
+		dp.addNode(event)
+	case source.DelNodeEvent:
+		dp.delNode(event)
+	case source.SyncedEvent:
+		dp.sync(event.Listed)
+		dp.fallback.Store(false)
+		dp.registryDown.Store(false)
+		dp.ready.Store(true)
+		fmt.Println("[INFO] ipconf candidate table synced")
+	case source.RegistryDownEvent:
+		dp.registryDown.Store(true)
+		// nothing was listed yet, the last known nodes are better than none
+		if !dp.ready.Load() {
+			dp.loadFallback(dp.snapshotPath, dp.static)
+		}
+	case source.RegistryUpEvent:
+		dp.registryDown.Store(false)
 	}
+	degradedGauge.Set(boolToFloat(dp.degraded()))
+}
+
+// Degraded reports the nodes dispatched may be stale, the registry is unreachable or a fallback is served
+func Degraded() bool {
+	return dp.degraded()
+}
 
-	// step3: sort the nodes by score, with active and static scores
-	sort.Slice(eds, func(i, j int) bool {
-		// active score is more important
-		if eds[i].ActiveScore > eds[j].ActiveScore {
-			return true
+func (dp *Dispatcher) degraded() bool {
+	return dp.registryDown.Load() || dp.fallback.Load()
+}
+
+// sync removes the nodes missing from a new listing of the registry, including the fallback ones
+func (dp *Dispatcher) sync(listed []string) {
+	dp.Lock()
+	defer dp.Unlock()
+	keep := make(map[string]bool, len(listed))
+	for _, ip := range listed {
+		keep[ip] = true
+	}
+	removed := false
+	for ip := range dp.candidateTable {
+		if !keep[ip] {
+			delete(dp.candidateTable, ip)
+			removed = true
 		}
-		// if active score is the same, then sort by static score
-		if eds[i].ActiveScore == eds[j].ActiveScore {
-			return eds[i].StaticScore > eds[j].StaticScore
+	}
+	if removed {
+		dp.nodesChanged()
+	}
+}
+
+// the ranking of a random strategy is cached without the shuffle, so the clients sharing it are still spread
+func (dp *Dispatcher) setStrategy(st Strategy) {
+	dp.strategy, dp.ranker, dp.shuffle = st, st, nil
+	if rt, ok := st.(*randomTopK); ok {
+		dp.ranker, dp.shuffle = rt.base, rt.shuffleTop
+	}
+}
+
+func Dispatch(ctx *IpConfContext) []*Endport {
+	// step 1 and 2: get all candidate nodes and rank them with the configured strategy, the preferred node first
+	eds := dp.rank(ctx)
+
+	// step3: a device keeps landing on the same node, the ranked nodes follow as fallbacks
+	decision := decisionRanked
+	if ctx.ClientCtx != nil && ctx.ClientCtx.DeviceID != "" {
+		dp.RLock()
+		ring := dp.ring
+		dp.RUnlock()
+		if sticky := ring.pick(ctx.ClientCtx.DeviceID, eds, dp.loadFactor); sticky != nil {
+			eds = moveToFront(eds, sticky)
+			decision = decisionSticky
 		}
-		return false
-	})
+	}
+	if len(eds) == 0 {
+		dispatchCounter.WithLabelValues("", decisionNone).Inc()
+	} else {
+		dispatchCounter.WithLabelValues(endportKey(eds[0]), decision).Inc()
+	}
+	return eds
+}
+
+// rank the candidates, the ranking is cached by the inputs of the client since the strategies
+// only rank by the stats of the candidates
+func (dp *Dispatcher) rank(ctx *IpConfContext) []*Endport {
+	now := time.Now()
+	key := rankKey(ctx)
+	eds, ok := dp.cache.get(key, now)
+	if ok {
+		rankCacheCounter.WithLabelValues("hit").Inc()
+	} else {
+		rankCacheCounter.WithLabelValues("miss").Inc()
+		eds = dp.ranker.Rank(ctx, dp.getCandidateEndport(ctx))
+		dp.cache.put(key, eds, now)
+	}
+	// the cached ranking is shared, each request reorders its own copy
+	eds = slices.Clone(eds)
+	if dp.shuffle != nil {
+		eds = dp.shuffle(eds)
+	}
 	return eds
 }
 
@@ -59,10 +190,18 @@ func (dp *Dispatcher) getCandidateEndport(ctx *IpConfContext) []*Endport {
 	// filter the candidate nodes by the given context
 	candidateList := make([]*Endport, 0, len(dp.candidateTable))
 	for _, ed := range dp.candidateTable {
-		candidateList = append(candidateList, ed)
+		// unlike the unhealthy nodes, the disabled nodes are left out even if no other node is left
+		if !ed.override.Disabled {
+			candidateList = append(candidateList, ed)
+		}
+	}
+	// the nodes not serving the protocols of the client are of no use even as a fallback
+	if ctx != nil && ctx.ClientCtx != nil {
+		candidateList = filterProtocols(candidateList, ctx.ClientCtx.Protocols)
 	}
+	candidateList = filterHealthy(candidateList, time.Now(), dp.health)
 
-	return candidateList
+	return preferLocal(ctx, candidateList)
 }
 
 func (dp *Dispatcher) addNode(event *source.Event) {
@@ -72,13 +211,28 @@ func (dp *Dispatcher) addNode(event *source.Event) {
 		ed *Endport
 		ok bool
 	)
-	if ed, ok = dp.candidateTable[event.IP]; !ok {
-		ed = NewEndport(event.IP, event.Port)
+	// the endports are shared by the dispatching requests, so a node whose tags or listeners change is replaced
+	listeners := newListeners(event.Listeners)
+	if ed, ok = dp.candidateTable[event.IP]; ok && (ed.Port != event.Port || ed.Region != event.Region ||
+		ed.Carrier != event.Carrier || !slices.Equal(ed.Listeners, listeners)) {
+		ok = false
+	}
+	if !ok {
+		ed = NewEndport(event.IP, event.Port, dp.statHorizon)
+		ed.Region, ed.Carrier = event.Region, event.Carrier
+		ed.Listeners = listeners
+		ed.override = dp.overrides[event.IP]
 		dp.candidateTable[event.IP] = ed
+		dp.nodesChanged()
 	}
+	ed.health.report(time.Now(), event.Draining)
 	ed.UpdateStat(&Stat{
-		ConnectNum: 	event.ConnectNum,
-		MessageBytes: 	event.MessageBytes,
+		ConnectNum:      event.ConnectNum,
+		MessageBytes:    event.MessageBytes,
+		CPU:             event.CPU,
+		MaxConnectNum:   event.MaxConnectNum,
+		MaxMessageBytes: event.MaxMessageBytes,
+		Weight:          event.Weight,
 	})
 }
 
@@ -86,9 +240,34 @@ func (dp *Dispatcher) delNode(event *source.Event) {
 	dp.Lock()
 	defer dp.Unlock()
 	
-	ed, ok := dp.candidateTable[event.IP]
-	if ok {
-		ed.Close()
+	if _, ok := dp.candidateTable[event.IP]; ok {
 		delete(dp.candidateTable, event.IP)
+		dp.nodesChanged()
+	}
+}
+
+// the nodes joined or left, the caller holds the lock
+func (dp *Dispatcher) nodesChanged() {
+	dp.rebuildRing()
+	dp.cache.reset()
+}
+
+// rebuild the hash ring of the sticky devices, the caller holds the lock
+func (dp *Dispatcher) rebuildRing() {
+	keys := make([]string, 0, len(dp.candidateTable))
+	for _, ed := range dp.candidateTable {
+		keys = append(keys, endportKey(ed))
+	}
+	dp.ring = newHashRing(keys, dp.replicas)
+}
+
+func moveToFront(eds []*Endport, ed *Endport) []*Endport {
+	for i := range eds {
+		if eds[i] == ed {
+			copy(eds[1:i+1], eds[:i])
+			eds[0] = ed
+			break
+		}
 	}
+	return eds
 }
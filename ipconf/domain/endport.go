@@ -2,49 +2,69 @@ package domain
 
 import (
 	"sync/atomic"
-	"unsafe"
+	"time"
 )
 
 type Endport struct {
-	IP 			string 		 `json:"ip"`
-	Port 		string 		 `json:"port"`
-	ActiveScore float64 	 `json:"-"`
-	StaticScore float64 	 `json:"-"`
-	Stats       *Stat  		 `json:"-"`
-	window      *stateWindow `json:"-"`
+	IP      string `json:"ip"`
+	Port    string `json:"port"`
+	Region  string `json:"region,omitempty"`
+	Carrier string `json:"carrier,omitempty"`
+	// the protocols served and their advertised addresses, IP and Port are the registered address
+	Listeners []Listener `json:"endpoints"`
+	// the stats are replaced rather than modified, so the dispatching requests read them without a lock
+	stats  atomic.Pointer[Stat] // smoothed
+	raw    atomic.Pointer[Stat] // latest reported
+	window *stateWindow         // only used by UpdateStat
+	health health
+	// set by the operators, guarded by the dispatcher lock like UpdateStat
+	override Override
 }
 
-
-
-func NewEndport(ip, port string) *Endport {
+func NewEndport(ip, port string, horizon time.Duration) *Endport {
 	ed := &Endport{
-		IP: ip,
-		Port: port,
+		IP:     ip,
+		Port:   port,
+		window: newStateWindow(horizon),
 	}
-	ed.window = newStateWindow()
-	ed.Stats = ed.window.getStat()
-	go func() {
-		for stat := range ed.window.statChan {
-			ed.window.appendStat(stat)
-			newStat := ed.window.getStat()
-			atomic.SwapPointer((*unsafe.Pointer)(unsafe.Pointer(ed.Stats)), unsafe.Pointer(newStat))
-		}
-	}()
+	ed.stats.Store(&Stat{})
 	return ed
 }
 
+// UpdateStat appends the reported stat, it is called by a single goroutine
 func (ed *Endport) UpdateStat(s *Stat) {
-	ed.window.statChan <- s
+	ed.updateStat(s, time.Now())
 }
 
-func (ed *Endport) CalculateScore(ctx *IpConfContext) {
-	// if stats is nil, use the last calculated score
-	if ed.Stats != nil {
-		ed.ActiveScore = ed.Stats.CalculateActiveScore()
-		ed.StaticScore = ed.Stats.CalculateStaticScore()
+func (ed *Endport) updateStat(s *Stat, now time.Time) {
+	ed.raw.Store(s)
+	ed.publish(ed.window.appendStat(s, now))
+}
+
+// setOverride applies the override to the published stats right away
+func (ed *Endport) setOverride(o Override) {
+	ed.override = o
+	ed.publish(ed.window.smoothed)
+}
+
+func (ed *Endport) publish(smoothed *Stat) {
+	if smoothed == nil {
+		smoothed = &Stat{}
+	}
+	if ed.override.Weight > 0 {
+		// the smoothed stat belongs to the window, so the override goes to a copy
+		smoothed = smoothed.Clone()
+		smoothed.Weight = ed.override.Weight
 	}
+	ed.stats.Store(smoothed)
+}
+
+// Stats returns the smoothed stats, they are zero before the first report
+func (ed *Endport) Stats() *Stat {
+	return ed.stats.Load()
 }
 
-func (ed *Endport) Close() {
-	close(ed.window.statChan)
+// RawStats returns the latest reported stats, nil before the first report
+func (ed *Endport) RawStats() *Stat {
+	return ed.raw.Load()
 }
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// health of an endport, it is updated by the stat reports and the prober while the dispatching requests read it
+type health struct {
+	lastReport    atomic.Int64 // unix nano of the last stat report
+	draining      atomic.Bool  // the gateway asked for no new connections
+	probeFailures atomic.Int32 // consecutive failed tcp probes
+}
+
+type healthPolicy struct {
+	staleAfter       time.Duration // an endport not reporting for longer is stale
+	probe            bool
+	probeTimeout     time.Duration
+	failureThreshold int32 // consecutive failed probes before the endport is unhealthy
+}
+
+func (h *health) report(now time.Time, draining bool) {
+	h.lastReport.Store(now.UnixNano())
+	h.draining.Store(draining)
+}
+
+func (h *health) healthy(now time.Time, policy *healthPolicy) bool {
+	if h.draining.Load() {
+		return false
+	}
+	if now.Sub(time.Unix(0, h.lastReport.Load())) > policy.staleAfter {
+		return false
+	}
+	return !policy.probe || h.probeFailures.Load() < policy.failureThreshold
+}
+
+// probe the endport with a tcp connection
+func (h *health) probe(addr string, timeout time.Duration) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		h.probeFailures.Add(1)
+		return
+	}
+	conn.Close()
+	h.probeFailures.Store(0)
+}
+
+// the healthy endports, all of them when none is healthy, a wrong verdict is better than no gateway
+func filterHealthy(eds []*Endport, now time.Time, policy *healthPolicy) []*Endport {
+	healthy := make([]*Endport, 0, len(eds))
+	for _, ed := range eds {
+		if ed.health.healthy(now, policy) {
+			healthy = append(healthy, ed)
+		}
+	}
+	if len(healthy) == 0 {
+		return eds
+	}
+	return healthy
+}
+
+// probe every endport periodically
+func (dp *Dispatcher) runProber(interval time.Duration) {
+	tc := time.NewTicker(interval)
+	defer tc.Stop()
+	for range tc.C {
+		dp.RLock()
+		eds := make([]*Endport, 0, len(dp.candidateTable))
+		for _, ed := range dp.candidateTable {
+			eds = append(eds, ed)
+		}
+		dp.RUnlock()
+		for _, ed := range eds {
+			go ed.health.probe(net.JoinHostPort(ed.IP, ed.Port), dp.health.probeTimeout)
+		}
+	}
+}
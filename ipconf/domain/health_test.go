@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEndportHealthy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	policy := &healthPolicy{staleAfter: 10 * time.Second, probe: true, failureThreshold: 2}
+	tests := []struct {
+		name          string
+		reportedAgo   time.Duration
+		draining      bool
+		probeFailures int32
+		probe         bool
+		want          bool
+	}{
+		{"reporting", time.Second, false, 0, true, true},
+		{"stale", 11 * time.Second, false, 0, true, false},
+		{"at the cutoff", 10 * time.Second, false, 0, true, true},
+		{"draining", time.Second, true, 0, true, false},
+		{"a failed probe is tolerated", time.Second, false, 1, true, true},
+		{"probe failed", time.Second, false, 2, true, false},
+		{"probe disabled", time.Second, false, 5, false, true},
+		{"never reported", 0, false, 0, true, false},
+	}
+	for _, tt := range tests {
+		var h health
+		if tt.reportedAgo > 0 {
+			h.report(now.Add(-tt.reportedAgo), tt.draining)
+		}
+		h.probeFailures.Store(tt.probeFailures)
+		p := *policy
+		p.probe = tt.probe
+		if got := h.healthy(now, &p); got != tt.want {
+			t.Fatalf("%s: healthy %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterHealthy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	policy := &healthPolicy{staleAfter: 10 * time.Second}
+	eds := ringEndports(3)
+	eds[0].health.report(now, false)
+	eds[1].health.report(now, true)
+	eds[2].health.report(now.Add(-time.Minute), false)
+	if got := rankedIPs(filterHealthy(eds, now, policy)); len(got) != 1 || got[0] != "10.0.0.0" {
+		t.Fatalf("healthy endports %v", got)
+	}
+	// every endport looks unhealthy, e.g. the stats stopped flowing, so all of them are kept
+	if got := filterHealthy(eds[1:], now, policy); len(got) != 2 {
+		t.Fatalf("got %d endports, want the fallback to all", len(got))
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	var h health
+	h.probeFailures.Store(2)
+	h.probe(addr, time.Second)
+	if n := h.probeFailures.Load(); n != 0 {
+		t.Fatalf("failures %d after a successful probe", n)
+	}
+	l.Close()
+	h.probe(addr, time.Second)
+	h.probe(addr, time.Second)
+	if n := h.probeFailures.Load(); n != 2 {
+		t.Fatalf("failures %d after two failed probes", n)
+	}
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/feichai0017/GoChat/ipconf/source"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolTLS = "tls"
+	ProtocolWS  = "ws"
+	ProtocolWSS = "wss"
+)
+
+// Listener is a protocol served by an endport at the address advertised to the clients
+type Listener struct {
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+}
+
+func newListeners(ls []source.Listener) []Listener {
+	listeners := make([]Listener, 0, len(ls))
+	for _, l := range ls {
+		listeners = append(listeners, Listener{Protocol: l.Protocol, Host: l.Host, Port: l.Port})
+	}
+	return listeners
+}
+
+// parseProtocols reads the protocols supported by the client, most preferred first, e.g. "wss,tcp"
+func parseProtocols(query string) []string {
+	var protocols []string
+	for _, p := range strings.Split(query, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" && !slices.Contains(protocols, p) {
+			protocols = append(protocols, p)
+		}
+	}
+	return protocols
+}
+
+// Serves reports whether the endport serves any of the protocols, every endport serves a client not telling them
+func (ed *Endport) Serves(protocols []string) bool {
+	return len(ed.ListenersFor(protocols)) > 0
+}
+
+// ListenersFor returns the listeners of the protocols in the order of the client preference,
+// all of them if no protocol is given
+func (ed *Endport) ListenersFor(protocols []string) []Listener {
+	if len(protocols) == 0 {
+		return ed.Listeners
+	}
+	var listeners []Listener
+	for _, p := range protocols {
+		for _, l := range ed.Listeners {
+			if l.Protocol == p {
+				listeners = append(listeners, l)
+			}
+		}
+	}
+	return listeners
+}
+
+func filterProtocols(eds []*Endport, protocols []string) []*Endport {
+	if len(protocols) == 0 {
+		return eds
+	}
+	served := eds[:0]
+	for _, ed := range eds {
+		if ed.Serves(protocols) {
+			served = append(served, ed)
+		}
+	}
+	return served
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/feichai0017/GoChat/ipconf/source"
+)
+
+func TestParseProtocols(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"wss", []string{"wss"}},
+		{"WSS, tcp,,wss", []string{"wss", "tcp"}},
+	}
+	for _, tt := range tests {
+		if got := parseProtocols(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListenersFor(t *testing.T) {
+	tcp := Listener{Protocol: ProtocolTCP, Host: "2001:db8::1", Port: "8900"}
+	ws := Listener{Protocol: ProtocolWS, Host: "gw.example.com", Port: "80"}
+	wss := Listener{Protocol: ProtocolWSS, Host: "gw.example.com", Port: "443"}
+	ed := &Endport{IP: "10.0.0.1", Port: "8900", Listeners: []Listener{tcp, ws, wss}}
+	tests := []struct {
+		name      string
+		protocols []string
+		want      []Listener
+	}{
+		{"any protocol", nil, []Listener{tcp, ws, wss}},
+		{"client preference order", []string{ProtocolWSS, ProtocolTCP}, []Listener{wss, tcp}},
+		{"not served", []string{ProtocolTLS}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ed.ListenersFor(tt.protocols); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if got := ed.Serves(tt.protocols); got != (len(tt.want) > 0) {
+				t.Fatalf("serves %v", got)
+			}
+		})
+	}
+}
+
+func TestDispatchProtocols(t *testing.T) {
+	d := newTestDispatcher(t)
+	d.addNode(&source.Event{
+		Type: source.AddNodeEvent, IP: "2001:db8::2", Port: "8900", ConnectNum: 500,
+		Listeners: []source.Listener{{Protocol: ProtocolTCP, Host: "2001:db8::2", Port: "8900"}, {Protocol: ProtocolWSS, Host: "gw.example.com", Port: "443"}},
+	})
+	dispatch := func(protocols ...string) []string {
+		return rankedIPs(Dispatch(&IpConfContext{ClientCtx: &ClientContext{DeviceID: "d1", Protocols: protocols}}))
+	}
+	if got := dispatch(); len(got) != 3 {
+		t.Fatalf("any protocol: %v", got)
+	}
+	if got := dispatch(ProtocolWSS); !reflect.DeepEqual(got, []string{"2001:db8::2"}) {
+		t.Fatalf("wss: %v", got)
+	}
+	if got := dispatch(ProtocolTLS); len(got) != 0 {
+		t.Fatalf("tls: %v", got)
+	}
+	// a change of the listeners replaces the endport
+	old := d.candidateTable["2001:db8::2"]
+	d.addNode(&source.Event{Type: source.AddNodeEvent, IP: "2001:db8::2", Port: "8900", Listeners: []source.Listener{{Protocol: ProtocolTLS, Host: "2001:db8::2", Port: "8943"}}})
+	if d.candidateTable["2001:db8::2"] == old {
+		t.Fatal("endport kept after the listeners changed")
+	}
+	if got := dispatch(ProtocolTLS); !reflect.DeepEqual(got, []string{"2001:db8::2"}) {
+		t.Fatalf("tls after the change: %v", got)
+	}
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/feichai0017/GoChat/common/crpc/prome"
+)
+
+const nameSpace = "gochat_ipconf"
+
+// how the first endport of a dispatch was chosen
+const (
+	decisionRanked = "ranked" // the best ranked by the strategy
+	decisionSticky = "sticky" // the endport of the device on the hash ring
+	decisionNone   = "none"   // no endport to dispatch to
+)
+
+var (
+	// endpoint is the ip:port of the first endport returned
+	dispatchCounter = prome.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "dispatch",
+			Name:      "total",
+		},
+		[]string{"endpoint", "decision"},
+	)
+
+	// result is one of hit and miss
+	rankCacheCounter = prome.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "rank_cache",
+			Name:      "total",
+		},
+		[]string{"result"},
+	)
+
+	// 1 while the registry is unreachable or the nodes come from the snapshot or the static endpoints
+	degradedGauge = prome.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: nameSpace,
+			Name:      "degraded",
+		},
+	)
+)
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
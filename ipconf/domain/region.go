@@ -0,0 +1,163 @@
+package domain
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// regionDB maps the client ip to its region and carrier, it is loaded from a local file with one
+// "cidr region [carrier]" entry per line, blank lines and lines starting with # are ignored
+type regionDB struct {
+	entries []regionEntry // longest prefix first
+}
+
+type regionEntry struct {
+	prefix  netip.Prefix
+	region  string
+	carrier string
+}
+
+func loadRegionDB(path string) (*regionDB, error) {
+	db := &regionDB{}
+	if path == "" {
+		return db, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("region db %s:%d: want cidr region [carrier]", path, line)
+		}
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("region db %s:%d: %w", path, line, err)
+		}
+		entry := regionEntry{prefix: prefix.Masked(), region: fields[1]}
+		if len(fields) > 2 {
+			entry.carrier = fields[2]
+		}
+		db.entries = append(db.entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(db.entries, func(i, j int) bool {
+		return db.entries[i].prefix.Bits() > db.entries[j].prefix.Bits()
+	})
+	return db, nil
+}
+
+// the region and carrier of the most specific entry containing the ip, empty if unknown
+func (db *regionDB) lookup(ip string) (string, string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || db == nil {
+		return "", ""
+	}
+	addr = addr.Unmap()
+	for _, entry := range db.entries {
+		if entry.prefix.Contains(addr) {
+			return entry.region, entry.carrier
+		}
+	}
+	return "", ""
+}
+
+// parse the trusted proxies, a single ip is taken as a host prefix
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return res, nil
+}
+
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// the ip of the client. X-Forwarded-For is only honoured when the peer is a trusted proxy, and it is
+// walked from the right, the first address not added by a trusted proxy is the client, so a client
+// can not spoof its address by sending the header itself
+func resolveClientIP(remoteAddr, forwardedFor string, trusted []netip.Prefix) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if forwardedFor == "" || !isTrustedProxy(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// a malformed hop can not be trusted, so the last trusted peer is taken as the client
+			return ip
+		}
+		ip = hop
+		if !isTrustedProxy(hop, trusted) {
+			return hop
+		}
+	}
+	return ip
+}
+
+// prefer the endports in the region and on the carrier of the client, then the endports in the region,
+// the other endports are only used when the region has none
+func preferLocal(ctx *IpConfContext, eds []*Endport) []*Endport {
+	if ctx == nil || ctx.ClientCtx == nil || ctx.ClientCtx.Region == "" {
+		return eds
+	}
+	var sameRegion, sameCarrier []*Endport
+	for _, ed := range eds {
+		if ed.Region != ctx.ClientCtx.Region {
+			continue
+		}
+		sameRegion = append(sameRegion, ed)
+		if ctx.ClientCtx.Carrier != "" && ed.Carrier == ctx.ClientCtx.Carrier {
+			sameCarrier = append(sameCarrier, ed)
+		}
+	}
+	if len(sameCarrier) > 0 {
+		return sameCarrier
+	}
+	if len(sameRegion) > 0 {
+		return sameRegion
+	}
+	return eds
+}
@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRegionDBLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "region.db")
+	data := `# cidr region carrier
+10.0.0.0/8      north
+10.1.0.0/16     south  telecom
+2001:db8::/32   east   unicom
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadRegionDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip      string
+		region  string
+		carrier string
+	}{
+		{"10.2.3.4", "north", ""},
+		{"10.1.3.4", "south", "telecom"}, // the most specific entry wins
+		{"::ffff:10.1.3.4", "south", "telecom"},
+		{"2001:db8::1", "east", "unicom"},
+		{"192.168.1.1", "", ""},
+		{"not an ip", "", ""},
+	}
+	for _, tt := range tests {
+		region, carrier := db.lookup(tt.ip)
+		if region != tt.region || carrier != tt.carrier {
+			t.Fatalf("%s: got %s/%s, want %s/%s", tt.ip, region, carrier, tt.region, tt.carrier)
+		}
+	}
+
+	if err = os.WriteFile(path, []byte("10.0.0.0/33 north\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadRegionDB(path); err == nil {
+		t.Fatal("invalid cidr loaded")
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{"direct", "1.2.3.4:5000", "", "1.2.3.4"},
+		{"untrusted peer can not spoof", "1.2.3.4:5000", "5.6.7.8", "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:5000", "5.6.7.8", "5.6.7.8"},
+		{"proxy chain", "192.168.0.1:5000", "5.6.7.8, 10.0.0.2", "5.6.7.8"},
+		{"spoofed hop before the client", "10.0.0.1:5000", "9.9.9.9, 5.6.7.8", "5.6.7.8"},
+		{"only proxies", "10.0.0.1:5000", "10.0.0.2", "10.0.0.2"},
+		{"malformed hop", "10.0.0.1:5000", "garbage", "10.0.0.1"},
+		{"ipv6 peer", "[2001:db8::1]:5000", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		if got := resolveClientIP(tt.remoteAddr, tt.forwardedFor, trusted); got != tt.want {
+			t.Fatalf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreferLocal(t *testing.T) {
+	eds := []*Endport{
+		{IP: "a", Region: "north", Carrier: "telecom"},
+		{IP: "b", Region: "north", Carrier: "unicom"},
+		{IP: "c", Region: "south", Carrier: "telecom"},
+		{IP: "d"},
+	}
+	tests := []struct {
+		name    string
+		region  string
+		carrier string
+		want    []string
+	}{
+		{"same region and carrier", "north", "unicom", []string{"b"}},
+		{"same region", "north", "mobile", []string{"a", "b"}},
+		{"region without carrier", "south", "", []string{"c"}},
+		{"no endport in the region", "west", "telecom", []string{"a", "b", "c", "d"}},
+		{"unknown client", "", "", []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		ctx := &IpConfContext{ClientCtx: &ClientContext{Region: tt.region, Carrier: tt.carrier}}
+		if got := rankedIPs(preferLocal(ctx, eds)); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
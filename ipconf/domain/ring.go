@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hashRing is a consistent hash ring over the endports, each endport is placed at several virtual
+// points so that the devices spread evenly, and adding or removing an endport only moves the devices
+// between it and its neighbours. The ring is immutable, it is rebuilt when the membership changes.
+type hashRing struct {
+	hashes []uint64 // sorted points on the ring
+	nodes  []string // endport key of the point at the same index
+}
+
+func newHashRing(keys []string, replicas int) *hashRing {
+	r := &hashRing{
+		hashes: make([]uint64, 0, len(keys)*replicas),
+		nodes:  make([]string, 0, len(keys)*replicas),
+	}
+	type point struct {
+		hash uint64
+		node string
+	}
+	points := make([]point, 0, len(keys)*replicas)
+	for _, key := range keys {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{hash: xxhash.Sum64String(key + "#" + strconv.Itoa(i)), node: key})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].node < points[j].node
+	})
+	for _, p := range points {
+		r.hashes = append(r.hashes, p.hash)
+		r.nodes = append(r.nodes, p.node)
+	}
+	return r
+}
+
+// walk visits the distinct endports clockwise from the point of the key, until visit returns true
+func (r *hashRing) walk(key string, visit func(node string) bool) {
+	if r == nil || len(r.hashes) == 0 {
+		return
+	}
+	start := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= xxhash.Sum64String(key)
+	})
+	seen := make(map[string]struct{})
+	for i := 0; i < len(r.hashes); i++ {
+		node := r.nodes[(start+i)%len(r.hashes)]
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+		if visit(node) {
+			return
+		}
+	}
+}
+
+func endportKey(ed *Endport) string {
+	return net.JoinHostPort(ed.IP, ed.Port)
+}
+
+// pick the endport of the device among the candidates with consistent hashing and bounded load.
+// An endport is skipped when its connections exceed (1+loadFactor) times the average, so a popular
+// range of the ring does not overload one gateway, the skipped devices land on the next endport.
+func (r *hashRing) pick(deviceID string, eds []*Endport, loadFactor float64) *Endport {
+	if len(eds) == 0 {
+		return nil
+	}
+	candidates := make(map[string]*Endport, len(eds))
+	var total float64
+	for _, ed := range eds {
+		candidates[endportKey(ed)] = ed
+		if stat := ed.Stats(); stat != nil {
+			total += stat.ConnectNum
+		}
+	}
+	// the bound counts the device being placed, so it is always above the load of the least loaded endport
+	bound := math.Ceil((1 + loadFactor) * (total + 1) / float64(len(eds)))
+	var first, picked *Endport
+	r.walk(deviceID, func(node string) bool {
+		ed, ok := candidates[node]
+		if !ok {
+			return false
+		}
+		if first == nil {
+			first = ed
+		}
+		if stat := ed.Stats(); stat == nil || stat.ConnectNum+1 <= bound {
+			picked = ed
+			return true
+		}
+		return false
+	})
+	if picked == nil {
+		// the stats are stale or the candidates are not on the ring yet
+		return first
+	}
+	return picked
+}
@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ringEndports(n int) []*Endport {
+	eds := make([]*Endport, 0, n)
+	for i := 0; i < n; i++ {
+		eds = append(eds, testEndport(fmt.Sprintf("10.0.0.%d", i), &Stat{}))
+	}
+	return eds
+}
+
+func ringOf(eds []*Endport) *hashRing {
+	keys := make([]string, 0, len(eds))
+	for _, ed := range eds {
+		keys = append(keys, endportKey(ed))
+	}
+	return newHashRing(keys, 160)
+}
+
+func TestHashRingSticky(t *testing.T) {
+	eds := ringEndports(5)
+	r := ringOf(eds)
+	for i := 0; i < 100; i++ {
+		device := fmt.Sprintf("device-%d", i)
+		first := r.pick(device, eds, 0.25)
+		// the order of the candidates does not matter
+		reversed := append([]*Endport(nil), eds...)
+		for l, h := 0, len(reversed)-1; l < h; l, h = l+1, h-1 {
+			reversed[l], reversed[h] = reversed[h], reversed[l]
+		}
+		if got := r.pick(device, reversed, 0.25); got != first {
+			t.Fatalf("%s moved from %s to %s", device, endportKey(first), endportKey(got))
+		}
+	}
+}
+
+func TestHashRingMembershipChange(t *testing.T) {
+	const devices = 10000
+	tests := []struct {
+		name   string
+		before int
+		after  int
+	}{
+		{"add a node", 4, 5},
+		{"remove a node", 5, 4},
+		{"add to a large ring", 19, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := ringEndports(5 * tt.after)
+			before, after := all[:tt.before], all[:tt.after]
+			rb, ra := ringOf(before), ringOf(after)
+			moved := 0
+			for i := 0; i < devices; i++ {
+				device := fmt.Sprintf("device-%d", i)
+				if rb.pick(device, before, 0.25) != ra.pick(device, after, 0.25) {
+					moved++
+				}
+			}
+			// ideally 1/max(before, after) of the devices move, allow a margin for the hash variance
+			larger := tt.before
+			if tt.after > larger {
+				larger = tt.after
+			}
+			if limit := devices * 3 / (2 * larger); moved > limit {
+				t.Fatalf("%d devices moved, want at most %d", moved, limit)
+			}
+		})
+	}
+}
+
+func TestHashRingBoundedLoad(t *testing.T) {
+	eds := ringEndports(4)
+	r := ringOf(eds)
+	device := "device-1"
+	home := r.pick(device, eds, 0.25)
+
+	tests := []struct {
+		name     string
+		homeLoad float64
+		moved    bool
+	}{
+		// the other endports have 100 connections each
+		{"within the bound", 120, false}, // bound ceil(1.25*(420+1)/4) = 132
+		{"overloaded", 400, true},        // bound ceil(1.25*(700+1)/4) = 220
+	}
+	for _, tt := range tests {
+		for _, ed := range eds {
+			ed.stats.Store(&Stat{ConnectNum: 100})
+		}
+		home.stats.Store(&Stat{ConnectNum: tt.homeLoad})
+		got := r.pick(device, eds, 0.25)
+		if (got != home) != tt.moved {
+			t.Fatalf("%s: picked %s, home %s", tt.name, endportKey(got), endportKey(home))
+		}
+	}
+}
+
+func TestHashRingCandidates(t *testing.T) {
+	eds := ringEndports(5)
+	r := ringOf(eds)
+	device := "device-7"
+	home := r.pick(device, eds, 0.25)
+	// the endport is filtered out of the request, e.g. in another region, the next endport on the ring is taken
+	rest := make([]*Endport, 0, len(eds)-1)
+	for _, ed := range eds {
+		if ed != home {
+			rest = append(rest, ed)
+		}
+	}
+	if got := r.pick(device, rest, 0.25); got == nil || got == home {
+		t.Fatalf("picked %v without the home endport", got)
+	}
+	// endports not on the ring yet are not picked
+	if got := r.pick(device, ringEndports(7)[5:], 0.25); got != nil {
+		t.Fatalf("picked %s off the ring", endportKey(got))
+	}
+	if got := (*hashRing)(nil).pick(device, eds, 0.25); got != nil {
+		t.Fatal("picked from a nil ring")
+	}
+}
+
+func TestMoveToFront(t *testing.T) {
+	eds := ringEndports(4)
+	got := rankedIPs(moveToFront(append([]*Endport(nil), eds...), eds[2]))
+	want := []string{"10.0.0.2", "10.0.0.0", "10.0.0.1", "10.0.0.3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
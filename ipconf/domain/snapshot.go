@@ -0,0 +1,149 @@
+package domain
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// snapshot of the candidate table, saved to disk so that ipconf can serve the last known
+// gateways when it starts while the registry is unreachable
+type snapshot struct {
+	SavedAt time.Time       `json:"saved_at"`
+	Nodes   []*snapshotNode `json:"nodes"`
+}
+
+type snapshotNode struct {
+	IP        string     `json:"ip"`
+	Port      string     `json:"port"`
+	Region    string     `json:"region,omitempty"`
+	Carrier   string     `json:"carrier,omitempty"`
+	Listeners []Listener `json:"endpoints"`
+	Stat      *Stat      `json:"stat,omitempty"` // latest reported
+}
+
+func (dp *Dispatcher) snapshot(now time.Time) *snapshot {
+	dp.RLock()
+	defer dp.RUnlock()
+	snap := &snapshot{SavedAt: now, Nodes: make([]*snapshotNode, 0, len(dp.candidateTable))}
+	for _, ed := range dp.candidateTable {
+		snap.Nodes = append(snap.Nodes, &snapshotNode{
+			IP:        ed.IP,
+			Port:      ed.Port,
+			Region:    ed.Region,
+			Carrier:   ed.Carrier,
+			Listeners: ed.Listeners,
+			Stat:      ed.RawStats(),
+		})
+	}
+	return snap
+}
+
+// save the snapshot to a temporary file renamed over the path, so a crash never leaves half a snapshot
+func saveSnapshot(path string, snap *snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func loadSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return snap, nil
+}
+
+// staticSnapshot turns the configured host:port addresses into tcp nodes without stats
+func staticSnapshot(addrs []string) (*snapshot, error) {
+	snap := &snapshot{}
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("static endpoint %q: %w", addr, err)
+		}
+		snap.Nodes = append(snap.Nodes, &snapshotNode{
+			IP:        host,
+			Port:      port,
+			Listeners: []Listener{{Protocol: ProtocolTCP, Host: host, Port: port}},
+		})
+	}
+	return snap, nil
+}
+
+// the snapshot is saved while the table follows the registry, not while it is stale or a fallback
+func (dp *Dispatcher) runSnapshotter(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !dp.ready.Load() || dp.degraded() {
+			continue
+		}
+		if err := saveSnapshot(path, dp.snapshot(time.Now())); err != nil {
+			fmt.Printf("[ERROR] save ipconf snapshot %s: %v\n", path, err)
+		}
+	}
+}
+
+// loadFallback fills the empty candidate table from the snapshot, or the static endpoints when there is no
+// snapshot. The nodes are replaced by the registry ones once the registry is listed.
+func (dp *Dispatcher) loadFallback(path string, static []string) {
+	var snap *snapshot
+	var err error
+	if path != "" {
+		if snap, err = loadSnapshot(path); err != nil {
+			fmt.Printf("[ERROR] load ipconf snapshot: %v\n", err)
+		}
+	}
+	from := "static endpoints"
+	if snap != nil && len(snap.Nodes) > 0 {
+		from = "snapshot saved at " + snap.SavedAt.Format(time.RFC3339)
+	} else if snap, err = staticSnapshot(static); err != nil {
+		fmt.Printf("[ERROR] load ipconf static endpoints: %v\n", err)
+		return
+	}
+	if len(snap.Nodes) == 0 {
+		fmt.Println("[ERROR] ipconf has no snapshot nor static endpoints to fall back to")
+		return
+	}
+	dp.Lock()
+	defer dp.Unlock()
+	if len(dp.candidateTable) > 0 {
+		return
+	}
+	for _, node := range snap.Nodes {
+		ed := NewEndport(node.IP, node.Port, dp.statHorizon)
+		ed.Region, ed.Carrier, ed.Listeners = node.Region, node.Carrier, node.Listeners
+		ed.override = dp.overrides[node.IP]
+		if node.Stat != nil {
+			ed.UpdateStat(node.Stat)
+		}
+		dp.candidateTable[node.IP] = ed
+	}
+	dp.nodesChanged()
+	dp.fallback.Store(true)
+	dp.ready.Store(true)
+	fmt.Printf("[INFO] ipconf serves %d nodes from the %s\n", len(snap.Nodes), from)
+}
@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/feichai0017/GoChat/ipconf/source"
+)
+
+func tableIPs(d *Dispatcher) []string {
+	ips := make([]string, 0, len(d.candidateTable))
+	for ip := range d.candidateTable {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	d := newTestDispatcher(t)
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := saveSnapshot(path, d.snapshot(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := loadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := d.snapshot(snap.SavedAt)
+	sort.Slice(snap.Nodes, func(i, j int) bool { return snap.Nodes[i].IP < snap.Nodes[j].IP })
+	sort.Slice(want.Nodes, func(i, j int) bool { return want.Nodes[i].IP < want.Nodes[j].IP })
+	if !reflect.DeepEqual(snap.Nodes, want.Nodes) {
+		t.Fatalf("loaded %+v, want %+v", snap.Nodes, want.Nodes)
+	}
+}
+
+func TestLoadFallback(t *testing.T) {
+	saved := newTestDispatcher(t)
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := saveSnapshot(path, saved.snapshot(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	static := []string{"10.0.1.1:8900", "[2001:db8::1]:8900"}
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"snapshot", path, []string{"10.0.0.1", "10.0.0.2"}},
+		{"static without a snapshot file", filepath.Join(t.TempDir(), "missing.json"), []string{"10.0.1.1", "2001:db8::1"}},
+		{"static without a snapshot path", "", []string{"10.0.1.1", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDispatcher(t)
+			d.candidateTable = make(map[string]*Endport)
+			d.snapshotPath, d.static = tt.path, static
+			d.handleEvent(&source.Event{Type: source.RegistryDownEvent})
+			if got := tableIPs(d); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("nodes %v, want %v", got, tt.want)
+			}
+			if !Ready() || !Degraded() {
+				t.Fatalf("ready %v degraded %v", Ready(), Degraded())
+			}
+			if eds := Dispatch(&IpConfContext{ClientCtx: &ClientContext{DeviceID: "d1"}}); len(eds) != 2 {
+				t.Fatalf("dispatched %v", rankedIPs(eds))
+			}
+			// the registry is back, the nodes it lists replace the fallback ones
+			d.handleEvent(&source.Event{Type: source.RegistryUpEvent})
+			if !Degraded() {
+				t.Fatal("not degraded while serving the fallback")
+			}
+			d.addNode(&source.Event{Type: source.AddNodeEvent, IP: "10.0.0.3", Port: "8900"})
+			d.handleEvent(&source.Event{Type: source.SyncedEvent, Listed: []string{"10.0.0.3"}})
+			if got := tableIPs(d); !reflect.DeepEqual(got, []string{"10.0.0.3"}) {
+				t.Fatalf("nodes %v after the sync", got)
+			}
+			if Degraded() {
+				t.Fatal("degraded after the sync")
+			}
+		})
+	}
+}
+
+// once listed, the registry going down keeps the nodes known and flags them as possibly stale
+func TestRegistryDownAfterSync(t *testing.T) {
+	d := newTestDispatcher(t)
+	d.static = []string{"10.0.1.1:8900"}
+	d.handleEvent(&source.Event{Type: source.SyncedEvent, Listed: []string{"10.0.0.1", "10.0.0.2"}})
+	d.handleEvent(&source.Event{Type: source.RegistryDownEvent})
+	if got := tableIPs(d); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("nodes %v", got)
+	}
+	if !Degraded() {
+		t.Fatal("not degraded while the registry is down")
+	}
+	d.handleEvent(&source.Event{Type: source.RegistryUpEvent})
+	if Degraded() {
+		t.Fatal("degraded after the registry is back")
+	}
+}
+
+func TestStaticSnapshot(t *testing.T) {
+	if _, err := staticSnapshot([]string{"10.0.0.1"}); err == nil {
+		t.Fatal("address without a port accepted")
+	}
+	snap, err := staticSnapshot([]string{"[2001:db8::1]:8900"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Listener{{Protocol: ProtocolTCP, Host: "2001:db8::1", Port: "8900"}}
+	if n := snap.Nodes[0]; n.IP != "2001:db8::1" || n.Port != "8900" || !reflect.DeepEqual(n.Listeners, want) {
+		t.Fatalf("node %+v", n)
+	}
+}
@@ -1,54 +1,25 @@
 package domain
 
-import "math"
-
-
+// the load levels and rates are smoothed over the window, the capacities and the weight are the latest reported
 type Stat struct {
-	ConnectNum   float64 // im gateway rest of connect num
-	MessageBytes float64 // im gateway rest of message bytes
-}
-
-func (s *Stat) CalculateActiveScore() float64 {
-	return getGB(s.MessageBytes)
+	ConnectNum      float64 `json:"connect_num"`       // im gateway connect num
+	MessageBytes    float64 `json:"message_bytes"`     // im gateway message bytes
+	CPU             float64 `json:"cpu"`               // im gateway cpu usage, from 0 to 1
+	ConnectRate     float64 `json:"connect_rate"`      // change of the connect num per second
+	MessageByteRate float64 `json:"message_byte_rate"` // change of the message bytes per second
+	MaxConnectNum   float64 `json:"max_connect_num"`   // connection capacity, 0 if not reported
+	MaxMessageBytes float64 `json:"max_message_bytes"` // message bytes capacity, 0 if not reported
+	Weight          float64 `json:"weight"`            // relative weight, 0 if not reported
 }
 
-func (s *Stat) Avg(num float64) {
-	s.ConnectNum /= num
-	s.MessageBytes /= num
-}
 func (s *Stat) Clone() *Stat {
 	if s == nil {
 		return &Stat{}
 	}
-	newStat := &Stat{
-		MessageBytes: s.MessageBytes,
-		ConnectNum:   s.ConnectNum,
-	}
-	return newStat
-}
-
-func (s *Stat) Add(st *Stat) {
-	if st == nil {
-		return
-	}
-	s.ConnectNum += st.ConnectNum
-	s.MessageBytes += st.MessageBytes
+	newStat := *s
+	return &newStat
 }
 
-func (s *Stat) Sub(st *Stat) {
-	if st == nil {
-		return
-	}
-	s.ConnectNum -= st.ConnectNum
-	s.MessageBytes -= st.MessageBytes
-}
-
-func getGB(m float64) float64 {
-	return decimal(m / (1 << 30))
-}
-func decimal(value float64) float64 {
-	return math.Trunc(value * 1e2 + 0.5) * 1e-2
-}
 func min(a, b, c float64) float64 {
 	m := func(k, j float64) float64 {
 		if k > j {
@@ -58,6 +29,3 @@ func min(a, b, c float64) float64 {
 	}
 	return m(a, m(b, c))
 }
-func (s *Stat) CalculateStaticScore() float64 {
-	return s.ConnectNum
-}
\ No newline at end of file
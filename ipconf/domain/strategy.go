@@ -0,0 +1,180 @@
+package domain
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/feichai0017/GoChat/common/config"
+)
+
+const (
+	StrategyLeastConnections = "least_connections"
+	StrategyHeadroom         = "headroom"
+	StrategyMultiFactor      = "multi_factor"
+	StrategyRandomTopK       = "random_top_k"
+)
+
+// Strategy ranks the candidate endports, the preferred one first
+type Strategy interface {
+	Rank(ctx *IpConfContext, eds []*Endport) []*Endport
+}
+
+// scorer is a strategy ranking by a score of the stats, a higher score first
+type scorer interface {
+	score(s *Stat) float64
+}
+
+// NewStrategy builds the strategy of the given name from the config
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case StrategyLeastConnections:
+		return &leastConnections{}, nil
+	case StrategyHeadroom:
+		return &headroom{defaultMaxConnectNum: config.GetIPConfDispatchDefaultMaxConnectNum()}, nil
+	case StrategyMultiFactor:
+		return &multiFactor{
+			cpuWeight:              config.GetIPConfDispatchCPUWeight(),
+			connWeight:             config.GetIPConfDispatchConnWeight(),
+			bandwidthWeight:        config.GetIPConfDispatchBandwidthWeight(),
+			defaultMaxConnectNum:   config.GetIPConfDispatchDefaultMaxConnectNum(),
+			defaultMaxMessageBytes: config.GetIPConfDispatchDefaultMaxMessageBytes(),
+		}, nil
+	case StrategyRandomTopK:
+		baseName := config.GetIPConfDispatchBaseStrategy()
+		if baseName == StrategyRandomTopK {
+			return nil, fmt.Errorf("base strategy of %s can not be itself", StrategyRandomTopK)
+		}
+		base, err := NewStrategy(baseName)
+		if err != nil {
+			return nil, err
+		}
+		return &randomTopK{base: base, k: config.GetIPConfDispatchTopK(), shuffle: rand.Shuffle}, nil
+	}
+	return nil, fmt.Errorf("unknown dispatch strategy %q", name)
+}
+
+// sort the endports by the score, a higher score first. The scores are kept out of the endports,
+// which are shared by the concurrent requests. Ties are broken by the address, so the order is stable.
+func rankByScore(eds []*Endport, score func(s *Stat) float64) []*Endport {
+	scores := make(map[*Endport]float64, len(eds))
+	for _, ed := range eds {
+		stat := ed.Stats()
+		if stat == nil {
+			stat = &Stat{}
+		}
+		scores[ed] = score(stat)
+	}
+	sort.Slice(eds, func(i, j int) bool {
+		if scores[eds[i]] != scores[eds[j]] {
+			return scores[eds[i]] > scores[eds[j]]
+		}
+		if eds[i].IP != eds[j].IP {
+			return eds[i].IP < eds[j].IP
+		}
+		return eds[i].Port < eds[j].Port
+	})
+	return eds
+}
+
+// usage of the capacity, the default capacity is used when the gateway does not report one
+func usage(level, capacity, defaultCapacity float64) float64 {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if capacity <= 0 {
+		return 0
+	}
+	return level / capacity
+}
+
+// relative weight of the gateway, the admin override replaces the reported one. 1 if neither is set
+func weight(s *Stat) float64 {
+	if s.Weight <= 0 {
+		return 1
+	}
+	return s.Weight
+}
+
+// leastConnections prefers the gateway with the fewest connections per unit of weight
+type leastConnections struct{}
+
+func (st *leastConnections) Rank(ctx *IpConfContext, eds []*Endport) []*Endport {
+	return rankByScore(eds, st.score)
+}
+
+func (st *leastConnections) score(s *Stat) float64 {
+	return -s.ConnectNum / weight(s)
+}
+
+// headroom prefers the gateway with the most free connections, scaled by its weight,
+// so a larger or a preferred gateway takes a larger share
+type headroom struct {
+	defaultMaxConnectNum float64
+}
+
+func (st *headroom) Rank(ctx *IpConfContext, eds []*Endport) []*Endport {
+	return rankByScore(eds, st.score)
+}
+
+func (st *headroom) score(s *Stat) float64 {
+	capacity := s.MaxConnectNum
+	if capacity <= 0 {
+		capacity = st.defaultMaxConnectNum
+	}
+	// an overloaded gateway has a negative headroom, so it still ranks below the others
+	return weight(s) * (capacity - s.ConnectNum)
+}
+
+// multiFactor prefers the least loaded gateway, the load combines the cpu, connection and bandwidth usage
+// and is divided by the weight of the gateway
+type multiFactor struct {
+	cpuWeight              float64
+	connWeight             float64
+	bandwidthWeight        float64
+	defaultMaxConnectNum   float64
+	defaultMaxMessageBytes float64
+}
+
+func (st *multiFactor) Rank(ctx *IpConfContext, eds []*Endport) []*Endport {
+	return rankByScore(eds, st.score)
+}
+
+func (st *multiFactor) score(s *Stat) float64 {
+	load := st.cpuWeight*s.CPU +
+		st.connWeight*usage(s.ConnectNum, s.MaxConnectNum, st.defaultMaxConnectNum) +
+		st.bandwidthWeight*usage(s.MessageBytes, s.MaxMessageBytes, st.defaultMaxMessageBytes)
+	return -load / weight(s)
+}
+
+// randomTopK shuffles the top k endports of the base strategy, so that the clients dispatched
+// between two stat reports do not herd onto the same gateway
+type randomTopK struct {
+	base    Strategy
+	k       int
+	shuffle func(n int, swap func(i, j int))
+}
+
+func (st *randomTopK) Rank(ctx *IpConfContext, eds []*Endport) []*Endport {
+	return st.shuffleTop(st.base.Rank(ctx, eds))
+}
+
+// shuffle the top k of the ranked endports
+func (st *randomTopK) shuffleTop(eds []*Endport) []*Endport {
+	k := st.k
+	if k > len(eds) {
+		k = len(eds)
+	}
+	st.shuffle(k, func(i, j int) {
+		eds[i], eds[j] = eds[j], eds[i]
+	})
+	return eds
+}
+
+// the score of the base strategy, before the shuffle
+func (st *randomTopK) score(s *Stat) float64 {
+	if sc, ok := st.base.(scorer); ok {
+		return sc.score(s)
+	}
+	return 0
+}
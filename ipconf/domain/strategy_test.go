@@ -0,0 +1,198 @@
+package domain
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func testEndport(ip string, stat *Stat) *Endport {
+	ed := &Endport{IP: ip, Port: "8900"}
+	ed.stats.Store(stat)
+	return ed
+}
+
+// endports named a, b, c... with the given stats
+func testEndports(stats ...*Stat) []*Endport {
+	eds := make([]*Endport, 0, len(stats))
+	for i, stat := range stats {
+		eds = append(eds, testEndport(string(rune('a'+i)), stat))
+	}
+	return eds
+}
+
+func rankedIPs(eds []*Endport) []string {
+	ips := make([]string, 0, len(eds))
+	for _, ed := range eds {
+		ips = append(ips, ed.IP)
+	}
+	return ips
+}
+
+func TestLeastConnections(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats []*Stat
+		want  []string
+	}{
+		{"fewest connections first", []*Stat{{ConnectNum: 300}, {ConnectNum: 100}, {ConnectNum: 200}}, []string{"b", "c", "a"}},
+		{"ties by address", []*Stat{{ConnectNum: 100}, {ConnectNum: 100}, {ConnectNum: 50}}, []string{"c", "a", "b"}},
+		{"bytes are ignored", []*Stat{{ConnectNum: 10, MessageBytes: 1 << 30}, {ConnectNum: 20}}, []string{"a", "b"}},
+		{"no stats yet", []*Stat{{ConnectNum: 1}, nil}, []string{"b", "a"}},
+		{"connections per weight", []*Stat{{ConnectNum: 100}, {ConnectNum: 150, Weight: 2}}, []string{"b", "a"}},
+		{"empty", nil, []string{}},
+	}
+	st := &leastConnections{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankedIPs(st.Rank(nil, testEndports(tt.stats...))); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeadroom(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats []*Stat
+		want  []string
+	}{
+		{"larger gateway first", []*Stat{{ConnectNum: 500, MaxConnectNum: 1000}, {ConnectNum: 800, MaxConnectNum: 2000}}, []string{"b", "a"}},
+		{"default capacity", []*Stat{{ConnectNum: 500}, {ConnectNum: 500, MaxConnectNum: 800}}, []string{"a", "b"}},
+		{"weight scales the headroom", []*Stat{{ConnectNum: 0, MaxConnectNum: 1000}, {ConnectNum: 500, MaxConnectNum: 1000, Weight: 3}}, []string{"b", "a"}},
+		{"overloaded last", []*Stat{{ConnectNum: 1200, MaxConnectNum: 1000}, {ConnectNum: 999, MaxConnectNum: 1000}}, []string{"b", "a"}},
+	}
+	st := &headroom{defaultMaxConnectNum: 1000}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankedIPs(st.Rank(nil, testEndports(tt.stats...))); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiFactor(t *testing.T) {
+	tests := []struct {
+		name  string
+		st    *multiFactor
+		stats []*Stat
+		want  []string
+	}{
+		{
+			name:  "cpu only",
+			st:    &multiFactor{cpuWeight: 1},
+			stats: []*Stat{{CPU: 0.9, ConnectNum: 1}, {CPU: 0.2, ConnectNum: 900}},
+			want:  []string{"b", "a"},
+		},
+		{
+			name:  "connection usage of the capacity",
+			st:    &multiFactor{connWeight: 1, defaultMaxConnectNum: 1000},
+			stats: []*Stat{{ConnectNum: 600, MaxConnectNum: 2000}, {ConnectNum: 400}},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "bandwidth usage",
+			st:    &multiFactor{bandwidthWeight: 1, defaultMaxMessageBytes: 100},
+			stats: []*Stat{{MessageBytes: 50}, {MessageBytes: 80, MaxMessageBytes: 200}},
+			want:  []string{"b", "a"},
+		},
+		{
+			name: "combined",
+			st:   &multiFactor{cpuWeight: 0.5, connWeight: 0.5, defaultMaxConnectNum: 100},
+			// loads 0.5*0.8+0.5*0.1=0.45, 0.5*0.3+0.5*0.5=0.4, 0.5*0.1+0.5*0.9=0.5
+			stats: []*Stat{{CPU: 0.8, ConnectNum: 10}, {CPU: 0.3, ConnectNum: 50}, {CPU: 0.1, ConnectNum: 90}},
+			want:  []string{"b", "a", "c"},
+		},
+		{
+			name:  "load per weight",
+			st:    &multiFactor{cpuWeight: 1},
+			stats: []*Stat{{CPU: 0.4}, {CPU: 0.6, Weight: 2}},
+			want:  []string{"b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankedIPs(tt.st.Rank(nil, testEndports(tt.stats...))); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomTopK(t *testing.T) {
+	stats := []*Stat{{ConnectNum: 5}, {ConnectNum: 1}, {ConnectNum: 4}, {ConnectNum: 2}, {ConnectNum: 3}}
+	tests := []struct {
+		name string
+		k    int
+		top  []string // the endports shuffled in the top k
+		rest []string // the endports keeping the base order
+	}{
+		{"top 3", 3, []string{"b", "d", "e"}, []string{"c", "a"}},
+		{"top 1 is the base order", 1, []string{"b"}, []string{"d", "e", "c", "a"}},
+		{"k larger than the candidates", 10, []string{"a", "b", "c", "d", "e"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			st := &randomTopK{base: &leastConnections{}, k: tt.k, shuffle: r.Shuffle}
+			firsts := make(map[string]bool)
+			for i := 0; i < 50; i++ {
+				got := rankedIPs(st.Rank(nil, testEndports(stats...)))
+				top := append([]string(nil), got[:len(tt.top)]...)
+				firsts[top[0]] = true
+				if !sameSet(top, tt.top) {
+					t.Fatalf("top %v, want a permutation of %v", top, tt.top)
+				}
+				if rest := got[len(tt.top):]; !reflect.DeepEqual(rest, tt.rest) {
+					t.Fatalf("rest %v, want %v", rest, tt.rest)
+				}
+			}
+			// the first place is spread over the top k
+			if len(firsts) != len(tt.top) {
+				t.Fatalf("first places %v, want all of %v", firsts, tt.top)
+			}
+		})
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		wantErr bool
+	}{
+		{StrategyLeastConnections, "", false},
+		{StrategyHeadroom, "", false},
+		{StrategyMultiFactor, "", false},
+		{StrategyRandomTopK, StrategyHeadroom, false},
+		{StrategyRandomTopK, StrategyRandomTopK, true},
+		{"round_robin", "", true},
+	}
+	for _, tt := range tests {
+		viper.Set("ip_conf.dispatch.base_strategy", tt.base)
+		if _, err := NewStrategy(tt.name); (err != nil) != tt.wantErr {
+			t.Fatalf("%s with base %q: err %v, want err %v", tt.name, tt.base, err, tt.wantErr)
+		}
+	}
+	viper.Reset()
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+	for _, v := range b {
+		if !set[v] {
+			return false
+		}
+	}
+	return true
+}
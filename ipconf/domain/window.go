@@ -1,36 +1,49 @@
 package domain
 
-const (
-	windowSize = 5
+import (
+	"math"
+	"time"
 )
 
+// stateWindow smooths the reported stats with a moving average decayed by time. A sample weighs by the
+// time since the previous one rather than by its count, so a node reporting more often does not dominate,
+// and a burst fades within the horizon while a steady load stays. It is only used by the reporting goroutine.
 type stateWindow struct {
-	stateQueue []*Stat
-	statChan   chan *Stat
-	sumStat    *Stat
-	idx        int64
+	horizon  time.Duration
+	last     *Stat // the latest raw sample
+	lastAt   time.Time
+	smoothed *Stat
 }
 
-func newStateWindow() *stateWindow {
-	return &stateWindow{
-		stateQueue: make([]*Stat, windowSize),
-		statChan:   make(chan *Stat),
-		sumStat:    &Stat{},
-	}
+func newStateWindow(horizon time.Duration) *stateWindow {
+	return &stateWindow{horizon: horizon}
 }
 
-func (sw *stateWindow) getStat() *Stat {
-	res := sw.sumStat.Clone()
-	res.Avg(windowSize)
-	return res
+// append the sample reported at the given time, the smoothed stat returned is never modified afterwards
+func (sw *stateWindow) appendStat(s *Stat, now time.Time) *Stat {
+	if sw.last == nil {
+		sw.smoothed = s.Clone()
+		sw.last, sw.lastAt = s, now
+		return sw.smoothed
+	}
+	next := sw.smoothed.Clone()
+	if dt := now.Sub(sw.lastAt).Seconds(); dt > 0 {
+		alpha := 1 - math.Exp(-dt/sw.horizon.Seconds())
+		next.ConnectNum = ewma(next.ConnectNum, s.ConnectNum, alpha)
+		next.MessageBytes = ewma(next.MessageBytes, s.MessageBytes, alpha)
+		next.CPU = ewma(next.CPU, s.CPU, alpha)
+		next.ConnectRate = ewma(next.ConnectRate, (s.ConnectNum-sw.last.ConnectNum)/dt, alpha)
+		next.MessageByteRate = ewma(next.MessageByteRate, (s.MessageBytes-sw.last.MessageBytes)/dt, alpha)
+	}
+	// the capacities and the weight are configured rather than measured, the latest one applies
+	next.MaxConnectNum = s.MaxConnectNum
+	next.MaxMessageBytes = s.MaxMessageBytes
+	next.Weight = s.Weight
+	sw.smoothed = next
+	sw.last, sw.lastAt = s, now
+	return next
 }
 
-func (sw *stateWindow) appendStat(s *Stat) {
-	// minus the old stat
-	sw.sumStat.Sub(sw.stateQueue[sw.idx % windowSize])
-	// update the latest stat
-	sw.stateQueue[sw.idx % windowSize] = s
-	// calculate the latest window sum
-	sw.sumStat.Add(s)
-	sw.idx++
-}
\ No newline at end of file
+func ewma(old, sample, alpha float64) float64 {
+	return old + alpha*(sample-old)
+}
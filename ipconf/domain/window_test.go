@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// a sample reported at the offset from the start of the test
+type timedStat struct {
+	at   time.Duration
+	stat *Stat
+}
+
+// constant connections reported every interval during the span, starting at the offset
+func steady(from, span, interval time.Duration, connectNum float64) []timedStat {
+	var samples []timedStat
+	for at := from; at <= from+span; at += interval {
+		samples = append(samples, timedStat{at: at, stat: &Stat{ConnectNum: connectNum}})
+	}
+	return samples
+}
+
+func replay(horizon time.Duration, samples []timedStat) *Stat {
+	start := time.Unix(1700000000, 0)
+	sw := newStateWindow(horizon)
+	var s *Stat
+	for _, sample := range samples {
+		s = sw.appendStat(sample.stat, start.Add(sample.at))
+	}
+	return s
+}
+
+func TestStateWindowLevels(t *testing.T) {
+	burst := append(steady(0, 0, time.Second, 100), timedStat{time.Second, &Stat{ConnectNum: 1000}})
+	burst = append(burst, steady(2*time.Second, 30*time.Second, time.Second, 100)...)
+	tests := []struct {
+		name     string
+		samples  []timedStat
+		min, max float64
+	}{
+		{"first sample is taken as is", steady(0, 0, time.Second, 100), 100, 100},
+		{"steady load stays", steady(0, time.Minute, time.Second, 100), 99.99, 100.01},
+		{"converges to a new level within the horizon", append(steady(0, 0, time.Second, 0), steady(time.Second, 30*time.Second, time.Second, 100)...), 94, 100},
+		{"a recent burst shows", burst[:3], 150, 1000},
+		{"a burst fades after the horizon", burst, 100, 110},
+		{"a sample at the same time is ignored", []timedStat{{0, &Stat{ConnectNum: 100}}, {0, &Stat{ConnectNum: 900}}}, 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replay(10*time.Second, tt.samples).ConnectNum
+			if got < tt.min || got > tt.max {
+				t.Fatalf("connect num %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// the weight of a level depends on how long it lasted, not on how many times it was reported
+func TestStateWindowReportFrequency(t *testing.T) {
+	tests := []struct {
+		name           string
+		slow, frequent time.Duration
+	}{
+		{"10x more often", time.Second, 100 * time.Millisecond},
+		{"uneven intervals", 3 * time.Second, 700 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// both reported 100 for 6s then 200 for 6s
+			slow := replay(10*time.Second, append(steady(0, 6*time.Second, tt.slow, 100), steady(6*time.Second+tt.slow, 6*time.Second, tt.slow, 200)...))
+			frequent := replay(10*time.Second, append(steady(0, 6*time.Second, tt.frequent, 100), steady(6*time.Second+tt.frequent, 6*time.Second, tt.frequent, 200)...))
+			if diff := math.Abs(slow.ConnectNum - frequent.ConnectNum); diff > 15 {
+				t.Fatalf("slow %v and frequent %v reporters differ by %v", slow.ConnectNum, frequent.ConnectNum, diff)
+			}
+		})
+	}
+}
+
+func TestStateWindowRates(t *testing.T) {
+	var samples []timedStat
+	for i := 0; i <= 60; i++ {
+		// 10 connections and 1000 bytes more every second
+		samples = append(samples, timedStat{time.Duration(i) * time.Second, &Stat{ConnectNum: float64(10 * i), MessageBytes: float64(1000 * i), MaxConnectNum: float64(i)}})
+	}
+	got := replay(10*time.Second, samples)
+	if math.Abs(got.ConnectRate-10) > 0.1 {
+		t.Fatalf("connect rate %v, want 10", got.ConnectRate)
+	}
+	if math.Abs(got.MessageByteRate-1000) > 10 {
+		t.Fatalf("message byte rate %v, want 1000", got.MessageByteRate)
+	}
+	// the capacity is not smoothed
+	if got.MaxConnectNum != 60 {
+		t.Fatalf("max connect num %v, want the latest 60", got.MaxConnectNum)
+	}
+}
+
+func TestEndportUpdateStat(t *testing.T) {
+	ed := NewEndport("10.0.0.1", "8900", 10*time.Second)
+	if s := ed.Stats(); s == nil || s.ConnectNum != 0 || ed.RawStats() != nil {
+		t.Fatalf("stats before the first report %+v, raw %+v", s, ed.RawStats())
+	}
+	now := time.Unix(1700000000, 0)
+	ed.updateStat(&Stat{ConnectNum: 100}, now)
+	first := ed.Stats()
+	ed.updateStat(&Stat{ConnectNum: 1000}, now.Add(time.Second))
+	// a published stat is never modified, the readers keep a consistent snapshot
+	if first.ConnectNum != 100 {
+		t.Fatalf("published stat modified to %v", first.ConnectNum)
+	}
+	if raw := ed.RawStats().ConnectNum; raw != 1000 {
+		t.Fatalf("raw connect num %v, want 1000", raw)
+	}
+	if s := ed.Stats().ConnectNum; s <= 100 || s >= 1000 {
+		t.Fatalf("smoothed connect num %v, want between the samples", s)
+	}
+}
@@ -0,0 +1,78 @@
+package ipconf
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+
+	"github.com/feichai0017/GoChat/common/config"
+)
+
+// limiter keeps a token bucket per client ip. A bucket idle long enough to be full again
+// is no different from a new one, so it is dropped by the sweep.
+type limiter struct {
+	rate      float64
+	burst     int64
+	idle      time.Duration // time to fill an empty bucket
+	mu        sync.Mutex
+	buckets   map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+type limiterEntry struct {
+	bucket   *ratelimit.Bucket
+	lastSeen time.Time
+}
+
+func newLimiter(rate float64, burst int64) *limiter {
+	return &limiter{
+		rate:      rate,
+		burst:     burst,
+		idle:      time.Duration(float64(burst) / rate * float64(time.Second)),
+		buckets:   make(map[string]*limiterEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token of the ip, or returns the time until the next token
+func (l *limiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > l.idle {
+		for key, entry := range l.buckets {
+			if now.Sub(entry.lastSeen) > l.idle {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	entry, ok := l.buckets[ip]
+	if !ok {
+		entry = &limiterEntry{bucket: ratelimit.NewBucketWithRate(l.rate, l.burst)}
+		l.buckets[ip] = entry
+	}
+	entry.lastSeen = now
+	if entry.bucket.TakeAvailable(1) == 1 {
+		return true, 0
+	}
+	return false, time.Duration(float64(time.Second) / l.rate)
+}
+
+// nil when the rate limit is disabled
+var ipLimiter *limiter
+
+func initLimiter() {
+	if config.IsIPConfRateLimitEnable() {
+		ipLimiter = newLimiter(config.GetIPConfRateLimitRate(), config.GetIPConfRateLimitBurst())
+	}
+}
+
+// retryAfter adds a random jitter to the wait, so the clients told to wait do not come back together
+func retryAfter(wait, jitter time.Duration) time.Duration {
+	if jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return wait
+}
@@ -0,0 +1,45 @@
+package ipconf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("10.0.0.1", now); !ok {
+			t.Fatalf("request %d of the burst limited", i)
+		}
+	}
+	ok, wait := l.allow("10.0.0.1", now)
+	if ok || wait != time.Millisecond {
+		t.Fatalf("over the burst: allowed %v, wait %v", ok, wait)
+	}
+	if ok, _ := l.allow("10.0.0.2", now); !ok {
+		t.Fatal("other ip limited")
+	}
+	// the idle buckets are dropped
+	l.allow("10.0.0.2", time.Now().Add(time.Second))
+	if _, ok := l.buckets["10.0.0.1"]; ok || len(l.buckets) != 1 {
+		t.Fatalf("%d buckets after the sweep", len(l.buckets))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 50; i++ {
+		d := retryAfter(time.Second, time.Second)
+		if d < time.Second || d >= 2*time.Second {
+			t.Fatalf("retry after %v, want within [1s, 2s)", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("retry after not jittered")
+	}
+	if d := retryAfter(time.Second, 0); d != time.Second {
+		t.Fatalf("retry after %v without jitter", d)
+	}
+}
@@ -0,0 +1,47 @@
+package ipconf
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/feichai0017/GoChat/common/crpc/prome"
+)
+
+const nameSpace = "gochat_ipconf"
+
+var (
+	// path is the route, so the unknown paths share one label
+	requestCounter = prome.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "http",
+			Name:      "requests_total",
+		},
+		[]string{"path", "code"},
+	)
+
+	requestHistogram = prome.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: nameSpace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Buckets:   []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+		},
+		[]string{"path"},
+	)
+)
+
+func metricsMiddleware(c context.Context, ctx *app.RequestContext) {
+	start := time.Now()
+	ctx.Next(c)
+	path := ctx.FullPath()
+	if path == "" {
+		path = "unknown"
+	}
+	requestCounter.WithLabelValues(path, strconv.Itoa(ctx.Response.StatusCode())).Inc()
+	requestHistogram.WithLabelValues(path).Observe(time.Since(start).Seconds())
+}
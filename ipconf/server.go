@@ -1,18 +1,55 @@
 package ipconf
 
 import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+
 	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/crpc/prome"
+	"github.com/feichai0017/GoChat/common/ticket"
 	"github.com/feichai0017/GoChat/ipconf/domain"
 	"github.com/feichai0017/GoChat/ipconf/source"
 )
 
-
 func RunMain(path string) {
 	config.Init(path)
 	source.Init()
 	domain.Init()
-	s := server.Default(server.WithHostPorts(":6789"))
+	initLimiter()
+	if config.IsTicketEnable() {
+		if err := ticket.Init(); err != nil {
+			panic(err)
+		}
+	}
+	if port := config.GetIPConfPrometheusPort(); port != 0 {
+		prome.StartAgent(config.GetIPConfPrometheusHost(), port)
+	}
+	s := server.Default(server.WithHostPorts(config.GetIPConfListenAddr()))
+	s.Use(metricsMiddleware)
 	s.GET("/ip/list", GetIpInfoList)
+	s.GET("/healthz", Healthz)
+	s.GET("/readyz", Readyz)
+	// the admin routes change the dispatching, so they are only served with a token
+	if config.GetIPConfAdminToken() != "" {
+		admin := s.Group("/admin", adminAuth)
+		admin.GET("/nodes", GetNodes)
+		admin.POST("/nodes/:ip", UpdateNode)
+	} else {
+		fmt.Println("[INFO] ip_conf.admin_token is not set, /admin is disabled")
+	}
 	s.Spin()
 }
+
+// the admin routes require "Authorization: Bearer <token>", nothing is accepted without a configured token
+func adminAuth(c context.Context, ctx *app.RequestContext) {
+	token := config.GetIPConfAdminToken()
+	got := string(ctx.GetHeader("Authorization"))
+	if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+		ctx.AbortWithStatusJSON(consts.StatusUnauthorized, Response{Message: "unauthorized", Code: 1})
+	}
+}
@@ -2,6 +2,9 @@ package source
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/bytedance/gopkg/util/logger"
 	"github.com/feichai0017/GoChat/common/config"
@@ -20,14 +23,24 @@ func Init() {
 	}
 }
 
+// DataHandler lists and watches the gateways, the registry being unreachable is reported rather than fatal,
+// and the gateways are listed again once it is back
 func DataHandler(ctx *context.Context) {
 	dis := discovery.NewServiceDiscovery(ctx)
 	defer dis.Close()
 
+	registry := &registryState{}
+	go registry.check(dis, config.GetIPConfRegistryCheckInterval())
+
+	var listed []string // ips of the gateways in the listing, before the watch starts
+	listing := true
 	setFunc := func(key, value string) {
 		if ed, err := discovery.UnMarshal[any]([]byte(value)); err == nil {
 			if event := NewEvent(ed); ed != nil {
 				event.Type = AddNodeEvent
+				if listing {
+					listed = append(listed, event.IP)
+				}
 				eventChan <- event
 			}
 		} else {
@@ -44,8 +57,57 @@ func DataHandler(ctx *context.Context) {
 			logger.CtxErrorf(*ctx, "DataHandler.delFunc.err: %v", err)
 		}
 	}
-	err := dis.WatchService(config.GetServicePathForIPConf(), setFunc, delFunc)
-	if err != nil {
-		panic(err)
+	syncedFunc := func() {
+		listing = false
+		registry.report(nil)
+		eventChan <- &Event{Type: SyncedEvent, Listed: listed}
+	}
+	backoff := time.Second
+	for {
+		listed, listing = nil, true
+		err := dis.WatchServiceSynced(config.GetServicePathForIPConf(), setFunc, delFunc, syncedFunc)
+		if err == nil {
+			// the watch ended, e.g. its revision was compacted, so the gateways are listed again
+			fmt.Println("[INFO] ipconf registry watch ended, listing again")
+			backoff = time.Second
+			continue
+		}
+		fmt.Printf("[ERROR] ipconf list gateways err:%v, retry in %v\n", err, backoff)
+		registry.report(err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// registryState reports the registry going down and coming back once
+type registryState struct {
+	sync.Mutex
+	down bool
+}
+
+func (r *registryState) report(err error) {
+	r.Lock()
+	defer r.Unlock()
+	switch {
+	case err != nil && !r.down:
+		r.down = true
+		eventChan <- &Event{Type: RegistryDownEvent}
+	case err == nil && r.down:
+		r.down = false
+		eventChan <- &Event{Type: RegistryUpEvent}
+	}
+}
+
+func (r *registryState) check(dis *discovery.ServiceDiscovery, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := dis.Ping(config.GetServicePathForIPConf())
+		if err != nil {
+			fmt.Printf("[ERROR] ipconf registry unreachable: %v\n", err)
+		}
+		r.report(err)
 	}
 }
@@ -2,6 +2,10 @@ package source
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
 
 	"github.com/feichai0017/GoChat/common/discovery"
 )
@@ -17,37 +21,131 @@ type EventType string
 const (
 	AddNodeEvent EventType = "addNode"
 	DelNodeEvent EventType = "delNode"
+	// the nodes existing when the watch started have all been sent, the nodes not listed are gone
+	SyncedEvent EventType = "synced"
+	// the registry does not answer, the nodes known may be stale
+	RegistryDownEvent EventType = "registryDown"
+	RegistryUpEvent   EventType = "registryUp"
 )
 
 type Event struct {
-	Type         EventType
-	IP           string
-	Port         string
-	ConnectNum   float64
-	MessageBytes float64
+	Type            EventType
+	IP              string
+	Port            string
+	ConnectNum      float64
+	MessageBytes    float64
+	CPU             float64 // cpu usage of the gateway, from 0 to 1
+	MaxConnectNum   float64 // connection capacity of the gateway, 0 if not reported
+	MaxMessageBytes float64 // message bytes capacity of the gateway, 0 if not reported
+	Weight          float64 // relative weight of the gateway, 0 if not reported
+	Region          string  // region the gateway is deployed in, empty if not tagged
+	Carrier         string  // network carrier of the gateway, empty if not tagged
+	Draining        bool    // the gateway asks for no new connections before it shuts down
+	Listeners       []Listener
+	Listed          []string // ips of the nodes listed, set in a SyncedEvent
+}
+
+// Listener is a protocol served by a gateway, at the address the clients reach it by,
+// which may differ from the registered address the gateway binds
+type Listener struct {
+	Protocol string // tcp, tls, ws or wss
+	Host     string // ip or domain name
+	Port     string
 }
 
 func NewEvent(ed *discovery.EndpointInfo[any]) *Event {
 	if ed == nil || ed.MetaData == nil {
 		return nil
 	}
-	var connNum, msgBytes float64
+	var connNum, msgBytes, cpu, maxConnNum, maxMsgBytes, weight float64
 	if data, ok := ed.MetaData["connect_num"]; ok {
 		connNum = data.(float64) // if err, panic
 	}
 	if data, ok := ed.MetaData["message_bytes"]; ok {
 		msgBytes = data.(float64) // if err, panic
 	}
+	if data, ok := ed.MetaData["cpu"]; ok {
+		cpu = data.(float64)
+	}
+	if data, ok := ed.MetaData["max_connect_num"]; ok {
+		maxConnNum = data.(float64)
+	}
+	if data, ok := ed.MetaData["max_message_bytes"]; ok {
+		maxMsgBytes = data.(float64)
+	}
+	if data, ok := ed.MetaData["weight"]; ok {
+		weight = data.(float64)
+	}
+	region, _ := ed.MetaData["region"].(string)
+	carrier, _ := ed.MetaData["carrier"].(string)
+	draining, _ := ed.MetaData["draining"].(bool)
+	ip := normalizeIP(ed.IP)
+	// the public host of the listeners not giving one, the registered ip by default
+	host, _ := ed.MetaData["advertise_host"].(string)
+	if host == "" {
+		host = ip
+	}
 	return &Event{
-		Type:         AddNodeEvent,
-		IP:           ed.IP,
-		Port:         ed.Port,
-		ConnectNum:   connNum,
-		MessageBytes: msgBytes,
+		Type:            AddNodeEvent,
+		IP:              ip,
+		Port:            ed.Port,
+		ConnectNum:      connNum,
+		MessageBytes:    msgBytes,
+		CPU:             cpu,
+		MaxConnectNum:   maxConnNum,
+		MaxMessageBytes: maxMsgBytes,
+		Weight:          weight,
+		Region:          region,
+		Carrier:         carrier,
+		Draining:        draining,
+		Listeners:       parseListeners(ed.MetaData["endpoints"], host, ed.Port),
 	}
 
 }
 
 func (nd *Event) Key() string {
-	return fmt.Sprintf("%s:%s", nd.IP, nd.Port)
+	return net.JoinHostPort(nd.IP, nd.Port)
+}
+
+// the same address is written in several ways in ipv6, so the ips are compared in the canonical form
+func normalizeIP(ip string) string {
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return ip
+	}
+	return addr.Unmap().String()
+}
+
+// the endpoints metadata is a list of {"protocol", "host", "port"}, the host defaults to the advertised host.
+// A gateway not reporting it serves tcp on the registered port.
+func parseListeners(data any, host, port string) []Listener {
+	items, _ := data.([]any)
+	listeners := make([]Listener, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		l := Listener{Host: host}
+		l.Protocol, _ = m["protocol"].(string)
+		l.Protocol = strings.ToLower(l.Protocol)
+		if h, _ := m["host"].(string); h != "" {
+			l.Host = normalizeIP(h)
+		}
+		switch p := m["port"].(type) {
+		case string:
+			l.Port = p
+		case float64:
+			l.Port = strconv.Itoa(int(p))
+		}
+		if l.Protocol == "" || l.Port == "" {
+			fmt.Printf("[ERROR] invalid endpoint %v of %s\n", item, net.JoinHostPort(host, port))
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		listeners = append(listeners, Listener{Protocol: "tcp", Host: host, Port: port})
+	}
+	return listeners
 }
@@ -0,0 +1,60 @@
+package source
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/feichai0017/GoChat/common/discovery"
+)
+
+func TestNewEventListeners(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		meta map[string]any
+		want []Listener
+	}{
+		{
+			name: "tcp on the registered address by default",
+			ip:   "10.0.0.1",
+			meta: map[string]any{},
+			want: []Listener{{"tcp", "10.0.0.1", "8900"}},
+		},
+		{
+			name: "advertised host",
+			ip:   "10.0.0.1",
+			meta: map[string]any{"advertise_host": "gw1.example.com"},
+			want: []Listener{{"tcp", "gw1.example.com", "8900"}},
+		},
+		{
+			name: "several protocols",
+			ip:   "10.0.0.1",
+			meta: map[string]any{
+				"advertise_host": "203.0.113.1",
+				"endpoints": []any{
+					map[string]any{"protocol": "TCP", "port": float64(8900)},
+					map[string]any{"protocol": "wss", "host": "ws.example.com", "port": "443"},
+					map[string]any{"protocol": "ws"}, // no port
+				},
+			},
+			want: []Listener{{"tcp", "203.0.113.1", "8900"}, {"wss", "ws.example.com", "443"}},
+		},
+		{
+			name: "ipv6 in the canonical form",
+			ip:   "[2001:DB8:0::1]",
+			meta: map[string]any{"endpoints": []any{map[string]any{"protocol": "tls", "host": "2001:db8:0:0::2", "port": "8943"}}},
+			want: []Listener{{"tls", "2001:db8::2", "8943"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := NewEvent(&discovery.EndpointInfo[any]{IP: tt.ip, Port: "8900", MetaData: tt.meta})
+			if !reflect.DeepEqual(event.Listeners, tt.want) {
+				t.Fatalf("listeners %v, want %v", event.Listeners, tt.want)
+			}
+		})
+	}
+	if event := NewEvent(&discovery.EndpointInfo[any]{IP: "::ffff:10.0.0.1", Port: "8900", MetaData: map[string]any{}}); event.IP != "10.0.0.1" {
+		t.Fatalf("ip %s, want the ipv4 address", event.IP)
+	}
+}
@@ -18,8 +18,11 @@ func testServiceRegister(ctx *context.Context, port string, node string) {
 		Port: port,
 		// Ensure metadata values are stored as float64
 		MetaData: map[string]any{
-			"connect_num":   float64(rand.Intn(1000)),
-			"message_bytes": float64(rand.Intn(100000)),
+			"connect_num":       float64(rand.Intn(1000)),
+			"message_bytes":     float64(rand.Intn(100000)),
+			"cpu":               rand.Float64(),
+			"max_connect_num":   float64(1000),
+			"max_message_bytes": float64(100000),
 		},
 	}
 
@@ -41,8 +44,11 @@ func testServiceRegister(ctx *context.Context, port string, node string) {
 				IP:   "127.0.0.1",
 				Port: port,
 				MetaData: map[string]any{
-					"connect_num":   float64(rand.Intn(1000)),
-					"message_bytes": float64(rand.Intn(100000)),
+					"connect_num":       float64(rand.Intn(1000)),
+					"message_bytes":     float64(rand.Intn(100000)),
+					"cpu":               rand.Float64(),
+					"max_connect_num":   float64(1000),
+					"max_message_bytes": float64(100000),
 				},
 			}
 			sre.UpdateValue(ed)
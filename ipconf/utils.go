@@ -1,6 +1,8 @@
 package ipconf
 
 import (
+	"net"
+
 	"github.com/feichai0017/GoChat/ipconf/domain"
 )
 
@@ -11,10 +13,56 @@ func top5Endports(eds []*domain.Endport) []*domain.Endport {
 	return eds[:5]
 }
 
-func packRes(ed []*domain.Endport) Response {
+// Node is a gateway in the /ip/list response
+type Node struct {
+	// the advertised address of the preferred tcp listener, for the clients reading a single address
+	IP        string            `json:"ip"`
+	Port      string            `json:"port"`
+	Region    string            `json:"region,omitempty"`
+	Carrier   string            `json:"carrier,omitempty"`
+	Endpoints []domain.Listener `json:"endpoints"` // the protocols asked by the client, in the order of its preference
+}
+
+func packRes(eds []*domain.Endport, protocols []string) Response {
+	nodes := make([]*Node, 0, len(eds))
+	for _, ed := range eds {
+		node := &Node{
+			IP:        ed.IP,
+			Port:      ed.Port,
+			Region:    ed.Region,
+			Carrier:   ed.Carrier,
+			Endpoints: ed.ListenersFor(protocols),
+		}
+		if l, ok := preferredListener(node.Endpoints); ok {
+			node.IP, node.Port = l.Host, l.Port
+		}
+		nodes = append(nodes, node)
+	}
 	return Response{
 		Message: "ok",
 		Code:    0,
-		Data:    ed,
+		Data:    nodes,
+	}
+}
+
+// the first tcp listener, or the first listener if none serves tcp
+func preferredListener(listeners []domain.Listener) (domain.Listener, bool) {
+	for _, l := range listeners {
+		if l.Protocol == domain.ProtocolTCP {
+			return l, true
+		}
+	}
+	if len(listeners) > 0 {
+		return listeners[0], true
+	}
+	return domain.Listener{}, false
+}
+
+// ip:port of the endports, the gateways a ticket is valid for
+func endportAddrs(eds []*domain.Endport) []string {
+	addrs := make([]string, 0, len(eds))
+	for _, ed := range eds {
+		addrs = append(addrs, net.JoinHostPort(ed.IP, ed.Port))
 	}
-}
\ No newline at end of file
+	return addrs
+}
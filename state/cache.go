@@ -7,12 +7,15 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/feichai0017/GoChat/common/cache"
 	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/discovery"
 	"github.com/feichai0017/GoChat/common/idl/message"
 	"github.com/feichai0017/GoChat/common/router"
 	"github.com/feichai0017/GoChat/state/rpc/service"
+	"github.com/juju/ratelimit"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -20,102 +23,219 @@ var cs *cacheState
 
 // remote cache state
 type cacheState struct {
-	msgID            uint64 // test
 	connToStateTable sync.Map
 	server           *service.Service
+	slots            *discovery.SlotManager // nil when the slot range is owned statically
+	retransmit       *retransmitPolicy
+	workers          *cmdWorkers
+	store            cache.Store
+	router           *router.Table
+}
+
+// the state is kept in the store, redis in production or memory in tests
+func newCacheState(store cache.Store) *cacheState {
+	return &cacheState{
+		retransmit: newRetransmitPolicy(),
+		store:      store,
+		router:     router.NewTable(store),
+	}
 }
 
 // initialize global cache
 func InitCacheState(ctx context.Context) {
-	cs = &cacheState{}
-	cache.InitRedis(ctx)
-	router.Init(ctx)
-	cs.connToStateTable = sync.Map{}
-	cs.initLoginSlot(ctx)
-	cs.server = &service.Service{CmdChannel: make(chan *service.CmdContext, config.GetSateCmdChannelNum())}
+	cs = newCacheState(cache.InitRedis(ctx))
+	if config.IsStateSlotOwnershipEnable() {
+		cs.initSlotManager(ctx)
+	} else {
+		cs.initLoginSlot(ctx)
+	}
+	cs.workers = newCmdWorkers(config.GetStateCmdWorkerNum(), config.GetSateCmdChannelNum(), handleCmd, cmdConnIDs)
+	cs.server = &service.Service{
+		Dispatch:      cs.workers.tryDispatch,
+		Owns:          cs.ownsConn,
+		PresenceQuery: cs.queryPresence,
+		UnreadQuery:   cs.queryUnread,
+	}
 }
 
 // initialize connection login slot
 func (cs *cacheState) initLoginSlot(ctx context.Context) error {
 	loginSlotRange := config.GetStateServerLoginSlotRange()
 	for _, slot := range loginSlotRange {
-		loginSlotKey := fmt.Sprintf(cache.LoginSlotSetKey, slot)
 		// async parallel processing
-		go func() {
-			// here can use lua script for batch processing
-			loginSlot, err := cache.SmembersStrSlice(ctx, loginSlotKey)
-			if err != nil {
-				panic(err)
-			}
-			for _, mate := range loginSlot {
-				did, connID := cs.loginSlotUnmarshal(mate)
-				cs.connReLogin(ctx, did, connID)
-			}
-		}()
+		go cs.loadLoginSlot(ctx, slot)
 	}
 	return nil
 }
 
-func (cs *cacheState) newConnState(did, connID uint64) *connState {
+// coordinate the slot ownership with other state servers, the slots taken over are recovered from the login slot
+func (cs *cacheState) initSlotManager(ctx context.Context) {
+	endpoint := fmt.Sprintf("%s:%d", config.GetSateServiceAddr(), config.GetSateServerPort())
+	slots, err := discovery.NewSlotManager(&ctx, config.GetStateSlotOwnershipPath(), endpoint,
+		config.GetStateServerLoginSlotRange(), config.GetStateSlotOwnershipLease(),
+		func(slot int) { go cs.loadLoginSlot(ctx, slot) },
+		cs.releaseLoginSlot)
+	if err != nil {
+		panic(err)
+	}
+	cs.slots = slots
+	if err = cs.slots.Start(); err != nil {
+		panic(err)
+	}
+}
+
+// recover the connection states of the slot
+func (cs *cacheState) loadLoginSlot(ctx context.Context, slot int) {
+	loginSlotKey := fmt.Sprintf(cache.LoginSlotSetKey, slot)
+	// here can use lua script for batch processing
+	loginSlot, err := cs.store.SmembersStrSlice(ctx, loginSlotKey)
+	if err != nil {
+		panic(err)
+	}
+	for _, mate := range loginSlot {
+		did, connID, uid, heartbeat := cs.loginSlotUnmarshal(mate)
+		if _, ok := cs.loadConnIDState(connID); ok {
+			continue
+		}
+		cs.connReLogin(ctx, uid, did, connID, heartbeat)
+	}
+}
+
+// whether the slot of the connection is served by this state server, another server may hold it after a handover
+func (cs *cacheState) ownsConn(connID uint64) bool {
+	if cs.slots == nil {
+		return true
+	}
+	return cs.slots.Owns(cs.getSlot(connID))
+}
+
+// stop serving the slot, the distributed states are kept for the new owner
+func (cs *cacheState) releaseLoginSlot(slot int) {
+	cs.connToStateTable.Range(func(key, value any) bool {
+		state, _ := value.(*connState)
+		if cs.getSlot(state.connID) == slot {
+			state.stopTimers()
+			cs.connToStateTable.Delete(key)
+		}
+		return true
+	})
+}
+
+func (cs *cacheState) newConnState(uid, did, connID uint64, heartbeat time.Duration) *connState {
 	// create connection state object
-	state := &connState{connID: connID, did: did}
+	state := &connState{
+		connID:       connID,
+		did:          did,
+		uid:          uid,
+		heartbeat:    heartbeat,
+		signalBucket: ratelimit.NewBucketWithRate(config.GetStateSignalRate(), config.GetStateSignalBurst()),
+	}
 	// start heartbeat timer
 	state.reSetHeartTimer()
 	return state
 }
 
-func (cs *cacheState) connLogin(ctx context.Context, did, connID uint64) error {
-	state := cs.newConnState(did, connID)
+func (cs *cacheState) connLogin(ctx context.Context, endpoint string, uid, did, connID uint64, heartbeat time.Duration) error {
+	state := cs.newConnState(uid, did, connID, heartbeat)
 	// login slot storage
 	slotKey := cs.getLoginSlotKey(connID)
-	meta := cs.loginSlotMarshal(did, connID)
-	err := cache.SADD(ctx, slotKey, meta)
+	meta := cs.loginSlotMarshal(did, connID, uid, heartbeat)
+	err := cs.store.SADD(ctx, slotKey, meta)
 	if err != nil {
 		return err
 	}
 
-	// add routing record
-	endPoint := fmt.Sprintf("%s:%d", config.GetGatewayServiceAddr(), config.GetSateServerPort())
-	err = router.AddRecord(ctx, did, endPoint, connID)
+	// add routing record, the endpoint is the gateway holding the connection
+	err = cs.router.AddRecord(ctx, did, endpoint, connID)
 	if err != nil {
 		return err
 	}
 
+	// user devices index, used to fan out messages to every device of the user
+	if uid != 0 {
+		if err = cs.router.AddUserDevice(ctx, uid, did); err != nil {
+			return err
+		}
+	}
+
 	//TODO: upstream message max_client_id initialization, now is life cycle in conn dimension, will be adjusted to session dimension later when refactoring sdk
 
 	// local state storage
 	cs.storeConnIDState(connID, state)
+
+	// presence online, subscribers are notified when the device comes online
+	if err = cs.presenceOnline(ctx, uid, did); err != nil {
+		return err
+	}
+	// queue the messages of the inbox the device missed while it was offline,
+	// then deliver them with the ones which exhausted their retries while the device was unreachable
+	if err = cs.syncDeviceMsg(ctx, uid, did); err != nil {
+		return err
+	}
+	if err = cs.syncOfflineMsg(ctx, did, connID); err != nil {
+		return err
+	}
+	// then the recalls and edits missed while offline
+	if err = cs.syncOfflineModify(ctx, did, connID); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (cs *cacheState) connReLogin(ctx context.Context, did, connID uint64) {
-	state := cs.newConnState(did, connID)
+func (cs *cacheState) connReLogin(ctx context.Context, uid, did, connID uint64, heartbeat time.Duration) {
+	state := cs.newConnState(uid, did, connID, heartbeat)
 	cs.storeConnIDState(connID, state)
 	state.loadMsgTimer(ctx)
+	if err := cs.migrateConnKeys(ctx, connID); err != nil {
+		fmt.Printf("[ERROR] migrateConnKeys connID=%d:err=%s\n", connID, err.Error())
+	}
 }
 
 func (cs *cacheState) connLogOut(ctx context.Context, connID uint64) (uint64, error) {
 	if state, ok := cs.loadConnIDState(connID); ok {
-		did := state.did
-		return did, state.close(ctx)
+		did, uid := state.did, state.uid
+		// the subscriptions are read before the cleanup deletes the keys of the connection
+		if err := cs.unsubscribeAllPresence(ctx, connID); err != nil {
+			return did, err
+		}
+		if err := state.close(ctx); err != nil {
+			return did, err
+		}
+		if uid != 0 {
+			if err := cs.router.DelUserDevice(ctx, uid, did); err != nil {
+				return did, err
+			}
+		}
+		return did, cs.presenceOffline(ctx, uid, did)
 	}
 	return 0, nil
 }
 
-func (cs *cacheState) reConn(ctx context.Context, oldConnID, newConnID uint64) error {
-	var (
-		did uint64
-		err error
-	)
-	if did, err = cs.connLogOut(ctx, oldConnID); err != nil {
-		return err
+func (cs *cacheState) reConn(ctx context.Context, endpoint string, oldConnID, newConnID uint64) error {
+	var did, uid uint64
+	heartbeat := negotiateHeartbeat(0, "")
+	// the device stays online during re-connection, so presence is not touched here
+	if state, ok := cs.loadConnIDState(oldConnID); ok {
+		did, uid, heartbeat = state.did, state.uid, state.heartbeat
+		if err := cs.unsubscribeAllPresence(ctx, oldConnID); err != nil {
+			return err
+		}
+		if err := state.close(ctx); err != nil {
+			return err
+		}
 	}
-	return cs.connLogin(ctx, did, newConnID) // re-connection routing does not need to be updated
+	return cs.connLogin(ctx, endpoint, uid, did, newConnID, heartbeat)
 }
 
-func (cs *cacheState) reSetHeartTimer(connID uint64) {
+func (cs *cacheState) reSetHeartTimer(ctx context.Context, connID uint64) {
 	if state, ok := cs.loadConnIDState(connID); ok {
 		state.reSetHeartTimer()
+		// refresh the last active time at most once per interval
+		if state.touchActive(config.GetStatePresenceActiveInterval()) {
+			if err := cs.presenceTouch(ctx, state.uid, state.did); err != nil {
+				fmt.Printf("[ERROR] presenceTouch:err=%s\n", err.Error())
+			}
+		}
 	}
 }
 func (cs *cacheState) loadConnIDState(connID uint64) (*connState, bool) {
@@ -135,33 +255,71 @@ func (cs *cacheState) storeConnIDState(connID uint64, state *connState) {
 
 // get login slot key
 func (cs *cacheState) getLoginSlotKey(connID uint64) string {
-	connStateSlotList := config.GetStateServerLoginSlotRange()
-	slotSize := uint64(len(connStateSlotList))
-	slot := connID % slotSize
-	slotKey := fmt.Sprintf(cache.LoginSlotSetKey, connStateSlotList[slot])
-	return slotKey
+	return cache.LoginSlotKey(config.GetStateServerLoginSlotRange(), connID)
 }
 
-func (cs *cacheState) getConnStateSlot(connID uint64) uint64 {
-	connStateSlotList := config.GetStateServerLoginSlotRange()
-	slotSize := uint64(len(connStateSlotList))
-	return connID % slotSize
+// the index of the keys created for the connection, they are deleted together when the connection is closed
+func (cs *cacheState) getConnKeysKey(connID uint64) string {
+	return cs.connKey(cache.ConnKeysKey, connID)
 }
 
-// use lua to implement compare and increment
-func (cs *cacheState) compareAndIncrClientID(ctx context.Context, connID, oldMaxClientID uint64) bool {
-	slot := cs.getConnStateSlot(connID)
-	key := fmt.Sprintf(cache.MaxClientIDKey, slot, connID)
-	fmt.Printf("RunLuaInt %s, %d, %d\n", key, oldMaxClientID, cache.TTL7D)
-	var (
-		res int
-		err error
+// every key of the connection is tagged with its slot, the same one as its login slot set
+func (cs *cacheState) connKey(format string, connID uint64) string {
+	return cache.ConnKey(format, config.GetStateServerLoginSlotRange(), connID)
+}
+
+// connections logged in before the key index existed have their keys at the fixed names without an index,
+// index them when the connection is restored so that the cleanup deletes them
+func (cs *cacheState) migrateConnKeys(ctx context.Context, connID uint64) error {
+	return cs.store.SAddIndex(ctx, cs.getConnKeysKey(connID), cache.TTL7D,
+		cs.connKey(cache.MaxClientIDKey, connID),
+		cs.connKey(cache.UpMsgDedupKey, connID),
+		cs.connKey(cache.LastMsgKey, connID),
+		cs.connKey(cache.ConnPresenceSubKey, connID),
 	)
-	if res, err = cache.RunLuaInt(ctx, cache.LuaCompareAndIncrClientID, []string{key}, oldMaxClientID, cache.TTL7D); err != nil {
-		// LOG ... here should print log
-		panic(err)
+}
+
+// get the slot of the connection, which is the unit of ownership between state servers
+func (cs *cacheState) getSlot(connID uint64) int {
+	return cache.ConnSlot(config.GetStateServerLoginSlotRange(), connID)
+}
+
+// the outcomes of accepting a ClientID, the compare and increment runs as a lua script
+const (
+	clientIDAccepted   = 1
+	clientIDDuplicate  = 2
+	clientIDOutOfOrder = 3
+	clientIDInProgress = 4 // accepted, but the msgID is not recorded yet
+)
+
+// accept the next ClientID of the connection, a duplicate returns the msgID assigned when it was accepted,
+// an out of order ClientID returns the expected one
+func (cs *cacheState) acceptClientID(ctx context.Context, connID, clientID uint64) (int64, uint64, error) {
+	return cs.store.AcceptClientID(ctx,
+		cs.connKey(cache.MaxClientIDKey, connID),
+		cs.connKey(cache.UpMsgDedupKey, connID),
+		cs.getConnKeysKey(connID),
+		clientID, cache.TTL7D)
+}
+
+// remember the msgID of the accepted ClientID, only the latest window of ClientIDs is kept
+func (cs *cacheState) recordClientID(ctx context.Context, connID, clientID, msgID uint64) error {
+	key := cs.connKey(cache.UpMsgDedupKey, connID)
+	if err := cs.store.HSet(ctx, key, strconv.FormatUint(clientID, 10), msgID, cache.TTL7D); err != nil {
+		return err
 	}
-	return res > 0
+	if window := config.GetStateUpMsgDedupWindow(); clientID >= window {
+		return cs.store.HDel(ctx, key, strconv.FormatUint(clientID-window, 10))
+	}
+	return nil
+}
+
+// give the ClientID back when the message was not delivered, so that the resent message is accepted again
+func (cs *cacheState) releaseClientID(ctx context.Context, connID, clientID uint64) (bool, error) {
+	return cs.store.ReleaseClientID(ctx,
+		cs.connKey(cache.MaxClientIDKey, connID),
+		cs.connKey(cache.UpMsgDedupKey, connID),
+		clientID, cache.TTL7D)
 }
 
 // operate last msg structure
@@ -176,30 +334,40 @@ func (cs *cacheState) appendLastMsg(ctx context.Context, connID uint64, pushMsg
 	if state, ok = cs.loadConnIDState(connID); !ok {
 		return errors.New("connID state is nil")
 	}
-	slot := cs.getConnStateSlot(connID)
-	key := fmt.Sprintf(cache.LastMsgKey, slot, connID)
+	key := cs.connKey(cache.LastMsgKey, connID)
 	// TODO: now assume that a connection has only one session, will be refactored later when IMserver is refactored
-	msgTimerLock := fmt.Sprintf("%d_%d", pushMsg.SessionID, pushMsg.MsgID)
 	msgData, _ := proto.Marshal(pushMsg)
-	state.appendMsg(ctx, key, msgTimerLock, msgData)
+	state.appendMsg(ctx, key, pushMsgKey(pushMsg).String(), msgData)
 	return nil
 }
 
-func (cs *cacheState) ackLastMsg(ctx context.Context, connID, sessionID, msgID uint64) {
+func (cs *cacheState) ackLastMsg(ctx context.Context, connID uint64, key msgKey) {
 	var (
 		state *connState
 		ok    bool
 	)
 	if state, ok = cs.loadConnIDState(connID); ok {
-		state.ackLastMsg(ctx, sessionID, msgID)
+		// the inbox seq of the message is only known by the stored copy
+		var seq uint64
+		if pm, err := cs.getLastMsg(ctx, connID); err == nil && pm != nil {
+			seq = pm.Seq
+		}
+		if state.ackLastMsg(ctx, key) {
+			retransmitCounter.WithLabelValues("acked").Inc()
+			if err := cs.ackDeviceMsg(ctx, state.uid, state.did, seq); err != nil {
+				fmt.Printf("[ERROR] ackDeviceMsg:err=%s\n", err.Error())
+			}
+			if err := cs.ackOfflineMsg(ctx, state, key); err != nil {
+				fmt.Printf("[ERROR] ackOfflineMsg:err=%s\n", err.Error())
+			}
+		}
 	}
 }
 
 // operate last msg structure
 func (cs *cacheState) getLastMsg(ctx context.Context, connID uint64) (*message.PushMsg, error) {
-	slot := cs.getConnStateSlot(connID)
-	key := fmt.Sprintf(cache.LastMsgKey, slot, connID)
-	data, err := cache.GetBytes(ctx, key)
+	key := cs.connKey(cache.LastMsgKey, connID)
+	data, err := cs.store.GetBytes(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -214,10 +382,10 @@ func (cs *cacheState) getLastMsg(ctx context.Context, connID uint64) (*message.P
 	return pushMsg, nil
 }
 
-func (cs *cacheState) loginSlotUnmarshal(mate string) (uint64, uint64) {
+func (cs *cacheState) loginSlotUnmarshal(mate string) (uint64, uint64, uint64, time.Duration) {
 	strs := strings.Split(mate, "|")
 	if len(strs) < 2 {
-		return 0, 0
+		return 0, 0, 0, 0
 	}
 	did, err := strconv.ParseUint(strs[0], 10, 64)
 	if err != nil {
@@ -227,8 +395,23 @@ func (cs *cacheState) loginSlotUnmarshal(mate string) (uint64, uint64) {
 	if err != nil {
 		panic(err)
 	}
-	return did, connID
+	// the userID and the heartbeat interval are absent in the meta written by older versions
+	var uid uint64
+	if len(strs) > 2 {
+		if uid, err = strconv.ParseUint(strs[2], 10, 64); err != nil {
+			panic(err)
+		}
+	}
+	heartbeat := negotiateHeartbeat(0, "")
+	if len(strs) > 3 {
+		seconds, err := strconv.ParseUint(strs[3], 10, 32)
+		if err != nil {
+			panic(err)
+		}
+		heartbeat = negotiateHeartbeat(uint32(seconds), "")
+	}
+	return did, connID, uid, heartbeat
 }
-func (cs *cacheState) loginSlotMarshal(did, connID uint64) string {
-	return fmt.Sprintf("%d|%d", did, connID)
+func (cs *cacheState) loginSlotMarshal(did, connID, uid uint64, heartbeat time.Duration) string {
+	return fmt.Sprintf("%d|%d|%d|%d", did, connID, uid, heartbeat/time.Second)
 }
\ No newline at end of file
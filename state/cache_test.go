@@ -0,0 +1,430 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"github.com/feichai0017/GoChat/state/rpc/service"
+)
+
+func TestMain(m *testing.M) {
+	// a range not starting at 0, so that a key built from the slot index instead of the slot is caught
+	viper.Set("state.conn_state_slot_range", "2,5")
+	viper.Set("state.up_msg_dedup_window", 2)
+	InitTimer()
+	code := m.Run()
+	CloseTimer()
+	os.Exit(code)
+}
+
+// the global state on a memory store, the calls to the gateway are not covered
+func newTestCacheState(t *testing.T) *cacheState {
+	cs = newCacheState(cache.NewMemoryStore())
+	t.Cleanup(func() { cs = nil })
+	return cs
+}
+
+// pushRecorder records the msgs pushed to the gateway by connID, the connections are closed silently
+type pushRecorder struct {
+	sync.Mutex
+	cmds      map[uint64][]*message.MsgCmd
+	endpoints map[uint64]string // gateway endpoint of the latest msg of the connection, empty for the default gateway
+	batches   []pushBatch
+}
+
+// a batch push rpc to one gateway
+type pushBatch struct {
+	endpoint string
+	connIDs  []uint64
+}
+
+func recordPushes(t *testing.T) *pushRecorder {
+	r := &pushRecorder{cmds: make(map[uint64][]*message.MsgCmd), endpoints: make(map[uint64]string)}
+	push, batchPush, delConn := gatewayPush, gatewayBatchPush, gatewayDelConn
+	gatewayDelConn = func(ctx *context.Context, connID uint64, data []byte) error { return nil }
+	gatewayPush = func(ctx *context.Context, connID uint64, data []byte) error {
+		return r.record("", []uint64{connID}, data)
+	}
+	gatewayBatchPush = func(ctx *context.Context, endpoint string, connIDs []uint64, data []byte) error {
+		r.Lock()
+		r.batches = append(r.batches, pushBatch{endpoint: endpoint, connIDs: connIDs})
+		r.Unlock()
+		return r.record(endpoint, connIDs, data)
+	}
+	t.Cleanup(func() { gatewayPush, gatewayBatchPush, gatewayDelConn = push, batchPush, delConn })
+	return r
+}
+
+func (r *pushRecorder) record(endpoint string, connIDs []uint64, data []byte) error {
+	mc := &message.MsgCmd{}
+	if err := proto.Unmarshal(data, mc); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, connID := range connIDs {
+		r.cmds[connID] = append(r.cmds[connID], mc)
+		r.endpoints[connID] = endpoint
+	}
+	return nil
+}
+
+// the types of the msgs sent to the connection
+func (r *pushRecorder) types(connID uint64) []message.CmdType {
+	r.Lock()
+	defer r.Unlock()
+	var types []message.CmdType
+	for _, mc := range r.cmds[connID] {
+		types = append(types, mc.Type)
+	}
+	return types
+}
+
+// the msgIDs of the push msgs sent to the connection
+func (r *pushRecorder) pushed(connID uint64) []uint64 {
+	var msgIDs []uint64
+	for _, pm := range r.pushMsgs(connID) {
+		msgIDs = append(msgIDs, pm.MsgID)
+	}
+	return msgIDs
+}
+
+// the push msgs sent to the connection
+func (r *pushRecorder) pushMsgs(connID uint64) []*message.PushMsg {
+	r.Lock()
+	defer r.Unlock()
+	var pms []*message.PushMsg
+	for _, mc := range r.cmds[connID] {
+		if mc.Type != message.CmdType_Push {
+			continue
+		}
+		pm := &message.PushMsg{}
+		proto.Unmarshal(mc.Payload, pm)
+		pms = append(pms, pm)
+	}
+	return pms
+}
+
+// the acks of the up-stream messages sent to the connection
+func (r *pushRecorder) upACKs(connID uint64) []*message.ACKMsg {
+	r.Lock()
+	defer r.Unlock()
+	var acks []*message.ACKMsg
+	for _, mc := range r.cmds[connID] {
+		ackMsg := &message.ACKMsg{}
+		if mc.Type == message.CmdType_ACK && proto.Unmarshal(mc.Payload, ackMsg) == nil && ackMsg.Type == message.CmdType_UP {
+			acks = append(acks, ackMsg)
+		}
+	}
+	return acks
+}
+
+// log the device in on the connection, its timers are stopped when the test ends
+func testLogin(t *testing.T, cs *cacheState, uid, did, connID uint64) *connState {
+	return testLoginAt(t, cs, "", uid, did, connID)
+}
+
+// log the device in on a connection held by the gateway endpoint
+func testLoginAt(t *testing.T, cs *cacheState, endpoint string, uid, did, connID uint64) *connState {
+	if err := cs.connLogin(context.Background(), endpoint, uid, did, connID, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := cs.loadConnIDState(connID)
+	t.Cleanup(state.stopTimers)
+	return state
+}
+
+func TestAcceptClientID(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	const connID = 5
+	steps := []struct {
+		name     string
+		clientID uint64
+		code     int64
+		val      uint64
+	}{
+		{"first", 0, clientIDAccepted, 0},
+		{"next", 1, clientIDAccepted, 0},
+		{"retried", 1, clientIDDuplicate, 101},
+		{"gap", 4, clientIDOutOfOrder, 2},
+		{"expected", 2, clientIDAccepted, 0},
+		{"out of the dedup window", 0, clientIDDuplicate, 0},
+	}
+	for _, step := range steps {
+		code, val, err := cs.acceptClientID(ctx, connID, step.clientID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != step.code || val != step.val {
+			t.Fatalf("%s: got {%d, %d}, want {%d, %d}", step.name, code, val, step.code, step.val)
+		}
+		if code == clientIDAccepted {
+			if err = cs.recordClientID(ctx, connID, step.clientID, 100+step.clientID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// a retry arriving before the msgID is recorded is told to wait for the ack
+	for _, want := range []int64{clientIDAccepted, clientIDInProgress} {
+		if code, _, err := cs.acceptClientID(ctx, connID, 3); err != nil || code != want {
+			t.Fatalf("clientID 3 got %d, %v, want %d", code, err, want)
+		}
+	}
+}
+
+func TestUpMsgNack(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	const connID = 101
+	testLogin(t, cs, 1, 11, connID)
+	upMsg := func(clientID, groupID uint64) {
+		payload, _ := proto.Marshal(&message.UPMsg{Head: &message.UPMsgHead{ClientID: clientID, GroupID: groupID}})
+		c := context.Background()
+		upMsgHandler(&service.CmdContext{Ctx: &c, ConnID: connID}, &message.MsgCmd{Type: message.CmdType_UP, Payload: payload})
+	}
+
+	// a message not delivered gives its ClientID back, the resent one is accepted again
+	if code, _, err := cs.acceptClientID(ctx, connID, 0); err != nil || code != clientIDAccepted {
+		t.Fatalf("accept got %d, %v", code, err)
+	}
+	nackUpMsg(ctx, connID, 0, errors.New("store unavailable"))
+	upMsg(0, 0)
+	// a message to a group of which the sender is not a member is rejected and its ClientID is used up
+	upMsg(1, 9)
+	upMsg(2, 0)
+
+	want := []struct {
+		code             message.ACKCode
+		clientID         uint64
+		expectedClientID uint64
+	}{
+		{message.ACKCode_Failed, 0, 0},
+		{message.ACKCode_OK, 0, 0},
+		{message.ACKCode_Rejected, 1, 0},
+		{message.ACKCode_OK, 2, 0},
+	}
+	acks := pushes.upACKs(connID)
+	if len(acks) != len(want) {
+		t.Fatalf("got %d acks, want %d", len(acks), len(want))
+	}
+	for i, ack := range acks {
+		if ack.Code != uint32(want[i].code) || ack.ClientID != want[i].clientID || ack.ExpectedClientID != want[i].expectedClientID {
+			t.Fatalf("ack %d got %v, want %+v", i, ack, want[i])
+		}
+	}
+	if acks[1].MsgID == 0 {
+		t.Fatal("resent message acked without a msgID")
+	}
+}
+
+func TestLastMsgAck(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	const connID = 6
+	state := cs.newConnState(1, 2, connID, time.Minute)
+	cs.storeConnIDState(connID, state)
+	defer state.stopTimers()
+
+	pm := &message.PushMsg{MsgID: 10, Content: []byte("hi"), FromUserID: 3, Seq: 4}
+	if err := cs.appendLastMsg(ctx, connID, pm); err != nil {
+		t.Fatal(err)
+	}
+	// an edit rewrites the in-flight copy, so that the retransmission carries it
+	mm := &message.ModifyMsg{Op: message.ModifyOp_Edit, MsgID: 10, FromUserID: 3, Content: []byte("hello")}
+	if err := cs.modifyLastMsg(ctx, connID, mm); err != nil {
+		t.Fatal(err)
+	}
+	last, err := cs.getLastMsg(ctx, connID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || string(last.Content) != "hello" || !last.Edited {
+		t.Fatalf("last msg got %v", last)
+	}
+
+	// a stale ack does not clear the message
+	if state.ackLastMsg(ctx, msgKey{fromUID: 3, msgID: 9}) {
+		t.Fatal("stale ack accepted")
+	}
+	cs.ackLastMsg(ctx, connID, pushMsgKey(pm))
+	if last, _ = cs.getLastMsg(ctx, connID); last != nil {
+		t.Fatalf("acked msg still pending: %v", last)
+	}
+	if acked, _ := cs.store.HGetString(ctx, fmt.Sprintf(cache.DeviceAckKey, 1), "2"); acked != "4" {
+		t.Fatalf("device ack got %q", acked)
+	}
+}
+
+func TestCleanupConnKeys(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	const connID = 7
+	// keys written before the index existed
+	legacy := []string{
+		cs.connKey(cache.LastMsgKey, connID),
+		cs.connKey(cache.ConnPresenceSubKey, connID),
+	}
+	cs.store.SetBytes(ctx, legacy[0], []byte("msg"), cache.TTL7D)
+	cs.store.SADD(ctx, legacy[1], 1)
+	if err := cs.migrateConnKeys(ctx, connID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cs.acceptClientID(ctx, connID, 0); err != nil {
+		t.Fatal(err)
+	}
+	meta := cs.loginSlotMarshal(1, connID, 2, time.Minute)
+	loginSlotKey := cs.getLoginSlotKey(connID)
+	cs.store.SADD(ctx, loginSlotKey, meta)
+
+	// the cleanup script touches the indexed keys and the login slot, in cluster mode they must share the hash tag
+	indexed, err := cs.store.SmembersStrSlice(ctx, cs.getConnKeysKey(connID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range append(indexed, cs.getConnKeysKey(connID)) {
+		if hashTag(key) != hashTag(loginSlotKey) {
+			t.Fatalf("key %s is not in the slot of %s", key, loginSlotKey)
+		}
+	}
+
+	if err := cs.store.CleanupConnection(ctx, cs.getConnKeysKey(connID), loginSlotKey, meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range append(legacy, cs.connKey(cache.MaxClientIDKey, connID), cs.getConnKeysKey(connID)) {
+		if data, _ := cs.store.GetBytes(ctx, key); data != nil {
+			t.Fatalf("key %s left after cleanup", key)
+		}
+	}
+	if members, _ := cs.store.SmembersStrSlice(ctx, legacy[1]); len(members) != 0 {
+		t.Fatalf("presence subscriptions left after cleanup: %v", members)
+	}
+	if ok, _ := cs.store.SIsMember(ctx, loginSlotKey, meta); ok {
+		t.Fatal("connection left in the login slot")
+	}
+}
+
+// the part of the key hashed in cluster mode
+func hashTag(key string) string {
+	start := strings.Index(key, "{")
+	end := strings.Index(key, "}")
+	if start < 0 || end < start {
+		return key
+	}
+	return key[start+1 : end]
+}
+
+func TestReadCursor(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	receipt := &message.ReceiptMsg{ToUserID: 4}
+	session := receiptSession(receipt)
+	for _, step := range []struct {
+		msgID    uint64
+		advanced bool
+	}{{3, true}, {2, false}, {3, false}, {8, true}} {
+		advanced, err := cs.advanceReadCursor(ctx, 1, session, step.msgID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if advanced != step.advanced {
+			t.Fatalf("msgID %d: advanced %v, want %v", step.msgID, advanced, step.advanced)
+		}
+	}
+}
+
+func TestUnreadCount(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	recordPushes(t)
+	testLogin(t, cs, 1, 11, 101)
+	testLogin(t, cs, 2, 21, 201)
+	gid, err := cs.createGroup(ctx, 1, []uint64{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user 1 sends three direct messages and two group messages, user 2 replies once
+	var last uint64
+	for i := 0; i < 3; i++ {
+		if last, err = cs.deliverToUser(ctx, 101, 2, []byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = cs.deliverToGroup(ctx, 101, gid, []byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = cs.deliverToUser(ctx, 201, 1, []byte("hey")); err != nil {
+		t.Fatal(err)
+	}
+	// user 2 read the direct messages, the reply of its own is read as well
+	if _, err = cs.advanceReadCursor(ctx, 2, sessionKey(0, 1), last); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		uid, gid, peerUID uint64
+		want              uint64
+	}{
+		{1, 0, 2, 1},
+		{1, gid, 0, 0},
+		{2, 0, 1, 0},
+		{2, gid, 0, 2},
+		{2, 0, 3, 0},
+	} {
+		got, err := cs.unreadCount(ctx, tt.uid, tt.gid, tt.peerUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("unread of user %d in session %s got %d, want %d", tt.uid, sessionKey(tt.gid, tt.peerUID), got, tt.want)
+		}
+	}
+}
+
+func TestModifyOfflineMsg(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	const did = 3
+	key := fmt.Sprintf(cache.OfflineMsgKey, did)
+	for msgID := uint64(1); msgID <= 3; msgID++ {
+		data, _ := proto.Marshal(&message.PushMsg{MsgID: msgID, FromUserID: 1, Content: []byte("v1")})
+		cs.store.RPushBytes(ctx, key, data, cache.TTL7D)
+	}
+	edit := &message.ModifyMsg{Op: message.ModifyOp_Edit, MsgID: 2, FromUserID: 1, Content: []byte("v2")}
+	recall := &message.ModifyMsg{Op: message.ModifyOp_Recall, MsgID: 3, FromUserID: 1}
+	// only the sender can modify the message
+	forged := &message.ModifyMsg{Op: message.ModifyOp_Recall, MsgID: 1, FromUserID: 2}
+	for _, mm := range []*message.ModifyMsg{edit, recall, forged} {
+		if err := cs.modifyOfflineMsg(ctx, did, mm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := cs.store.LRangeBytes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d offline msgs, want 2", len(msgs))
+	}
+	pm := &message.PushMsg{}
+	proto.Unmarshal(msgs[1], pm)
+	if pm.MsgID != 2 || string(pm.Content) != "v2" || !pm.Edited {
+		t.Fatalf("edited msg got %v", pm)
+	}
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"google.golang.org/protobuf/proto"
+)
+
+// allocate the next msgID of the direct session between the two users, both directions share the session
+func (cs *cacheState) nextMsgID(ctx context.Context, uid, peerUID uint64) (uint64, error) {
+	if uid > peerUID {
+		uid, peerUID = peerUID, uid
+	}
+	return cs.store.IncrUint64(ctx, fmt.Sprintf(cache.DirectMsgIDKey, uid, peerUID))
+}
+
+// deliver an up-stream message to the receiver, and sync it to the other devices of the sender.
+// The msgID assigned to the message is returned.
+func (cs *cacheState) deliverToUser(ctx context.Context, connID, toUID uint64, data []byte) (uint64, error) {
+	var fromUID, fromDID uint64
+	if state, ok := cs.loadConnIDState(connID); ok {
+		fromUID, fromDID = state.uid, state.did
+	}
+	msgID, err := cs.nextMsgID(ctx, fromUID, toUID)
+	if err != nil {
+		return 0, err
+	}
+	if err = cs.recordSentMsg(ctx, fromUID, 0, toUID, msgID); err != nil {
+		return 0, err
+	}
+	if err = cs.advanceSessionSeq(ctx, fromUID, toUID, msgID); err != nil {
+		return 0, err
+	}
+	pm := &message.PushMsg{
+		MsgID:        msgID,
+		Content:      data,
+		FromUserID:   fromUID,
+		FromDeviceID: fromDID,
+		ToUserID:     toUID,
+	}
+	// the device that sent the message already has it, so it is excluded from the push and the sync
+	if err = cs.pushToUser(ctx, toUID, pm, connID); err != nil {
+		return 0, err
+	}
+	if fromUID == 0 || fromUID == toUID {
+		return msgID, nil
+	}
+	syncPm := &message.PushMsg{
+		MsgID:        msgID,
+		Content:      data,
+		FromUserID:   fromUID,
+		FromDeviceID: fromDID,
+		ToUserID:     toUID,
+		SentByMe:     true,
+	}
+	return msgID, cs.pushToUser(ctx, fromUID, syncPm, connID)
+}
+
+// store the message in the inbox of the user and push it to every online device, skipping the excluded connection.
+// The devices are reached through the gateway holding their connection, the devices offline,
+// or without any online device at all, get the message from the inbox on the next login.
+func (cs *cacheState) pushToUser(ctx context.Context, uid uint64, pm *message.PushMsg, excludeConnID uint64) error {
+	if err := cs.appendInbox(ctx, uid, pm); err != nil {
+		return err
+	}
+	batches, err := cs.groupConnBatches(ctx, []uint64{uid}, excludeConnID)
+	if err != nil {
+		return err
+	}
+	for endpoint, connIDs := range batches {
+		cs.batchPushMsg(ctx, endpoint, connIDs, pm, true)
+	}
+	return nil
+}
+
+// assign the next inbox seq of the user to the message and append it to the inbox
+func (cs *cacheState) appendInbox(ctx context.Context, uid uint64, pm *message.PushMsg) error {
+	if uid == 0 {
+		return nil
+	}
+	seq, err := cs.store.IncrUint64(ctx, fmt.Sprintf(cache.InboxSeqKey, uid))
+	if err != nil {
+		return err
+	}
+	pm.Seq = seq
+	data, err := proto.Marshal(pm)
+	if err != nil {
+		return err
+	}
+	return cs.store.RPushBytesCapped(ctx, fmt.Sprintf(cache.InboxKey, uid), data, config.GetStateInboxSize(), cache.TTL7D)
+}
+
+// record the last inbox seq acked by the device, so that each device of a user keeps its own progress
+func (cs *cacheState) ackDeviceMsg(ctx context.Context, uid, did, seq uint64) error {
+	if uid == 0 || seq == 0 {
+		return nil
+	}
+	key := fmt.Sprintf(cache.DeviceAckKey, uid)
+	_, err := cs.store.AdvanceCursor(ctx, key, strconv.FormatUint(did, 10), seq, cache.TTL7D)
+	return err
+}
+
+// queue the messages of the inbox after the last one acked by the device, they are delivered with the offline messages.
+// The messages sent by the device itself and the ones queued already are skipped.
+func (cs *cacheState) syncDeviceMsg(ctx context.Context, uid, did uint64) error {
+	if uid == 0 {
+		return nil
+	}
+	acked, err := cs.store.HGetString(ctx, fmt.Sprintf(cache.DeviceAckKey, uid), strconv.FormatUint(did, 10))
+	if err != nil {
+		return err
+	}
+	cursor, _ := strconv.ParseUint(acked, 10, 64)
+	offlineKey := fmt.Sprintf(cache.OfflineMsgKey, did)
+	offline, err := cs.store.LRangeBytes(ctx, offlineKey)
+	if err != nil {
+		return err
+	}
+	queued := make(map[uint64]bool, len(offline))
+	for _, data := range offline {
+		pm := &message.PushMsg{}
+		if err = proto.Unmarshal(data, pm); err == nil && pm.Seq != 0 {
+			queued[pm.Seq] = true
+		}
+	}
+	inbox, err := cs.store.LRangeBytes(ctx, fmt.Sprintf(cache.InboxKey, uid))
+	if err != nil {
+		return err
+	}
+	for _, data := range inbox {
+		pm := &message.PushMsg{}
+		if err = proto.Unmarshal(data, pm); err != nil {
+			return err
+		}
+		if pm.Seq <= cursor || queued[pm.Seq] || (pm.FromUserID == uid && pm.FromDeviceID == did) {
+			continue
+		}
+		if err = cs.store.RPushBytes(ctx, offlineKey, data, cache.TTL7D); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/idl/message"
+)
+
+func TestDeliverToUserDevices(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	// user 1 sends from device 11 and has device 12 online, user 2 has device 21 online
+	testLogin(t, cs, 1, 11, 101)
+	testLogin(t, cs, 1, 12, 102)
+	testLogin(t, cs, 2, 21, 201)
+
+	msgID, err := cs.deliverToUser(ctx, 101, 2, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a note to self is only synced to the other devices
+	selfMsgID, err := cs.deliverToUser(ctx, 101, 1, []byte("note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for connID, want := range map[uint64][]uint64{
+		101: nil,
+		102: {msgID, selfMsgID},
+		201: {msgID},
+	} {
+		if got := pushes.pushed(connID); !reflect.DeepEqual(got, want) {
+			t.Fatalf("connID %d got pushes %v, want %v", connID, got, want)
+		}
+	}
+
+	// device 22 of user 2 was offline, it gets the message from the inbox on login
+	testLogin(t, cs, 2, 22, 202)
+	if got := pushes.pushed(202); !reflect.DeepEqual(got, []uint64{msgID}) {
+		t.Fatalf("device 22 synced %v, want %v", got, []uint64{msgID})
+	}
+}
+
+func TestDeliverToUserGateways(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	// both users have a device on each gateway
+	testLoginAt(t, cs, "gw1", 1, 11, 101)
+	testLoginAt(t, cs, "gw2", 1, 12, 102)
+	testLoginAt(t, cs, "gw1", 2, 21, 201)
+	testLoginAt(t, cs, "gw2", 2, 22, 202)
+
+	msgID, err := cs.deliverToUser(ctx, 101, 2, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.deliverReceipt(ctx, 201, message.CmdType_Read, &message.ReceiptMsg{ToUserID: 1, MsgID: msgID}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.deliverSignal(ctx, 202, &message.SignalMsg{ToUserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.modifyMsg(ctx, 101, &message.ModifyMsg{Op: message.ModifyOp_Recall, ToUserID: 2, MsgID: msgID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every device gets the push, the sync copy, the receipt, the signal and the modify event through its own gateway
+	for _, tt := range []struct {
+		connID   uint64
+		endpoint string
+		want     []message.CmdType
+	}{
+		{101, "gw1", []message.CmdType{message.CmdType_Read, message.CmdType_Signal}},
+		{102, "gw2", []message.CmdType{message.CmdType_Push, message.CmdType_Read, message.CmdType_Signal, message.CmdType_Modify}},
+		{201, "gw1", []message.CmdType{message.CmdType_Push, message.CmdType_Modify}},
+		{202, "gw2", []message.CmdType{message.CmdType_Push, message.CmdType_Modify}},
+	} {
+		if got := pushes.types(tt.connID); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("connID %d got %v, want %v", tt.connID, got, tt.want)
+		}
+		if got := pushes.endpoints[tt.connID]; got != tt.endpoint {
+			t.Fatalf("connID %d reached through %q, want %q", tt.connID, got, tt.endpoint)
+		}
+	}
+}
+
+func TestSyncDeviceMsg(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	state := testLogin(t, cs, 2, 21, 201)
+
+	first, err := cs.deliverToUser(ctx, 0, 2, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.ackLastMsg(ctx, 201, msgKey{toUID: 2, msgID: first})
+	second, err := cs.deliverToUser(ctx, 0, 2, []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acked, _ := cs.store.HGetString(ctx, fmt.Sprintf(cache.DeviceAckKey, 2), "21"); acked != "1" {
+		t.Fatalf("device ack got %q, want the inbox seq 1", acked)
+	}
+
+	// the device reconnects before acking the second message, only the unacked one is synced
+	state.stopTimers()
+	if err = cs.reConn(ctx, "", 201, 301); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if state, ok := cs.loadConnIDState(301); ok {
+			state.stopTimers()
+		}
+	})
+	if got := pushes.pushed(301); !reflect.DeepEqual(got, []uint64{second}) {
+		t.Fatalf("reconnected device synced %v, want %v", got, []uint64{second})
+	}
+}
+
+func TestDirectMsgID(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	recordPushes(t)
+	testLogin(t, cs, 1, 11, 101)
+	testLogin(t, cs, 2, 21, 201)
+
+	// both directions share the session, another peer starts its own session
+	var got []uint64
+	for _, send := range []struct{ connID, toUID uint64 }{{101, 2}, {201, 1}, {101, 3}} {
+		msgID, err := cs.deliverToUser(ctx, send.connID, send.toUID, []byte("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msgID)
+	}
+	if want := []uint64{1, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("msgIDs %v, want %v", got, want)
+	}
+
+	// the same msgID in another session is left untouched by a recall
+	if err := cs.modifyMsg(ctx, 101, &message.ModifyMsg{Op: message.ModifyOp_Recall, ToUserID: 3, MsgID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for uid, recalled := range map[uint64]bool{2: false, 3: true} {
+		inbox, err := cs.store.LRangeBytes(ctx, fmt.Sprintf(cache.InboxKey, uid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm := &message.PushMsg{}
+		proto.Unmarshal(inbox[0], pm)
+		if pm.MsgID != 1 || pm.Recalled != recalled {
+			t.Fatalf("inbox of user %d got %v, want recalled %v", uid, pm, recalled)
+		}
+	}
+}
+
+func TestAckSameMsgIDFromTwoPeers(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	testLogin(t, cs, 1, 11, 101)
+	testLogin(t, cs, 3, 31, 301)
+	testLogin(t, cs, 2, 21, 201)
+
+	// users 1 and 3 start their own session with user 2, both get msgID 1
+	for _, connID := range []uint64{101, 301} {
+		if msgID, err := cs.deliverToUser(ctx, connID, 2, []byte("hi")); err != nil || msgID != 1 {
+			t.Fatalf("deliver from connID %d got %d, %v", connID, msgID, err)
+		}
+	}
+	fromA := msgKey{fromUID: 1, toUID: 2, msgID: 1}
+	fromC := msgKey{fromUID: 3, toUID: 2, msgID: 1}
+
+	// the late ack of the message of user 1 leaves the one of user 3 in flight
+	cs.ackLastMsg(ctx, 201, fromA)
+	if last, _ := cs.getLastMsg(ctx, 201); last == nil || pushMsgKey(last) != fromC {
+		t.Fatalf("last msg got %v after the late ack, want the one of user 3", last)
+	}
+	if acked, _ := cs.store.HGetString(ctx, fmt.Sprintf(cache.DeviceAckKey, 2), "21"); acked != "" {
+		t.Fatalf("device ack moved to %q by the late ack", acked)
+	}
+	cs.ackLastMsg(ctx, 201, fromC)
+	if last, _ := cs.getLastMsg(ctx, 201); last != nil {
+		t.Fatalf("last msg got %v after its ack", last)
+	}
+	if acked, _ := cs.store.HGetString(ctx, fmt.Sprintf(cache.DeviceAckKey, 2), "21"); acked != "2" {
+		t.Fatalf("device ack got %q, want the inbox seq 2", acked)
+	}
+
+	// device 22 syncs both messages, the ack of the second one does not trim the first one
+	testLogin(t, cs, 2, 22, 202)
+	offlineKey := fmt.Sprintf(cache.OfflineMsgKey, 22)
+	cs.ackLastMsg(ctx, 202, fromC)
+	if msgs, _ := cs.store.LRangeBytes(ctx, offlineKey); len(msgs) != 2 {
+		t.Fatalf("%d offline msgs left after the ack of another session, want 2", len(msgs))
+	}
+	cs.ackLastMsg(ctx, 202, fromA)
+	if msgs, _ := cs.store.LRangeBytes(ctx, offlineKey); len(msgs) != 1 {
+		t.Fatalf("%d offline msgs left after the ack, want 1", len(msgs))
+	}
+	if pms := pushes.pushMsgs(202); len(pms) != 2 || pushMsgKey(pms[1]) != fromC {
+		t.Fatalf("device 22 got %v, want the message of user 3 after the ack", pms)
+	}
+}
@@ -0,0 +1,259 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	errNotGroupMember = errors.New("not a member of the group")
+	errNotGroupOwner  = errors.New("only the owner can remove other members")
+)
+
+// create a group session, the creator is always a member and owns the group
+func (cs *cacheState) createGroup(ctx context.Context, creator uint64, uids []uint64) (uint64, error) {
+	gid, err := cs.store.IncrUint64(ctx, cache.GroupIDKey)
+	if err != nil {
+		return 0, err
+	}
+	owner := []byte(strconv.FormatUint(creator, 10))
+	if err = cs.store.SetBytes(ctx, fmt.Sprintf(cache.GroupOwnerKey, gid), owner, 0); err != nil {
+		return 0, err
+	}
+	if err = cs.addGroupMembers(ctx, gid, append(uids, creator)); err != nil {
+		return 0, err
+	}
+	return gid, nil
+}
+
+// the user must be a member of the group to see or change it. A group never created, or left by
+// every member, has no members, so it is rejected as well.
+func (cs *cacheState) checkGroupMember(ctx context.Context, gid, uid uint64) error {
+	isMember, err := cs.store.SIsMember(ctx, fmt.Sprintf(cache.GroupMembersKey, gid), uid)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return errNotGroupMember
+	}
+	return nil
+}
+
+// add the users to the group, only a member can add users
+func (cs *cacheState) joinGroup(ctx context.Context, uid, gid uint64, uids []uint64) error {
+	if err := cs.checkGroupMember(ctx, gid, uid); err != nil {
+		return err
+	}
+	return cs.addGroupMembers(ctx, gid, uids)
+}
+
+func (cs *cacheState) addGroupMembers(ctx context.Context, gid uint64, uids []uint64) error {
+	key := fmt.Sprintf(cache.GroupMembersKey, gid)
+	for _, uid := range uids {
+		if uid == 0 {
+			continue
+		}
+		if err := cs.store.SADD(ctx, key, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove the users from the group, a member can only remove itself unless it owns the group
+func (cs *cacheState) leaveGroup(ctx context.Context, uid, gid uint64, uids []uint64) error {
+	if err := cs.checkGroupMember(ctx, gid, uid); err != nil {
+		return err
+	}
+	removesOthers := false
+	for _, member := range uids {
+		removesOthers = removesOthers || member != uid
+	}
+	if removesOthers {
+		owner, err := cs.store.GetBytes(ctx, fmt.Sprintf(cache.GroupOwnerKey, gid))
+		if err != nil {
+			return err
+		}
+		if string(owner) != strconv.FormatUint(uid, 10) {
+			return errNotGroupOwner
+		}
+	}
+	key := fmt.Sprintf(cache.GroupMembersKey, gid)
+	for _, member := range uids {
+		if err := cs.store.SREM(ctx, key, member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the members of the group, only listed to a member
+func (cs *cacheState) queryGroupMembers(ctx context.Context, uid, gid uint64) ([]uint64, error) {
+	if err := cs.checkGroupMember(ctx, gid, uid); err != nil {
+		return nil, err
+	}
+	return cs.groupMembers(ctx, gid)
+}
+
+func (cs *cacheState) groupMembers(ctx context.Context, gid uint64) ([]uint64, error) {
+	members, err := cs.store.SmembersStrSlice(ctx, fmt.Sprintf(cache.GroupMembersKey, gid))
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]uint64, 0, len(members))
+	for _, member := range members {
+		uid, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// fan out an up-stream message to every member of the group.
+// The message is always appended to the group timeline. Small groups use write diffusion and
+// push the full message to every device, large groups use read diffusion and only push a
+// notification without content, the devices pull the messages with a sync request. The msgID in the group is returned.
+func (cs *cacheState) deliverToGroup(ctx context.Context, connID, gid uint64, data []byte) (uint64, error) {
+	var fromUID, fromDID uint64
+	if state, ok := cs.loadConnIDState(connID); ok {
+		fromUID, fromDID = state.uid, state.did
+	}
+	if err := cs.checkGroupMember(ctx, gid, fromUID); err != nil {
+		return 0, err
+	}
+	msgID, err := cs.store.IncrUint64(ctx, fmt.Sprintf(cache.GroupMsgIDKey, gid))
+	if err != nil {
+		return 0, err
+	}
+	pm := &message.PushMsg{
+		MsgID:        msgID,
+		SessionID:    gid,
+		Content:      data,
+		FromUserID:   fromUID,
+		FromDeviceID: fromDID,
+	}
+	msgData, err := proto.Marshal(pm)
+	if err != nil {
+		return 0, err
+	}
+	timelineKey := fmt.Sprintf(cache.GroupTimelineKey, gid)
+	if err = cs.store.RPushBytesCapped(ctx, timelineKey, msgData, config.GetStateGroupTimelineSize(), cache.TTL7D); err != nil {
+		return 0, err
+	}
+	if err = cs.recordSentMsg(ctx, fromUID, gid, 0, msgID); err != nil {
+		return 0, err
+	}
+	// the sender has read its own message
+	if _, err = cs.advanceReadCursor(ctx, fromUID, sessionKey(gid, 0), msgID); err != nil {
+		return 0, err
+	}
+
+	uids, err := cs.groupMembers(ctx, gid)
+	if err != nil {
+		return 0, err
+	}
+	readDiffusion := len(uids) > config.GetStateGroupReadDiffusionThreshold()
+	if readDiffusion {
+		pm = &message.PushMsg{
+			MsgID:        msgID,
+			SessionID:    gid,
+			FromUserID:   fromUID,
+			FromDeviceID: fromDID,
+		}
+	}
+	batches, err := cs.groupConnBatches(ctx, uids, connID)
+	if err != nil {
+		return 0, err
+	}
+	for endpoint, connIDs := range batches {
+		cs.batchPushMsg(ctx, endpoint, connIDs, pm, !readDiffusion)
+	}
+	return msgID, nil
+}
+
+// group the connections of the users by gateway endpoint, skipping the excluded connection
+func (cs *cacheState) groupConnBatches(ctx context.Context, uids []uint64, excludeConnID uint64) (map[string][]uint64, error) {
+	batches := make(map[string][]uint64)
+	for _, uid := range uids {
+		dids, err := cs.router.QueryUserDevices(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, did := range dids {
+			record, err := cs.router.QueryRecord(ctx, did)
+			if err != nil || record.ConndID == excludeConnID {
+				continue
+			}
+			batches[record.Endpoint] = append(batches[record.Endpoint], record.ConndID)
+		}
+	}
+	return batches, nil
+}
+
+// push the message to a batch of connections on one gateway with a single rpc
+func (cs *cacheState) batchPushMsg(ctx context.Context, endpoint string, connIDs []uint64, pm *message.PushMsg, reliable bool) {
+	payload, err := proto.Marshal(pm)
+	if err != nil {
+		fmt.Printf("[ERROR] Marshal:err=%s\n", err.Error())
+		return
+	}
+	batchSendMsg(ctx, endpoint, connIDs, message.CmdType_Push, payload)
+	if !reliable {
+		return
+	}
+	// the connections owned by this state server are retransmitted until acked
+	for _, connID := range connIDs {
+		if _, ok := cs.loadConnIDState(connID); ok {
+			if err = cs.appendLastMsg(ctx, connID, pm); err != nil {
+				fmt.Printf("[ERROR] appendLastMsg connID=%d:err=%s\n", connID, err.Error())
+			}
+		}
+	}
+}
+
+// send the same payload to a batch of connections on one gateway with a single rpc
+func batchSendMsg(ctx context.Context, endpoint string, connIDs []uint64, ty message.CmdType, payload []byte) {
+	data, err := proto.Marshal(&message.MsgCmd{Type: ty, Payload: payload})
+	if err != nil {
+		fmt.Printf("[ERROR] Marshal:err=%s\n", err.Error())
+		return
+	}
+	// a push shed by a saturated gateway is recovered by the retransmission of the last msg
+	if err = gatewayBatchPush(&ctx, endpoint, connIDs, data); err != nil {
+		fmt.Printf("[ERROR] BatchPush endpoint=%s:err=%s\n", endpoint, err.Error())
+	}
+}
+
+// push the messages of the group timeline after the given msgID to the connection
+func (cs *cacheState) syncGroupMsg(ctx context.Context, connID, gid, afterMsgID uint64) error {
+	var uid uint64
+	if state, ok := cs.loadConnIDState(connID); ok {
+		uid = state.uid
+	}
+	if err := cs.checkGroupMember(ctx, gid, uid); err != nil {
+		return err
+	}
+	msgs, err := cs.store.LRangeBytes(ctx, fmt.Sprintf(cache.GroupTimelineKey, gid))
+	if err != nil {
+		return err
+	}
+	for _, data := range msgs {
+		pm := &message.PushMsg{}
+		if err = proto.Unmarshal(data, pm); err != nil {
+			return err
+		}
+		if pm.MsgID > afterMsgID {
+			sendMsg(connID, message.CmdType_Push, data)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGroupAuthorization(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	recordPushes(t)
+	testLogin(t, cs, 3, 31, 301)
+	// user 1 owns the group with user 2, user 3 is not a member
+	gid, err := cs.createGroup(ctx, 1, []uint64{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := func(uid uint64) error {
+		_, err := cs.queryGroupMembers(ctx, uid, gid)
+		return err
+	}
+
+	steps := []struct {
+		name string
+		do   func() error
+		want error
+	}{
+		{"outsider joins itself", func() error { return cs.joinGroup(ctx, 3, gid, []uint64{3}) }, errNotGroupMember},
+		{"outsider lists members", func() error { return members(3) }, errNotGroupMember},
+		{"outsider syncs", func() error { return cs.syncGroupMsg(ctx, 301, gid, 0) }, errNotGroupMember},
+		{"outsider removes a member", func() error { return cs.leaveGroup(ctx, 3, gid, []uint64{2}) }, errNotGroupMember},
+		{"group never created", func() error { return cs.joinGroup(ctx, 1, gid+1, []uint64{1}) }, errNotGroupMember},
+		{"member removes the owner", func() error { return cs.leaveGroup(ctx, 2, gid, []uint64{1}) }, errNotGroupOwner},
+		{"member adds the outsider", func() error { return cs.joinGroup(ctx, 2, gid, []uint64{3}) }, nil},
+		{"new member syncs", func() error { return cs.syncGroupMsg(ctx, 301, gid, 0) }, nil},
+		{"owner removes a member", func() error { return cs.leaveGroup(ctx, 1, gid, []uint64{3}) }, nil},
+		{"member leaves itself", func() error { return cs.leaveGroup(ctx, 2, gid, []uint64{2}) }, nil},
+		{"former member lists members", func() error { return members(2) }, errNotGroupMember},
+	}
+	for _, step := range steps {
+		if err := step.do(); !errors.Is(err, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+		}
+	}
+	got, err := cs.queryGroupMembers(ctx, 1, gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("members got %v, want %v", got, want)
+	}
+}
+
+func TestDeliverToGroup(t *testing.T) {
+	tests := []struct {
+		name          string
+		threshold     int
+		readDiffusion bool
+	}{
+		{"write diffusion", 3, false},
+		{"read diffusion", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("state.group.read_diffusion_threshold", tt.threshold)
+			t.Cleanup(func() { viper.Set("state.group.read_diffusion_threshold", 0) })
+			ctx := context.Background()
+			cs := newTestCacheState(t)
+			pushes := recordPushes(t)
+			// user 1 sends from device 11 and has device 12 on the other gateway
+			testLoginAt(t, cs, "gw1", 1, 11, 101)
+			testLoginAt(t, cs, "gw2", 1, 12, 102)
+			testLoginAt(t, cs, "gw1", 2, 21, 201)
+			testLoginAt(t, cs, "gw2", 3, 31, 301)
+			gid, err := cs.createGroup(ctx, 1, []uint64{2, 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgID, err := cs.deliverToGroup(ctx, 101, gid, []byte("hi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// one batch per gateway, without the sending connection
+			batches := make(map[string][]uint64)
+			for _, batch := range pushes.batches {
+				if _, ok := batches[batch.endpoint]; ok {
+					t.Fatalf("more than one batch to %s", batch.endpoint)
+				}
+				connIDs := append([]uint64(nil), batch.connIDs...)
+				sort.Slice(connIDs, func(i, j int) bool { return connIDs[i] < connIDs[j] })
+				batches[batch.endpoint] = connIDs
+			}
+			if want := map[string][]uint64{"gw1": {201}, "gw2": {102, 301}}; !reflect.DeepEqual(batches, want) {
+				t.Fatalf("batches got %v, want %v", batches, want)
+			}
+
+			// a large group only gets a notification, which is not retransmitted
+			for _, connID := range []uint64{102, 201, 301} {
+				pms := pushes.pushMsgs(connID)
+				if len(pms) != 1 || pms[0].MsgID != msgID || pms[0].SessionID != gid {
+					t.Fatalf("connID %d got pushes %v, want msgID %d of group %d", connID, pms, msgID, gid)
+				}
+				if notified := len(pms[0].Content) == 0; notified != tt.readDiffusion {
+					t.Fatalf("connID %d got content %q", connID, pms[0].Content)
+				}
+				last, err := cs.getLastMsg(ctx, connID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if retransmitted := last != nil; retransmitted == tt.readDiffusion {
+					t.Fatalf("connID %d retransmitted %v, want %v", connID, retransmitted, !tt.readDiffusion)
+				}
+			}
+
+			// the content is pulled from the timeline
+			if err = cs.syncGroupMsg(ctx, 201, gid, 0); err != nil {
+				t.Fatal(err)
+			}
+			if pms := pushes.pushMsgs(201); len(pms) != 2 || string(pms[1].Content) != "hi" {
+				t.Fatalf("connID 201 synced %v, want the message with its content", pms)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package state
+
+import (
+	"time"
+
+	"github.com/feichai0017/GoChat/common/config"
+)
+
+// negotiate the heartbeat interval of a connection, the desired interval is clamped to the configured bounds
+func negotiateHeartbeat(desired uint32, networkType string) time.Duration {
+	interval := time.Duration(desired) * time.Second
+	if interval == 0 {
+		interval = config.GetStateHeartbeatDefaultInterval(networkType)
+	}
+	if min := config.GetStateHeartbeatMinInterval(); interval < min {
+		interval = min
+	}
+	if max := config.GetStateHeartbeatMaxInterval(); interval > max {
+		interval = max
+	}
+	return interval
+}
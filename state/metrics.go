@@ -0,0 +1,51 @@
+package state
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/feichai0017/GoChat/common/crpc/prome"
+)
+
+const nameSpace = "gochat_state"
+
+var (
+	// result is one of retry, acked and exhausted
+	retransmitCounter = prome.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "retransmit",
+			Name:      "msg_total",
+		},
+		[]string{"result"},
+	)
+
+	// result is one of sent and limited
+	signalCounter = prome.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "signal",
+			Name:      "total",
+		},
+		[]string{"result"},
+	)
+
+	// commands waiting in each worker shard
+	cmdBacklogGauge = prome.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: nameSpace,
+			Subsystem: "cmd",
+			Name:      "backlog",
+		},
+		[]string{"shard"},
+	)
+
+	// commands rejected because the worker shard is full
+	cmdRejectedCounter = prome.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: nameSpace,
+			Subsystem: "cmd",
+			Name:      "rejected_total",
+		},
+		[]string{"shard"},
+	)
+)
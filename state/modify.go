@@ -0,0 +1,237 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	errModifyNotFound = errors.New("message not found or not sent by the user")
+	errModifyExpired  = errors.New("modify window expired")
+)
+
+// msgIDs are allocated per session, so the sent message is keyed by the session and the msgID
+func sentMsgField(groupID, peerUID, msgID uint64) string {
+	return fmt.Sprintf("%s_%d", sessionKey(groupID, peerUID), msgID)
+}
+
+// remember who sent the message and when, so that only the sender can modify it within the window
+func (cs *cacheState) recordSentMsg(ctx context.Context, uid, groupID, peerUID, msgID uint64) error {
+	if uid == 0 {
+		return nil
+	}
+	key := fmt.Sprintf(cache.SentMsgKey, uid)
+	meta := fmt.Sprintf("%d|%d", peerUID, time.Now().UnixMilli())
+	return cs.store.HSet(ctx, key, sentMsgField(groupID, peerUID, msgID), meta, cache.TTL7D)
+}
+
+// recall or edit a message sent by the user of the connection.
+// The stored copies are rewritten and the modify event is pushed to every device of the recipients and the sender,
+// devices that are offline get the event with the offline messages on the next login.
+func (cs *cacheState) modifyMsg(ctx context.Context, connID uint64, mm *message.ModifyMsg) error {
+	state, ok := cs.loadConnIDState(connID)
+	if !ok {
+		return errModifyNotFound
+	}
+	if mm.SessionID != 0 {
+		mm.ToUserID = 0
+	}
+	meta, err := cs.store.HGetString(ctx, fmt.Sprintf(cache.SentMsgKey, state.uid), sentMsgField(mm.SessionID, mm.ToUserID, mm.MsgID))
+	if err != nil {
+		return err
+	}
+	strs := strings.Split(meta, "|")
+	if len(strs) < 2 {
+		return errModifyNotFound
+	}
+	peerUID, _ := strconv.ParseUint(strs[0], 10, 64)
+	sentAt, _ := strconv.ParseInt(strs[1], 10, 64)
+	if time.Since(time.UnixMilli(sentAt)) > config.GetStateModifyWindow() {
+		return errModifyExpired
+	}
+	mm.FromUserID = state.uid
+	mm.Timestamp = time.Now().UnixMilli()
+	if mm.Op == message.ModifyOp_Recall {
+		mm.Content = nil
+	}
+
+	uids := []uint64{state.uid}
+	if mm.SessionID != 0 {
+		if err = cs.modifyTimeline(ctx, fmt.Sprintf(cache.GroupTimelineKey, mm.SessionID), mm); err != nil {
+			return err
+		}
+		if uids, err = cs.groupMembers(ctx, mm.SessionID); err != nil {
+			return err
+		}
+	} else {
+		if peerUID != state.uid {
+			uids = append(uids, peerUID)
+		}
+		// the devices syncing from the inbox later get the modified copy
+		for _, uid := range uids {
+			if err = cs.modifyTimeline(ctx, fmt.Sprintf(cache.InboxKey, uid), mm); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := proto.Marshal(mm)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if err = cs.modifyUserDevices(ctx, uid, mm, data, connID); err != nil {
+			fmt.Printf("[ERROR] modifyUserDevices uid=%d:err=%s\n", uid, err.Error())
+		}
+	}
+	return nil
+}
+
+func (cs *cacheState) modifyUserDevices(ctx context.Context, uid uint64, mm *message.ModifyMsg, data []byte, excludeConnID uint64) error {
+	// online devices are indexed by the user, offline ones are only known by their acks
+	dids := make(map[uint64]struct{})
+	online, err := cs.router.QueryUserDevices(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for _, did := range online {
+		dids[did] = struct{}{}
+	}
+	acked, err := cs.store.HGetAllStrMap(ctx, fmt.Sprintf(cache.DeviceAckKey, uid))
+	if err != nil {
+		return err
+	}
+	for field := range acked {
+		if did, err := strconv.ParseUint(field, 10, 64); err == nil {
+			dids[did] = struct{}{}
+		}
+	}
+	// the online devices are reached through the gateway holding their connection
+	batches := make(map[string][]uint64)
+	var offline []uint64
+	for did := range dids {
+		record, err := cs.router.QueryRecord(ctx, did)
+		if err != nil {
+			offline = append(offline, did)
+			continue
+		}
+		if record.ConndID == excludeConnID {
+			continue
+		}
+		if err = cs.modifyLastMsg(ctx, record.ConndID, mm); err != nil {
+			fmt.Printf("[ERROR] modifyLastMsg connID=%d:err=%s\n", record.ConndID, err.Error())
+		}
+		batches[record.Endpoint] = append(batches[record.Endpoint], record.ConndID)
+	}
+	for endpoint, connIDs := range batches {
+		batchSendMsg(ctx, endpoint, connIDs, message.CmdType_Modify, data)
+	}
+	for _, did := range offline {
+		if err = cs.modifyOfflineMsg(ctx, did, mm); err != nil {
+			return err
+		}
+		if err = cs.store.RPushBytes(ctx, fmt.Sprintf(cache.OfflineModifyKey, did), data, cache.TTL7D); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrite the in-flight copy, so that a retransmission carries the modified message
+func (cs *cacheState) modifyLastMsg(ctx context.Context, connID uint64, mm *message.ModifyMsg) error {
+	pm, err := cs.getLastMsg(ctx, connID)
+	if err != nil || pm == nil || !modifyMatch(pm, mm) {
+		return err
+	}
+	applyModify(pm, mm)
+	data, err := proto.Marshal(pm)
+	if err != nil {
+		return err
+	}
+	return cs.store.SetBytes(ctx, cs.connKey(cache.LastMsgKey, connID), data, cache.TTL7D)
+}
+
+// a recalled message is removed from the offline messages, an edited one is replaced
+func (cs *cacheState) modifyOfflineMsg(ctx context.Context, did uint64, mm *message.ModifyMsg) error {
+	key := fmt.Sprintf(cache.OfflineMsgKey, did)
+	msgs, err := cs.store.LRangeBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	for i, data := range msgs {
+		pm := &message.PushMsg{}
+		if err = proto.Unmarshal(data, pm); err != nil || !modifyMatch(pm, mm) {
+			continue
+		}
+		if mm.Op == message.ModifyOp_Recall {
+			return cs.store.LRemBytes(ctx, key, data)
+		}
+		applyModify(pm, mm)
+		newData, err := proto.Marshal(pm)
+		if err != nil {
+			return err
+		}
+		return cs.store.LSetBytes(ctx, key, int64(i), newData)
+	}
+	return nil
+}
+
+// the recalled message keeps its place in the group timeline or the inbox, so that the msgIDs and the seqs stay continuous
+func (cs *cacheState) modifyTimeline(ctx context.Context, key string, mm *message.ModifyMsg) error {
+	msgs, err := cs.store.LRangeBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	for i, data := range msgs {
+		pm := &message.PushMsg{}
+		if err = proto.Unmarshal(data, pm); err != nil || !modifyMatch(pm, mm) {
+			continue
+		}
+		applyModify(pm, mm)
+		newData, err := proto.Marshal(pm)
+		if err != nil {
+			return err
+		}
+		return cs.store.LSetBytes(ctx, key, int64(i), newData)
+	}
+	return nil
+}
+
+// push the modify events stored while the device was offline
+func (cs *cacheState) syncOfflineModify(ctx context.Context, did, connID uint64) error {
+	key := fmt.Sprintf(cache.OfflineModifyKey, did)
+	events, err := cs.store.LRangeBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	for _, data := range events {
+		sendMsg(connID, message.CmdType_Modify, data)
+	}
+	return cs.store.Del(ctx, key)
+}
+
+func modifyMatch(pm *message.PushMsg, mm *message.ModifyMsg) bool {
+	return pm.SessionID == mm.SessionID && pm.MsgID == mm.MsgID && pm.FromUserID == mm.FromUserID && pm.ToUserID == mm.ToUserID
+}
+
+func applyModify(pm *message.PushMsg, mm *message.ModifyMsg) {
+	switch mm.Op {
+	case message.ModifyOp_Recall:
+		pm.Content = nil
+		pm.Recalled = true
+	case message.ModifyOp_Edit:
+		pm.Content = mm.Content
+		pm.Edited = true
+	}
+}
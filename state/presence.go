@@ -0,0 +1,197 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"github.com/feichai0017/GoChat/state/rpc/service"
+	"google.golang.org/protobuf/proto"
+)
+
+// mark the device online, and notify subscribers if the device status changed
+func (cs *cacheState) presenceOnline(ctx context.Context, uid, did uint64) error {
+	return cs.updatePresence(ctx, uid, did, true)
+}
+
+// mark the device offline, the last active time is kept as "last seen"
+func (cs *cacheState) presenceOffline(ctx context.Context, uid, did uint64) error {
+	return cs.updatePresence(ctx, uid, did, false)
+}
+
+// refresh the last active time of the device without notifying subscribers
+func (cs *cacheState) presenceTouch(ctx context.Context, uid, did uint64) error {
+	_, err := cs.setPresence(ctx, uid, did, true, time.Now().UnixMilli())
+	return err
+}
+
+func (cs *cacheState) updatePresence(ctx context.Context, uid, did uint64, online bool) error {
+	if uid == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	changed, err := cs.setPresence(ctx, uid, did, online, now)
+	if err != nil || !changed {
+		return err
+	}
+	up, err := cs.getUserPresence(ctx, uid)
+	if err != nil {
+		return err
+	}
+	cs.notifyPresence(ctx, &message.PresenceMsg{
+		UserID:       uid,
+		DeviceID:     did,
+		Online:       up.Online,
+		DeviceOnline: online,
+		LastActive:   now,
+	})
+	return nil
+}
+
+func (cs *cacheState) setPresence(ctx context.Context, uid, did uint64, online bool, lastActive int64) (bool, error) {
+	return cs.store.UpdatePresence(ctx, fmt.Sprintf(cache.PresenceKey, uid), did, online, lastActive, cache.TTL7D)
+}
+
+// query the presence of a batch of users, unknown users are reported offline
+func (cs *cacheState) queryPresence(ctx context.Context, uids []uint64) ([]*service.UserPresence, error) {
+	res := make([]*service.UserPresence, 0, len(uids))
+	for _, uid := range uids {
+		up, err := cs.getUserPresence(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, up)
+	}
+	return res, nil
+}
+
+func (cs *cacheState) getUserPresence(ctx context.Context, uid uint64) (*service.UserPresence, error) {
+	key := fmt.Sprintf(cache.PresenceKey, uid)
+	data, err := cs.store.HGetAllStrMap(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	up := &service.UserPresence{UserID: uid}
+	for field, value := range data {
+		did, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		online, lastActive := presenceUnmarshal(value)
+		up.Devices = append(up.Devices, &service.DevicePresence{
+			DeviceID:   did,
+			Online:     online,
+			LastActive: lastActive,
+		})
+		up.Online = up.Online || online
+		if lastActive > up.LastActive {
+			up.LastActive = lastActive
+		}
+	}
+	return up, nil
+}
+
+// the current presence of the user in the form of the change notifications, one per device,
+// so that a subscriber starts from the same view the following changes are applied to
+func presenceSnapshot(up *service.UserPresence) []*message.PresenceMsg {
+	if len(up.Devices) == 0 {
+		return []*message.PresenceMsg{{UserID: up.UserID, Online: up.Online, LastActive: up.LastActive}}
+	}
+	pms := make([]*message.PresenceMsg, 0, len(up.Devices))
+	for _, dp := range up.Devices {
+		pms = append(pms, &message.PresenceMsg{
+			UserID:       up.UserID,
+			DeviceID:     dp.DeviceID,
+			Online:       up.Online,
+			DeviceOnline: dp.Online,
+			LastActive:   dp.LastActive,
+		})
+	}
+	return pms
+}
+
+// subscribe presence changes of the given users for the connection
+func (cs *cacheState) subscribePresence(ctx context.Context, connID uint64, uids []uint64) error {
+	connSubKey := cs.connKey(cache.ConnPresenceSubKey, connID)
+	if err := cs.store.SAddIndex(ctx, cs.getConnKeysKey(connID), cache.TTL7D, connSubKey); err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if err := cs.store.SADD(ctx, fmt.Sprintf(cache.PresenceSubKey, uid), connID); err != nil {
+			return err
+		}
+		if err := cs.store.SADD(ctx, connSubKey, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *cacheState) unsubscribePresence(ctx context.Context, connID uint64, uids []uint64) error {
+	connSubKey := cs.connKey(cache.ConnPresenceSubKey, connID)
+	for _, uid := range uids {
+		if err := cs.store.SREM(ctx, fmt.Sprintf(cache.PresenceSubKey, uid), connID); err != nil {
+			return err
+		}
+		if err := cs.store.SREM(ctx, connSubKey, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop all the subscriptions of the connection, called when the connection logs out
+func (cs *cacheState) unsubscribeAllPresence(ctx context.Context, connID uint64) error {
+	connSubKey := cs.connKey(cache.ConnPresenceSubKey, connID)
+	members, err := cs.store.SmembersStrSlice(ctx, connSubKey)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		uid, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := cs.store.SREM(ctx, fmt.Sprintf(cache.PresenceSubKey, uid), connID); err != nil {
+			return err
+		}
+	}
+	return cs.store.Del(ctx, connSubKey)
+}
+
+// push the presence change to every subscribed connection
+func (cs *cacheState) notifyPresence(ctx context.Context, pm *message.PresenceMsg) {
+	members, err := cs.store.SmembersStrSlice(ctx, fmt.Sprintf(cache.PresenceSubKey, pm.UserID))
+	if err != nil {
+		fmt.Printf("[ERROR] notifyPresence:err=%s\n", err.Error())
+		return
+	}
+	if len(members) == 0 {
+		return
+	}
+	data, err := proto.Marshal(pm)
+	if err != nil {
+		fmt.Printf("[ERROR] notifyPresence:err=%s\n", err.Error())
+		return
+	}
+	for _, member := range members {
+		connID, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		sendMsg(connID, message.CmdType_Presence, data)
+	}
+}
+
+func presenceUnmarshal(value string) (bool, int64) {
+	strs := strings.Split(value, "|")
+	if len(strs) < 2 {
+		return false, 0
+	}
+	lastActive, _ := strconv.ParseInt(strs[1], 10, 64)
+	return strs[0] == "1", lastActive
+}
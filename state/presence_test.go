@@ -0,0 +1,103 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/state/rpc/service"
+)
+
+func TestPresenceTransitions(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	steps := []struct {
+		did     uint64
+		online  bool
+		changed bool
+	}{
+		{1, true, true},
+		{1, true, false}, // heartbeat touch
+		{2, true, true},
+		{1, false, true},
+	}
+	for i, step := range steps {
+		changed, err := cs.setPresence(ctx, 9, step.did, step.online, int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != step.changed {
+			t.Fatalf("step %d: changed %v, want %v", i, changed, step.changed)
+		}
+	}
+	up, err := cs.getUserPresence(ctx, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the user stays online while one of the devices is
+	if !up.Online || up.LastActive != 3 || len(up.Devices) != 2 {
+		t.Fatalf("presence got %v", up)
+	}
+}
+
+func TestPresenceSnapshot(t *testing.T) {
+	up := &service.UserPresence{
+		UserID:     9,
+		Online:     true,
+		LastActive: 3,
+		Devices: []*service.DevicePresence{
+			{DeviceID: 1, Online: false, LastActive: 3},
+			{DeviceID: 2, Online: true, LastActive: 2},
+		},
+	}
+	pms := presenceSnapshot(up)
+	if len(pms) != 2 {
+		t.Fatalf("got %d presence msgs, want 2", len(pms))
+	}
+	for i, pm := range pms {
+		dp := up.Devices[i]
+		if pm.UserID != 9 || !pm.Online || pm.DeviceID != dp.DeviceID || pm.DeviceOnline != dp.Online || pm.LastActive != dp.LastActive {
+			t.Fatalf("device %d got %v", dp.DeviceID, pm)
+		}
+	}
+	// a user never seen has no device, it is reported offline
+	pms = presenceSnapshot(&service.UserPresence{UserID: 8})
+	if len(pms) != 1 || pms[0].UserID != 8 || pms[0].Online || pms[0].DeviceID != 0 {
+		t.Fatalf("unknown user got %v", pms)
+	}
+}
+
+func TestPresenceSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	const connID = 5
+	if err := cs.subscribePresence(ctx, connID, []uint64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.unsubscribePresence(ctx, connID, []uint64{2}); err != nil {
+		t.Fatal(err)
+	}
+	for uid, subscribed := range map[uint64]bool{1: true, 2: false, 3: true} {
+		ok, err := cs.store.SIsMember(ctx, fmt.Sprintf(cache.PresenceSubKey, uid), connID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != subscribed {
+			t.Fatalf("user %d subscribed %v, want %v", uid, ok, subscribed)
+		}
+	}
+	// the logout drops the rest, on both sides
+	if err := cs.unsubscribeAllPresence(ctx, connID); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []uint64{1, 3} {
+		if members, _ := cs.store.SmembersStrSlice(ctx, fmt.Sprintf(cache.PresenceSubKey, uid)); len(members) != 0 {
+			t.Fatalf("user %d still subscribed by %v", uid, members)
+		}
+	}
+	connSubKey := cs.connKey(cache.ConnPresenceSubKey, connID)
+	if members, _ := cs.store.SmembersStrSlice(ctx, connSubKey); len(members) != 0 {
+		t.Fatalf("subscriptions of the connection left: %v", members)
+	}
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"github.com/feichai0017/GoChat/state/rpc/service"
+	"google.golang.org/protobuf/proto"
+)
+
+// route a delivery or read receipt back to every online device of the original sender.
+// A read receipt also moves the read cursor of the session forward, it is dropped if the cursor did not move.
+func (cs *cacheState) deliverReceipt(ctx context.Context, connID uint64, ty message.CmdType, receipt *message.ReceiptMsg) error {
+	if state, ok := cs.loadConnIDState(connID); ok {
+		receipt.FromUserID, receipt.FromDeviceID = state.uid, state.did
+	}
+	if receipt.FromUserID == 0 || receipt.ToUserID == 0 {
+		return nil
+	}
+	if receipt.Timestamp == 0 {
+		receipt.Timestamp = time.Now().UnixMilli()
+	}
+	if ty == message.CmdType_Read {
+		advanced, err := cs.advanceReadCursor(ctx, receipt.FromUserID, receiptSession(receipt), receipt.MsgID)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return nil
+		}
+	}
+	data, err := proto.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	batches, err := cs.groupConnBatches(ctx, []uint64{receipt.ToUserID}, 0)
+	if err != nil {
+		return err
+	}
+	for endpoint, connIDs := range batches {
+		batchSendMsg(ctx, endpoint, connIDs, ty, data)
+	}
+	return nil
+}
+
+// the session of a receipt seen from the reader, a group or the peer of a direct chat
+func receiptSession(receipt *message.ReceiptMsg) string {
+	return sessionKey(receipt.SessionID, receipt.ToUserID)
+}
+
+// a session seen from one of its users, the group or the peer of a direct chat
+func sessionKey(groupID, peerUID uint64) string {
+	if groupID != 0 {
+		return fmt.Sprintf("group_%d", groupID)
+	}
+	return fmt.Sprintf("user_%d", peerUID)
+}
+
+// persist the last read msgID of the session, the unread count of a session is its latest msgID minus the cursor
+func (cs *cacheState) advanceReadCursor(ctx context.Context, uid uint64, session string, msgID uint64) (bool, error) {
+	return cs.store.AdvanceCursor(ctx, fmt.Sprintf(cache.ReadCursorKey, uid), session, msgID, cache.TTL7D)
+}
+
+// record the latest msgID of the direct session for both users, the sender has read its own message
+func (cs *cacheState) advanceSessionSeq(ctx context.Context, fromUID, toUID, msgID uint64) error {
+	if _, err := cs.store.AdvanceCursor(ctx, fmt.Sprintf(cache.SessionSeqKey, toUID), sessionKey(0, fromUID), msgID, cache.TTL7D); err != nil {
+		return err
+	}
+	if fromUID == 0 {
+		return nil
+	}
+	if _, err := cs.store.AdvanceCursor(ctx, fmt.Sprintf(cache.SessionSeqKey, fromUID), sessionKey(0, toUID), msgID, cache.TTL7D); err != nil {
+		return err
+	}
+	_, err := cs.advanceReadCursor(ctx, fromUID, sessionKey(0, toUID), msgID)
+	return err
+}
+
+// the messages of the session after the read cursor of the user.
+// The latest msgID of a group is its allocator, the one of a direct session is recorded on delivery.
+func (cs *cacheState) unreadCount(ctx context.Context, uid, groupID, peerUID uint64) (uint64, error) {
+	session := sessionKey(groupID, peerUID)
+	var latest string
+	if groupID != 0 {
+		data, err := cs.store.GetBytes(ctx, fmt.Sprintf(cache.GroupMsgIDKey, groupID))
+		if err != nil {
+			return 0, err
+		}
+		latest = string(data)
+	} else {
+		var err error
+		if latest, err = cs.store.HGetString(ctx, fmt.Sprintf(cache.SessionSeqKey, uid), session); err != nil {
+			return 0, err
+		}
+	}
+	read, err := cs.store.HGetString(ctx, fmt.Sprintf(cache.ReadCursorKey, uid), session)
+	if err != nil {
+		return 0, err
+	}
+	latestID, _ := strconv.ParseUint(latest, 10, 64)
+	readID, _ := strconv.ParseUint(read, 10, 64)
+	if latestID <= readID {
+		return 0, nil
+	}
+	return latestID - readID, nil
+}
+
+// fill in the unread count of every session of the user
+func (cs *cacheState) queryUnread(ctx context.Context, uid uint64, sessions []*service.UnreadSession) ([]*service.UnreadSession, error) {
+	res := make([]*service.UnreadSession, 0, len(sessions))
+	for _, session := range sessions {
+		unread, err := cs.unreadCount(ctx, uid, session.GroupID, session.PeerUserID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &service.UnreadSession{GroupID: session.GroupID, PeerUserID: session.PeerUserID, Unread: unread})
+	}
+	return res, nil
+}
@@ -0,0 +1,136 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	exhaustedPolicyOffline    = "offline"
+	exhaustedPolicyDeadLetter = "dead_letter"
+)
+
+// retransmission policy of the last down-stream message of a connection
+type retransmitPolicy struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	multiplier      float64
+	jitter          float64
+	maxAttempts     int
+	deadline        time.Duration
+	exhaustedPolicy string
+	deadLetterSize  int64
+}
+
+func newRetransmitPolicy() *retransmitPolicy {
+	return &retransmitPolicy{
+		initialInterval: config.GetStateRetransmitInitialInterval(),
+		maxInterval:     config.GetStateRetransmitMaxInterval(),
+		multiplier:      config.GetStateRetransmitMultiplier(),
+		jitter:          config.GetStateRetransmitJitter(),
+		maxAttempts:     config.GetStateRetransmitMaxAttempts(),
+		deadline:        config.GetStateRetransmitDeadline(),
+		exhaustedPolicy: config.GetStateRetransmitExhaustedPolicy(),
+		deadLetterSize:  config.GetStateRetransmitDeadLetterSize(),
+	}
+}
+
+// backoff before the given attempt, attempt 0 is the first retransmission
+func (p *retransmitPolicy) backoff(attempt int) time.Duration {
+	interval := float64(p.initialInterval) * math.Pow(p.multiplier, float64(attempt))
+	if interval > float64(p.maxInterval) {
+		interval = float64(p.maxInterval)
+	}
+	if p.jitter > 0 {
+		interval += interval * p.jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(interval)
+}
+
+// whether the message should not be retransmitted anymore
+func (p *retransmitPolicy) exhausted(attempts int, firstPushed time.Time) bool {
+	return attempts >= p.maxAttempts || time.Since(firstPushed) >= p.deadline
+}
+
+// move the message which exhausted its retries out of the retransmission path.
+// A message delivered from the offline messages is still kept there, unless it goes to the dead letters.
+func (cs *cacheState) exhaustLastMsg(ctx context.Context, state *connState, pushMsg *message.PushMsg) error {
+	data, err := proto.Marshal(pushMsg)
+	if err != nil {
+		return err
+	}
+	head, headData, err := cs.offlineHead(ctx, state.did)
+	if err != nil {
+		return err
+	}
+	isHead := head != nil && pushMsgKey(head) == pushMsgKey(pushMsg)
+	if cs.retransmit.exhaustedPolicy == exhaustedPolicyDeadLetter {
+		if isHead {
+			if err = cs.store.LRemBytes(ctx, fmt.Sprintf(cache.OfflineMsgKey, state.did), headData); err != nil {
+				return err
+			}
+		}
+		err = cs.store.RPushBytesCapped(ctx, cache.DeadLetterMsgKey, data, cs.retransmit.deadLetterSize, cache.TTL7D)
+	} else if !isHead {
+		err = cs.store.RPushBytes(ctx, fmt.Sprintf(cache.OfflineMsgKey, state.did), data, cache.TTL7D)
+	}
+	if err != nil {
+		return err
+	}
+	state.stopMsgTimer()
+	return cs.store.Del(ctx, cs.connKey(cache.LastMsgKey, state.connID))
+}
+
+// deliver the messages stored while the device was unreachable, called on login.
+// They go through the ack and retransmission path one at a time, starting from the first one,
+// and a message is only trimmed from the list when it is acked.
+func (cs *cacheState) syncOfflineMsg(ctx context.Context, did, connID uint64) error {
+	pm, _, err := cs.offlineHead(ctx, did)
+	if err != nil || pm == nil {
+		return err
+	}
+	pushMsg(ctx, connID, pm)
+	return nil
+}
+
+// trim the acked offline message and deliver the next one. The first offline message is delivered again
+// if it is no longer in flight, e.g. a live message has taken its place as the last msg.
+func (cs *cacheState) ackOfflineMsg(ctx context.Context, state *connState, key msgKey) error {
+	pm, data, err := cs.offlineHead(ctx, state.did)
+	if err != nil || pm == nil {
+		return err
+	}
+	if pushMsgKey(pm) == key {
+		if err = cs.store.LRemBytes(ctx, fmt.Sprintf(cache.OfflineMsgKey, state.did), data); err != nil {
+			return err
+		}
+		if pm, _, err = cs.offlineHead(ctx, state.did); err != nil || pm == nil {
+			return err
+		}
+	} else if state.inFlight(pushMsgKey(pm)) {
+		return nil
+	}
+	pushMsg(ctx, state.connID, pm)
+	return nil
+}
+
+// the first offline message of the device and its encoding, nil if there is none
+func (cs *cacheState) offlineHead(ctx context.Context, did uint64) (*message.PushMsg, []byte, error) {
+	msgs, err := cs.store.LRangeBytes(ctx, fmt.Sprintf(cache.OfflineMsgKey, did))
+	if err != nil || len(msgs) == 0 {
+		return nil, nil, err
+	}
+	pm := &message.PushMsg{}
+	if err = proto.Unmarshal(msgs[0], pm); err != nil {
+		return nil, nil, err
+	}
+	return pm, msgs[0], nil
+}
@@ -0,0 +1,139 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/feichai0017/GoChat/common/cache"
+	"github.com/feichai0017/GoChat/common/idl/message"
+)
+
+func TestRetransmitBackoff(t *testing.T) {
+	p := &retransmitPolicy{
+		initialInterval: 100 * time.Millisecond,
+		maxInterval:     time.Second,
+		multiplier:      2,
+	}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) got %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+
+	// the jitter stays within its ratio of the interval
+	p.jitter = 0.2
+	for i := 0; i < 100; i++ {
+		got := p.backoff(1)
+		if got < 160*time.Millisecond || got > 240*time.Millisecond {
+			t.Fatalf("backoff(1) with jitter got %v, want within [160ms, 240ms]", got)
+		}
+	}
+}
+
+func TestRetransmitExhausted(t *testing.T) {
+	p := &retransmitPolicy{maxAttempts: 3, deadline: time.Minute}
+	tests := []struct {
+		name        string
+		attempts    int
+		firstPushed time.Time
+		want        bool
+	}{
+		{"fresh", 0, time.Now(), false},
+		{"attempts left", 2, time.Now(), false},
+		{"attempts used", 3, time.Now(), true},
+		{"deadline passed", 1, time.Now().Add(-2 * time.Minute), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.exhausted(tt.attempts, tt.firstPushed); got != tt.want {
+				t.Fatalf("exhausted(%d) got %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncOfflineMsg(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	offlineKey := fmt.Sprintf(cache.OfflineMsgKey, 11)
+	for _, msgID := range []uint64{1, 2} {
+		data, _ := proto.Marshal(&message.PushMsg{MsgID: msgID})
+		if err := cs.store.RPushBytes(ctx, offlineKey, data, cache.TTL7D); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the offline messages are delivered one at a time, each one is trimmed when acked
+	testLogin(t, cs, 1, 11, 101)
+	if got := pushes.pushed(101); !reflect.DeepEqual(got, []uint64{1}) {
+		t.Fatalf("pushed %v on login, want [1]", got)
+	}
+	cs.ackLastMsg(ctx, 101, msgKey{msgID: 1})
+	if got := pushes.pushed(101); !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Fatalf("pushed %v after the first ack, want [1 2]", got)
+	}
+	if msgs, _ := cs.store.LRangeBytes(ctx, offlineKey); len(msgs) != 1 {
+		t.Fatalf("%d offline msgs left after the first ack, want 1", len(msgs))
+	}
+
+	// an exhausted offline message is kept in the list for the next login
+	state, _ := cs.loadConnIDState(101)
+	pm, _ := cs.getLastMsg(ctx, 101)
+	if err := cs.exhaustLastMsg(ctx, state, pm); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := cs.store.LRangeBytes(ctx, offlineKey); len(msgs) != 1 {
+		t.Fatalf("%d offline msgs left after exhausting, want 1", len(msgs))
+	}
+	if err := cs.syncOfflineMsg(ctx, 11, 101); err != nil {
+		t.Fatal(err)
+	}
+	cs.ackLastMsg(ctx, 101, msgKey{msgID: 2})
+	if msgs, _ := cs.store.LRangeBytes(ctx, offlineKey); len(msgs) != 0 {
+		t.Fatalf("%d offline msgs left after the last ack, want 0", len(msgs))
+	}
+}
+
+func TestDeadLetterCapped(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	recordPushes(t)
+	cs.retransmit.exhaustedPolicy = exhaustedPolicyDeadLetter
+	cs.retransmit.deadLetterSize = 2
+	state := testLogin(t, cs, 1, 11, 101)
+
+	for msgID := uint64(1); msgID <= 3; msgID++ {
+		if err := cs.exhaustLastMsg(ctx, state, &message.PushMsg{MsgID: msgID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := cs.store.LRangeBytes(ctx, cache.DeadLetterMsgKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []uint64
+	for _, data := range msgs {
+		pm := &message.PushMsg{}
+		proto.Unmarshal(data, pm)
+		got = append(got, pm.MsgID)
+	}
+	if want := []uint64{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dead letters %v, want the latest %v", got, want)
+	}
+}
@@ -2,42 +2,88 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/feichai0017/GoChat/common/config"
 	"github.com/feichai0017/GoChat/common/crpc"
+	clientinterceptor "github.com/feichai0017/GoChat/common/crpc/interceptor/client"
 	"github.com/feichai0017/GoChat/gateway/rpc/service"
 )
 
-var gatewayClient service.GatewayClient
+const (
+	// a saturated gateway rejects the command, it is retried before the command is shed
+	retryAttempts = 3
+	retryBackoff  = 10 * time.Millisecond
+)
+
+var (
+	gatewayClient  service.GatewayClient // default gateway
+	gatewayPCli    *crpc.CClient
+	gatewayClients sync.Map // gateway endpoint -> service.GatewayClient
+)
 
 func initGatewayClient() {
-	pCli, err := crpc.NewCClient(config.GetGatewayServiceName())
+	var err error
+	gatewayPCli, err = crpc.NewCClient(config.GetGatewayServiceName(), clientinterceptor.RetryUnaryClientInterceptor(retryAttempts, retryBackoff))
 	if err != nil {
 		panic(err)
 	}
-	conn, err := pCli.DialByEndPoint(config.GetStateServerGatewayServerEndpoint())
+	conn, err := gatewayPCli.DialByEndPoint(config.GetStateServerGatewayServerEndpoint())
 	if err != nil {
 		panic(err)
 	}
 	gatewayClient = service.NewGatewayClient(conn)
 }
 
+// get the client of the gateway holding the connections, falls back to the default gateway
+func getGatewayClient(endpoint string) service.GatewayClient {
+	if endpoint == "" {
+		return gatewayClient
+	}
+	if cli, ok := gatewayClients.Load(endpoint); ok {
+		return cli.(service.GatewayClient)
+	}
+	conn, err := gatewayPCli.DialByEndPoint(endpoint)
+	if err != nil {
+		fmt.Printf("[ERROR] dial gateway %s error %s\n", endpoint, err.Error())
+		return gatewayClient
+	}
+	cli, _ := gatewayClients.LoadOrStore(endpoint, service.NewGatewayClient(conn))
+	return cli.(service.GatewayClient)
+}
+
 func DelConn(ctx *context.Context, connID uint64, Payload []byte) error {
 	rpcCtx, cancel := context.WithTimeout(*ctx, 100*time.Millisecond)
 	defer cancel()
-	gatewayClient.DelConn(rpcCtx, &service.GatewayRequest{ConnID: connID, Data: Payload})
-	return nil
+	resp, err := gatewayClient.DelConn(rpcCtx, &service.GatewayRequest{ConnID: connID, Data: Payload})
+	return respErr(resp, err)
 }
 
 func Push(ctx *context.Context, connID uint64, Payload []byte) error {
-	rpcCtx, cancel := context.WithTimeout(*ctx, 100*time.Second)
+	rpcCtx, cancel := context.WithTimeout(*ctx, 100*time.Millisecond)
 	defer cancel()
 	resp, err := gatewayClient.Push(rpcCtx, &service.GatewayRequest{ConnID: connID, Data: Payload})
+	return respErr(resp, err)
+}
+
+// push the same payload to a batch of connections held by the gateway endpoint
+func BatchPush(ctx *context.Context, endpoint string, connIDs []uint64, Payload []byte) error {
+	rpcCtx, cancel := context.WithTimeout(*ctx, 100*time.Millisecond)
+	defer cancel()
+	resp, err := getGatewayClient(endpoint).BatchPush(rpcCtx, &service.BatchPushRequest{ConnIDs: connIDs, Data: Payload})
+	return respErr(resp, err)
+}
+
+// the gateway reports the outcome of the command in the response code
+func respErr(resp *service.GatewayResponse, err error) error {
 	if err != nil {
-		fmt.Println(err)
+		return err
+	}
+	if resp.GetCode() != 0 {
+		return errors.New(resp.GetMsg())
 	}
-	fmt.Println(resp)
 	return nil
 }
\ No newline at end of file
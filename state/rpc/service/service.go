@@ -3,6 +3,9 @@ package service
 import (
 	context "context"
 	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -18,37 +21,84 @@ type CmdContext struct {
 	Payload  []byte
 }
 
+// ErrSlotNotOwned rejects the command of a connection whose slot is held by another state server,
+// the caller should route it again after the slot owners are updated
+var ErrSlotNotOwned = status.Error(codes.FailedPrecondition, "slot of the connection is not owned")
+
 type Service struct {
-	CmdChannel chan *CmdContext
+	// Dispatch admits the command without blocking, it reports false when the queue is saturated
+	Dispatch func(cmdCtx *CmdContext) bool
+	// Owns reports whether the slot of the connection is served here, nil means every slot is
+	Owns          func(connID uint64) bool
+	PresenceQuery func(ctx context.Context, userIDs []uint64) ([]*UserPresence, error)
+	UnreadQuery   func(ctx context.Context, userID uint64, sessions []*UnreadSession) ([]*UnreadSession, error)
 	UnimplementedStateServer
 }
 
 func (s *Service) CancelConn(ctx context.Context, sr *StateRequest) (*StateResponse, error) {
 	c := context.TODO()
-	s.CmdChannel <- &CmdContext{
+	return s.admit(&CmdContext{
 		Ctx:      &c,
 		Cmd:      CancelConnCmd,
 		ConnID:   sr.ConnID,
 		Endpoint: sr.GetEndpoint(),
-	}
-	return &StateResponse{
-		Code: 0,
-		Msg:  "success",
-	}, nil
+	})
 }
 
 func (s *Service) SendMsg(ctx context.Context, sr *StateRequest) (*StateResponse, error) {
 	c := context.TODO()
-	s.CmdChannel <- &CmdContext{
+	return s.admit(&CmdContext{
 		Ctx:      &c,
 		Cmd:      SendMsgCmd,
 		ConnID:   sr.ConnID,
 		Endpoint: sr.GetEndpoint(),
 		Payload:  sr.GetData(),
+	})
+}
+
+// the commands are processed asynchronously, so success only means the command is queued.
+// The outcome of an up-stream message is reported to the client by the ack.
+func (s *Service) admit(cmdCtx *CmdContext) (*StateResponse, error) {
+	if s.Owns != nil && !s.Owns(cmdCtx.ConnID) {
+		return nil, ErrSlotNotOwned
+	}
+	if !s.Dispatch(cmdCtx) {
+		return nil, status.Error(codes.ResourceExhausted, "state cmd queue is full")
 	}
-	fmt.Printf("[INFO] SendMsg connID=%d, channel=%d\n", sr.ConnID, len(s.CmdChannel))
 	return &StateResponse{
 		Code: 0,
 		Msg:  "success",
 	}, nil
-}
\ No newline at end of file
+}
+
+func (s *Service) QueryPresence(ctx context.Context, pr *PresenceRequest) (*PresenceResponse, error) {
+	presences, err := s.PresenceQuery(ctx, pr.GetUserIDs())
+	if err != nil {
+		fmt.Printf("[ERROR] QueryPresence err=%s\n", err.Error())
+		return &PresenceResponse{
+			Code: 1,
+			Msg:  err.Error(),
+		}, nil
+	}
+	return &PresenceResponse{
+		Code:      0,
+		Msg:       "success",
+		Presences: presences,
+	}, nil
+}
+
+func (s *Service) QueryUnread(ctx context.Context, ur *UnreadRequest) (*UnreadResponse, error) {
+	sessions, err := s.UnreadQuery(ctx, ur.GetUserID(), ur.GetSessions())
+	if err != nil {
+		fmt.Printf("[ERROR] QueryUnread err=%s\n", err.Error())
+		return &UnreadResponse{
+			Code: 1,
+			Msg:  err.Error(),
+		}, nil
+	}
+	return &UnreadResponse{
+		Code:     0,
+		Msg:      "success",
+		Sessions: sessions,
+	}, nil
+}
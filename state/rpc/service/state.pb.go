@@ -133,6 +133,411 @@ func (x *StateResponse) GetMsg() string {
 	return ""
 }
 
+type PresenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserIDs       []uint64               `protobuf:"varint,1,rep,packed,name=userIDs,proto3" json:"userIDs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PresenceRequest) Reset() {
+	*x = PresenceRequest{}
+	mi := &file_state_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PresenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PresenceRequest) ProtoMessage() {}
+
+func (x *PresenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PresenceRequest.ProtoReflect.Descriptor instead.
+func (*PresenceRequest) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PresenceRequest) GetUserIDs() []uint64 {
+	if x != nil {
+		return x.UserIDs
+	}
+	return nil
+}
+
+type DevicePresence struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceID      uint64                 `protobuf:"varint,1,opt,name=deviceID,proto3" json:"deviceID,omitempty"`
+	Online        bool                   `protobuf:"varint,2,opt,name=online,proto3" json:"online,omitempty"`
+	LastActive    int64                  `protobuf:"varint,3,opt,name=lastActive,proto3" json:"lastActive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DevicePresence) Reset() {
+	*x = DevicePresence{}
+	mi := &file_state_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DevicePresence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DevicePresence) ProtoMessage() {}
+
+func (x *DevicePresence) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DevicePresence.ProtoReflect.Descriptor instead.
+func (*DevicePresence) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DevicePresence) GetDeviceID() uint64 {
+	if x != nil {
+		return x.DeviceID
+	}
+	return 0
+}
+
+func (x *DevicePresence) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+func (x *DevicePresence) GetLastActive() int64 {
+	if x != nil {
+		return x.LastActive
+	}
+	return 0
+}
+
+type UserPresence struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserID        uint64                 `protobuf:"varint,1,opt,name=userID,proto3" json:"userID,omitempty"`
+	Online        bool                   `protobuf:"varint,2,opt,name=online,proto3" json:"online,omitempty"`
+	LastActive    int64                  `protobuf:"varint,3,opt,name=lastActive,proto3" json:"lastActive,omitempty"`
+	Devices       []*DevicePresence      `protobuf:"bytes,4,rep,name=devices,proto3" json:"devices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserPresence) Reset() {
+	*x = UserPresence{}
+	mi := &file_state_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserPresence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserPresence) ProtoMessage() {}
+
+func (x *UserPresence) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserPresence.ProtoReflect.Descriptor instead.
+func (*UserPresence) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UserPresence) GetUserID() uint64 {
+	if x != nil {
+		return x.UserID
+	}
+	return 0
+}
+
+func (x *UserPresence) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+func (x *UserPresence) GetLastActive() int64 {
+	if x != nil {
+		return x.LastActive
+	}
+	return 0
+}
+
+func (x *UserPresence) GetDevices() []*DevicePresence {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type PresenceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Msg           string                 `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	Presences     []*UserPresence        `protobuf:"bytes,3,rep,name=presences,proto3" json:"presences,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PresenceResponse) Reset() {
+	*x = PresenceResponse{}
+	mi := &file_state_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PresenceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PresenceResponse) ProtoMessage() {}
+
+func (x *PresenceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PresenceResponse.ProtoReflect.Descriptor instead.
+func (*PresenceResponse) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PresenceResponse) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *PresenceResponse) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+func (x *PresenceResponse) GetPresences() []*UserPresence {
+	if x != nil {
+		return x.Presences
+	}
+	return nil
+}
+
+// a group session, or the direct session with the peer when groupID is 0
+type UnreadSession struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	GroupID       uint64                 `protobuf:"varint,1,opt,name=groupID,proto3" json:"groupID,omitempty"`
+	PeerUserID    uint64                 `protobuf:"varint,2,opt,name=peerUserID,proto3" json:"peerUserID,omitempty"`
+	Unread        uint64                 `protobuf:"varint,3,opt,name=unread,proto3" json:"unread,omitempty"` // messages after the read cursor, filled by the server
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnreadSession) Reset() {
+	*x = UnreadSession{}
+	mi := &file_state_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnreadSession) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnreadSession) ProtoMessage() {}
+
+func (x *UnreadSession) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnreadSession.ProtoReflect.Descriptor instead.
+func (*UnreadSession) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *UnreadSession) GetGroupID() uint64 {
+	if x != nil {
+		return x.GroupID
+	}
+	return 0
+}
+
+func (x *UnreadSession) GetPeerUserID() uint64 {
+	if x != nil {
+		return x.PeerUserID
+	}
+	return 0
+}
+
+func (x *UnreadSession) GetUnread() uint64 {
+	if x != nil {
+		return x.Unread
+	}
+	return 0
+}
+
+type UnreadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserID        uint64                 `protobuf:"varint,1,opt,name=userID,proto3" json:"userID,omitempty"`
+	Sessions      []*UnreadSession       `protobuf:"bytes,2,rep,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnreadRequest) Reset() {
+	*x = UnreadRequest{}
+	mi := &file_state_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnreadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnreadRequest) ProtoMessage() {}
+
+func (x *UnreadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnreadRequest.ProtoReflect.Descriptor instead.
+func (*UnreadRequest) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *UnreadRequest) GetUserID() uint64 {
+	if x != nil {
+		return x.UserID
+	}
+	return 0
+}
+
+func (x *UnreadRequest) GetSessions() []*UnreadSession {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
+type UnreadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Msg           string                 `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	Sessions      []*UnreadSession       `protobuf:"bytes,3,rep,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnreadResponse) Reset() {
+	*x = UnreadResponse{}
+	mi := &file_state_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnreadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnreadResponse) ProtoMessage() {}
+
+func (x *UnreadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_state_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnreadResponse.ProtoReflect.Descriptor instead.
+func (*UnreadResponse) Descriptor() ([]byte, []int) {
+	return file_state_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *UnreadResponse) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *UnreadResponse) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+func (x *UnreadResponse) GetSessions() []*UnreadSession {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
 var File_state_proto protoreflect.FileDescriptor
 
 const file_state_proto_rawDesc = "" +
@@ -144,11 +549,45 @@ const file_state_proto_rawDesc = "" +
 	"\x04data\x18\x03 \x01(\fR\x04data\"5\n" +
 	"\rStateResponse\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x10\n" +
-	"\x03msg\x18\x02 \x01(\tR\x03msg2~\n" +
+	"\x03msg\x18\x02 \x01(\tR\x03msg\"+\n" +
+	"\x0fPresenceRequest\x12\x18\n" +
+	"\auserIDs\x18\x01 \x03(\x04R\auserIDs\"d\n" +
+	"\x0eDevicePresence\x12\x1a\n" +
+	"\bdeviceID\x18\x01 \x01(\x04R\bdeviceID\x12\x16\n" +
+	"\x06online\x18\x02 \x01(\bR\x06online\x12\x1e\n" +
+	"\n" +
+	"lastActive\x18\x03 \x01(\x03R\n" +
+	"lastActive\"\x91\x01\n" +
+	"\fUserPresence\x12\x16\n" +
+	"\x06userID\x18\x01 \x01(\x04R\x06userID\x12\x16\n" +
+	"\x06online\x18\x02 \x01(\bR\x06online\x12\x1e\n" +
+	"\n" +
+	"lastActive\x18\x03 \x01(\x03R\n" +
+	"lastActive\x121\n" +
+	"\adevices\x18\x04 \x03(\v2\x17.service.DevicePresenceR\adevices\"m\n" +
+	"\x10PresenceResponse\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x10\n" +
+	"\x03msg\x18\x02 \x01(\tR\x03msg\x123\n" +
+	"\tpresences\x18\x03 \x03(\v2\x15.service.UserPresenceR\tpresences\"a\n" +
+	"\rUnreadSession\x12\x18\n" +
+	"\agroupID\x18\x01 \x01(\x04R\agroupID\x12\x1e\n" +
+	"\n" +
+	"peerUserID\x18\x02 \x01(\x04R\n" +
+	"peerUserID\x12\x16\n" +
+	"\x06unread\x18\x03 \x01(\x04R\x06unread\"[\n" +
+	"\rUnreadRequest\x12\x16\n" +
+	"\x06userID\x18\x01 \x01(\x04R\x06userID\x122\n" +
+	"\bsessions\x18\x02 \x03(\v2\x16.service.UnreadSessionR\bsessions\"j\n" +
+	"\x0eUnreadResponse\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x10\n" +
+	"\x03msg\x18\x02 \x01(\tR\x03msg\x122\n" +
+	"\bsessions\x18\x03 \x03(\v2\x16.service.UnreadSessionR\bsessions2\x84\x02\n" +
 	"\x05state\x12;\n" +
 	"\n" +
 	"CancelConn\x12\x15.service.StateRequest\x1a\x16.service.StateResponse\x128\n" +
-	"\aSendMsg\x12\x15.service.StateRequest\x1a\x16.service.StateResponseB\fZ\n" +
+	"\aSendMsg\x12\x15.service.StateRequest\x1a\x16.service.StateResponse\x12D\n" +
+	"\rQueryPresence\x12\x18.service.PresenceRequest\x1a\x19.service.PresenceResponse\x12>\n" +
+	"\vQueryUnread\x12\x16.service.UnreadRequest\x1a\x17.service.UnreadResponseB\fZ\n" +
 	"./;serviceb\x06proto3"
 
 var (
@@ -163,21 +602,36 @@ func file_state_proto_rawDescGZIP() []byte {
 	return file_state_proto_rawDescData
 }
 
-var file_state_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_state_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_state_proto_goTypes = []any{
-	(*StateRequest)(nil),  // 0: service.StateRequest
-	(*StateResponse)(nil), // 1: service.StateResponse
+	(*StateRequest)(nil),     // 0: service.StateRequest
+	(*StateResponse)(nil),    // 1: service.StateResponse
+	(*PresenceRequest)(nil),  // 2: service.PresenceRequest
+	(*DevicePresence)(nil),   // 3: service.DevicePresence
+	(*UserPresence)(nil),     // 4: service.UserPresence
+	(*PresenceResponse)(nil), // 5: service.PresenceResponse
+	(*UnreadSession)(nil),    // 6: service.UnreadSession
+	(*UnreadRequest)(nil),    // 7: service.UnreadRequest
+	(*UnreadResponse)(nil),   // 8: service.UnreadResponse
 }
 var file_state_proto_depIdxs = []int32{
-	0, // 0: service.state.CancelConn:input_type -> service.StateRequest
-	0, // 1: service.state.SendMsg:input_type -> service.StateRequest
-	1, // 2: service.state.CancelConn:output_type -> service.StateResponse
-	1, // 3: service.state.SendMsg:output_type -> service.StateResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	3, // 0: service.UserPresence.devices:type_name -> service.DevicePresence
+	4, // 1: service.PresenceResponse.presences:type_name -> service.UserPresence
+	6, // 2: service.UnreadRequest.sessions:type_name -> service.UnreadSession
+	6, // 3: service.UnreadResponse.sessions:type_name -> service.UnreadSession
+	0, // 4: service.state.CancelConn:input_type -> service.StateRequest
+	0, // 5: service.state.SendMsg:input_type -> service.StateRequest
+	2, // 6: service.state.QueryPresence:input_type -> service.PresenceRequest
+	7, // 7: service.state.QueryUnread:input_type -> service.UnreadRequest
+	1, // 8: service.state.CancelConn:output_type -> service.StateResponse
+	1, // 9: service.state.SendMsg:output_type -> service.StateResponse
+	5, // 10: service.state.QueryPresence:output_type -> service.PresenceResponse
+	8, // 11: service.state.QueryUnread:output_type -> service.UnreadResponse
+	8, // [8:12] is the sub-list for method output_type
+	4, // [4:8] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_state_proto_init() }
@@ -191,7 +645,7 @@ func file_state_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_state_proto_rawDesc), len(file_state_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,8 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	State_CancelConn_FullMethodName = "/service.state/CancelConn"
-	State_SendMsg_FullMethodName    = "/service.state/SendMsg"
+	State_CancelConn_FullMethodName    = "/service.state/CancelConn"
+	State_SendMsg_FullMethodName       = "/service.state/SendMsg"
+	State_QueryPresence_FullMethodName = "/service.state/QueryPresence"
+	State_QueryUnread_FullMethodName   = "/service.state/QueryUnread"
 )
 
 // StateClient is the client API for State service.
@@ -32,6 +34,8 @@ const (
 type StateClient interface {
 	CancelConn(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*StateResponse, error)
 	SendMsg(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*StateResponse, error)
+	QueryPresence(ctx context.Context, in *PresenceRequest, opts ...grpc.CallOption) (*PresenceResponse, error)
+	QueryUnread(ctx context.Context, in *UnreadRequest, opts ...grpc.CallOption) (*UnreadResponse, error)
 }
 
 type stateClient struct {
@@ -62,6 +66,26 @@ func (c *stateClient) SendMsg(ctx context.Context, in *StateRequest, opts ...grp
 	return out, nil
 }
 
+func (c *stateClient) QueryPresence(ctx context.Context, in *PresenceRequest, opts ...grpc.CallOption) (*PresenceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PresenceResponse)
+	err := c.cc.Invoke(ctx, State_QueryPresence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) QueryUnread(ctx context.Context, in *UnreadRequest, opts ...grpc.CallOption) (*UnreadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnreadResponse)
+	err := c.cc.Invoke(ctx, State_QueryUnread_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StateServer is the server API for State service.
 // All implementations must embed UnimplementedStateServer
 // for forward compatibility.
@@ -71,6 +95,8 @@ func (c *stateClient) SendMsg(ctx context.Context, in *StateRequest, opts ...grp
 type StateServer interface {
 	CancelConn(context.Context, *StateRequest) (*StateResponse, error)
 	SendMsg(context.Context, *StateRequest) (*StateResponse, error)
+	QueryPresence(context.Context, *PresenceRequest) (*PresenceResponse, error)
+	QueryUnread(context.Context, *UnreadRequest) (*UnreadResponse, error)
 	mustEmbedUnimplementedStateServer()
 }
 
@@ -87,6 +113,12 @@ func (UnimplementedStateServer) CancelConn(context.Context, *StateRequest) (*Sta
 func (UnimplementedStateServer) SendMsg(context.Context, *StateRequest) (*StateResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendMsg not implemented")
 }
+func (UnimplementedStateServer) QueryPresence(context.Context, *PresenceRequest) (*PresenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryPresence not implemented")
+}
+func (UnimplementedStateServer) QueryUnread(context.Context, *UnreadRequest) (*UnreadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryUnread not implemented")
+}
 func (UnimplementedStateServer) mustEmbedUnimplementedStateServer() {}
 func (UnimplementedStateServer) testEmbeddedByValue()               {}
 
@@ -144,6 +176,42 @@ func _State_SendMsg_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _State_QueryPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PresenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).QueryPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_QueryPresence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).QueryPresence(ctx, req.(*PresenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_QueryUnread_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnreadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).QueryUnread(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_QueryUnread_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).QueryUnread(ctx, req.(*UnreadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // State_ServiceDesc is the grpc.ServiceDesc for State service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -159,6 +227,14 @@ var State_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendMsg",
 			Handler:    _State_SendMsg_Handler,
 		},
+		{
+			MethodName: "QueryPresence",
+			Handler:    _State_QueryPresence_Handler,
+		},
+		{
+			MethodName: "QueryUnread",
+			Handler:    _State_QueryUnread_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "state.proto",
@@ -2,10 +2,13 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/feichai0017/GoChat/common/config"
 	"github.com/feichai0017/GoChat/common/crpc"
+	"github.com/feichai0017/GoChat/common/crpc/prome"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/feichai0017/GoChat/common/idl/message"
@@ -26,8 +29,12 @@ func RunMain(path string) {
 	InitTimer()
 	// start remote cache state machine component
 	InitCacheState(ctx)
-	// start command processing write coroutine
-	go cmdHandler()
+	// start prometheus agent
+	if port := config.GetStatePrometheusPort(); port != 0 {
+		prome.StartAgent(config.GetSateServiceAddr(), port)
+	}
+	// start the command workers sharded by connID
+	cs.workers.start()
 	// register rpc server
 	s := crpc.NewCServer(
 		crpc.WithServiceName(config.GetStateServiceName()),
@@ -40,24 +47,57 @@ func RunMain(path string) {
 	s.Start(ctx)
 }
 
-// consume signal channel, identify the protocol route between gateway and state server
-func cmdHandler() {
-	for cmdCtx := range cs.server.CmdChannel {
-		switch cmdCtx.Cmd {
-		case service.CancelConnCmd:
-			fmt.Printf("[INFO] cancel conn endpoint:%s, coonID:%d, data:%+v\n", cmdCtx.Endpoint, cmdCtx.ConnID, cmdCtx.Payload)
-			cs.connLogOut(*cmdCtx.Ctx, cmdCtx.ConnID)
-		case service.SendMsgCmd:
-			msgCmd := &message.MsgCmd{}
-			err := proto.Unmarshal(cmdCtx.Payload, msgCmd)
-			if err != nil {
-				fmt.Printf("[ERROR] SendMsgCmd:err=%s\n", err.Error())
-			}
-			msgCmdHandler(cmdCtx, msgCmd)
+// identify the protocol route between gateway and state server
+func handleCmd(cmdCtx *service.CmdContext) {
+	// the slot may have been handed over while the command was queued, the new owner serves the connection
+	// from now on and the client resends the messages not acked
+	if !cs.ownsConn(cmdCtx.ConnID) {
+		fmt.Printf("[ERROR] slot not owned, drop cmd=%d connID=%d\n", cmdCtx.Cmd, cmdCtx.ConnID)
+		return
+	}
+	switch cmdCtx.Cmd {
+	case service.CancelConnCmd:
+		fmt.Printf("[INFO] cancel conn endpoint:%s, coonID:%d, data:%+v\n", cmdCtx.Endpoint, cmdCtx.ConnID, cmdCtx.Payload)
+		cs.connLogOut(*cmdCtx.Ctx, cmdCtx.ConnID)
+	case service.SendMsgCmd:
+		msgCmd := &message.MsgCmd{}
+		err := proto.Unmarshal(cmdCtx.Payload, msgCmd)
+		if err != nil {
+			fmt.Printf("[ERROR] SendMsgCmd:err=%s\n", err.Error())
 		}
+		msgCmdHandler(cmdCtx, msgCmd)
 	}
 }
 
+// the other connections whose state the command changes. An ack names the connection of the acked message,
+// which differs from the connection of the command after a re-connection, and a re-connection closes the old connection.
+func cmdConnIDs(cmdCtx *service.CmdContext) []uint64 {
+	if cmdCtx.Cmd != service.SendMsgCmd {
+		return nil
+	}
+	msgCmd := &message.MsgCmd{}
+	if err := proto.Unmarshal(cmdCtx.Payload, msgCmd); err != nil {
+		return nil
+	}
+	var connID uint64
+	switch msgCmd.Type {
+	case message.CmdType_ACK:
+		ackMsg := &message.ACKMsg{}
+		if err := proto.Unmarshal(msgCmd.Payload, ackMsg); err == nil {
+			connID = ackMsg.ConnID
+		}
+	case message.CmdType_ReConn:
+		reConnMsg := &message.ReConnMsg{}
+		if err := proto.Unmarshal(msgCmd.Payload, reConnMsg); err == nil && reConnMsg.Head != nil {
+			connID = reConnMsg.Head.ConnID
+		}
+	}
+	if connID == 0 || connID == cmdCtx.ConnID {
+		return nil
+	}
+	return []uint64{connID}
+}
+
 // identify message type, identify the protocol route between client and state server
 func msgCmdHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 	switch msgCmd.Type {
@@ -71,6 +111,16 @@ func msgCmdHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 		upMsgHandler(cmdCtx, msgCmd)
 	case message.CmdType_ACK:
 		ackMsgHandler(cmdCtx, msgCmd)
+	case message.CmdType_PresenceSub:
+		presenceSubMsgHandler(cmdCtx, msgCmd)
+	case message.CmdType_Group:
+		groupMsgHandler(cmdCtx, msgCmd)
+	case message.CmdType_Delivered, message.CmdType_Read:
+		receiptMsgHandler(cmdCtx, msgCmd)
+	case message.CmdType_Signal:
+		signalMsgHandler(cmdCtx, msgCmd)
+	case message.CmdType_Modify:
+		modifyMsgHandler(cmdCtx, msgCmd)
 	}
 }
 
@@ -86,11 +136,19 @@ func loginMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 		// this will send login msg to business layer for processing
 		fmt.Println("[INFO] loginMsgHandler", loginMsg.Head.DeviceID)
 	}
-	err = cs.connLogin(*cmdCtx.Ctx, loginMsg.Head.DeviceID, cmdCtx.ConnID)
+	heartbeat := negotiateHeartbeat(loginMsg.Head.HeartbeatInterval, loginMsg.Head.NetworkType)
+	err = cs.connLogin(*cmdCtx.Ctx, cmdCtx.Endpoint, loginMsg.Head.UserID, loginMsg.Head.DeviceID, cmdCtx.ConnID, heartbeat)
 	if err != nil {
 		panic(err)
 	}
-	sendACKMsg(message.CmdType_Login, cmdCtx.ConnID, 0, 0, "login ok")
+	// echo the negotiated heartbeat interval back to the client
+	sendACK(&message.ACKMsg{
+		Code:              0,
+		Msg:               "login ok",
+		Type:              message.CmdType_Login,
+		ConnID:            cmdCtx.ConnID,
+		HeartbeatInterval: uint32(heartbeat / time.Second),
+	})
 }
 
 // handle heartbeat message
@@ -101,7 +159,7 @@ func hearbeatMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 		fmt.Printf("[ERROR] hearbeatMsgHandler:err=%s\n", err.Error())
 		return
 	}
-	cs.reSetHeartTimer(cmdCtx.ConnID)
+	cs.reSetHeartTimer(*cmdCtx.Ctx, cmdCtx.ConnID)
 	fmt.Printf("[INFO] hearbeatMsgHandler connID=%d\n", cmdCtx.ConnID)
 	// TODO: not reduce communication, can temporarily not reply heartbeat ack
 }
@@ -117,7 +175,7 @@ func reConnMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 		return
 	}
 	// the connID in the re-connection message header is the connID of the last disconnected connection
-	if err := cs.reConn(*cmdCtx.Ctx, reConnMsg.Head.ConnID, cmdCtx.ConnID); err != nil {
+	if err := cs.reConn(*cmdCtx.Ctx, cmdCtx.Endpoint, reConnMsg.Head.ConnID, cmdCtx.ConnID); err != nil {
 		code, msg = 1, "reconn failed"
 		panic(err)
 	}
@@ -132,12 +190,88 @@ func upMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 		fmt.Printf("[ERROR] upMsgHandler:err=%s\n", err.Error())
 		return
 	}
-	if cs.compareAndIncrClientID(*cmdCtx.Ctx, cmdCtx.ConnID, upMsg.Head.ClientID) {
-		// call downstream business layer rpc, only when the rpc reply is successful can max_clientID be updated
-		sendACKMsg(message.CmdType_UP, cmdCtx.ConnID, upMsg.Head.ClientID, 0, "ok")
-		// TODO: here should call business layer code
-		pushMsg(*cmdCtx.Ctx, cmdCtx.ConnID, cs.msgID, 0, upMsg.UPMsgBody)
+	ctx := *cmdCtx.Ctx
+	clientID := upMsg.Head.ClientID
+	status, value, err := cs.acceptClientID(ctx, cmdCtx.ConnID, clientID)
+	if err != nil {
+		fmt.Printf("[ERROR] acceptClientID:err=%s\n", err.Error())
+		return
+	}
+	switch status {
+	case clientIDDuplicate:
+		// the ack was lost, replay it so that the client stops resending
+		sendUpACK(cmdCtx.ConnID, clientID, value)
+		return
+	case clientIDInProgress:
+		// the first copy has not been acked yet, the client keeps waiting for its ack
+		sendACK(&message.ACKMsg{
+			Code:     uint32(message.ACKCode_InProgress),
+			Msg:      "in progress",
+			Type:     message.CmdType_UP,
+			ConnID:   cmdCtx.ConnID,
+			ClientID: clientID,
+		})
+		return
+	case clientIDOutOfOrder:
+		sendACK(&message.ACKMsg{
+			Code:             uint32(message.ACKCode_OutOfOrder),
+			Msg:              "client id out of order",
+			Type:             message.CmdType_UP,
+			ConnID:           cmdCtx.ConnID,
+			ClientID:         clientID,
+			ExpectedClientID: value,
+		})
+		return
+	}
+	// TODO: here should call business layer code
+	var msgID uint64
+	switch {
+	case upMsg.Head.GroupID != 0:
+		msgID, err = cs.deliverToGroup(ctx, cmdCtx.ConnID, upMsg.Head.GroupID, upMsg.UPMsgBody)
+	case upMsg.Head.ToUserID == 0:
+		// echo back to the sender, in the direct session of the user with itself
+		var uid uint64
+		if state, ok := cs.loadConnIDState(cmdCtx.ConnID); ok {
+			uid = state.uid
+		}
+		if msgID, err = cs.nextMsgID(ctx, uid, uid); err == nil {
+			pushMsg(ctx, cmdCtx.ConnID, &message.PushMsg{MsgID: msgID, Content: upMsg.UPMsgBody})
+		}
+	default:
+		msgID, err = cs.deliverToUser(ctx, cmdCtx.ConnID, upMsg.Head.ToUserID, upMsg.UPMsgBody)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] upMsgHandler deliver connID=%d, clientID=%d:err=%s\n", cmdCtx.ConnID, clientID, err.Error())
+		nackUpMsg(ctx, cmdCtx.ConnID, clientID, err)
+		return
+	}
+	if err = cs.recordClientID(ctx, cmdCtx.ConnID, clientID, msgID); err != nil {
+		fmt.Printf("[ERROR] recordClientID:err=%s\n", err.Error())
+	}
+	sendUpACK(cmdCtx.ConnID, clientID, msgID)
+}
+
+// a message which is not allowed uses up its ClientID and is rejected, the client must not resend it.
+// Otherwise the ClientID is released and the client resends the message from it.
+func nackUpMsg(ctx context.Context, connID, clientID uint64, err error) {
+	ackMsg := &message.ACKMsg{
+		Code:     uint32(message.ACKCode_Rejected),
+		Msg:      err.Error(),
+		Type:     message.CmdType_UP,
+		ConnID:   connID,
+		ClientID: clientID,
+	}
+	if errors.Is(err, errNotGroupMember) {
+		if err = cs.recordClientID(ctx, connID, clientID, 0); err != nil {
+			fmt.Printf("[ERROR] recordClientID:err=%s\n", err.Error())
+		}
+	} else {
+		if _, err = cs.releaseClientID(ctx, connID, clientID); err != nil {
+			fmt.Printf("[ERROR] releaseClientID:err=%s\n", err.Error())
+		}
+		ackMsg.Code, ackMsg.ExpectedClientID = uint32(message.ACKCode_Failed), clientID
 	}
+	sendACK(ackMsg)
 }
 
 // handle down-stream message ack reply
@@ -148,16 +282,138 @@ func ackMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
 		fmt.Printf("[ERROR] ackMsgHandler:err=%s\n", err.Error())
 		return
 	}
-	cs.ackLastMsg(*cmdCtx.Ctx, ackMsg.ConnID, ackMsg.SessionID, ackMsg.MsgID)
+	cs.ackLastMsg(*cmdCtx.Ctx, ackMsg.ConnID, ackMsgKey(ackMsg))
 }
 
-// called by business layer, handle down-stream message
-func pushMsg(ctx context.Context, connID, sessionID, msgID uint64, data []byte) {
-	// TODO: first push message here
-	pushMsg := &message.PushMsg{
-		Content: data,
-		MsgID:   cs.msgID,
+// handle presence subscribe and unsubscribe
+func presenceSubMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
+	subMsg := &message.PresenceSubMsg{}
+	err := proto.Unmarshal(msgCmd.Payload, subMsg)
+	if err != nil {
+		fmt.Printf("[ERROR] presenceSubMsgHandler:err=%s\n", err.Error())
+		return
 	}
+	if subMsg.Unsubscribe {
+		if err = cs.unsubscribePresence(*cmdCtx.Ctx, cmdCtx.ConnID, subMsg.UserIDs); err != nil {
+			fmt.Printf("[ERROR] presenceSubMsgHandler:err=%s\n", err.Error())
+		}
+		return
+	}
+	if err = cs.subscribePresence(*cmdCtx.Ctx, cmdCtx.ConnID, subMsg.UserIDs); err != nil {
+		fmt.Printf("[ERROR] presenceSubMsgHandler:err=%s\n", err.Error())
+		return
+	}
+	// push the current presence of the subscribed users as the initial state
+	ups, err := cs.queryPresence(*cmdCtx.Ctx, subMsg.UserIDs)
+	if err != nil {
+		fmt.Printf("[ERROR] presenceSubMsgHandler:err=%s\n", err.Error())
+		return
+	}
+	for _, up := range ups {
+		for _, pm := range presenceSnapshot(up) {
+			data, err := proto.Marshal(pm)
+			if err != nil {
+				fmt.Printf("[ERROR] Marshal:err=%s\n", err.Error())
+				continue
+			}
+			sendMsg(cmdCtx.ConnID, message.CmdType_Presence, data)
+		}
+	}
+}
+
+// handle group management and timeline sync
+func groupMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
+	groupMsg := &message.GroupMsg{}
+	err := proto.Unmarshal(msgCmd.Payload, groupMsg)
+	if err != nil {
+		fmt.Printf("[ERROR] groupMsgHandler:err=%s\n", err.Error())
+		return
+	}
+	ctx := *cmdCtx.Ctx
+	var uid uint64
+	if state, ok := cs.loadConnIDState(cmdCtx.ConnID); ok {
+		uid = state.uid
+	}
+	reply := &message.GroupMsg{Op: groupMsg.Op, GroupID: groupMsg.GroupID, Msg: "ok"}
+	switch groupMsg.Op {
+	case message.GroupOp_Create:
+		reply.GroupID, err = cs.createGroup(ctx, uid, groupMsg.UserIDs)
+	case message.GroupOp_Join:
+		if len(groupMsg.UserIDs) == 0 {
+			groupMsg.UserIDs = []uint64{uid}
+		}
+		err = cs.joinGroup(ctx, uid, groupMsg.GroupID, groupMsg.UserIDs)
+	case message.GroupOp_Leave:
+		if len(groupMsg.UserIDs) == 0 {
+			groupMsg.UserIDs = []uint64{uid}
+		}
+		err = cs.leaveGroup(ctx, uid, groupMsg.GroupID, groupMsg.UserIDs)
+	case message.GroupOp_Members:
+		reply.UserIDs, err = cs.queryGroupMembers(ctx, uid, groupMsg.GroupID)
+	case message.GroupOp_Sync:
+		err = cs.syncGroupMsg(ctx, cmdCtx.ConnID, groupMsg.GroupID, groupMsg.MsgID)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] groupMsgHandler op=%s:err=%s\n", groupMsg.Op, err.Error())
+		reply.Code, reply.Msg = 1, err.Error()
+	}
+	data, err := proto.Marshal(reply)
+	if err != nil {
+		fmt.Printf("[ERROR] Marshal:err=%s\n", err.Error())
+		return
+	}
+	sendMsg(cmdCtx.ConnID, message.CmdType_Group, data)
+}
+
+// handle delivery and read receipts
+func receiptMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
+	receipt := &message.ReceiptMsg{}
+	err := proto.Unmarshal(msgCmd.Payload, receipt)
+	if err != nil {
+		fmt.Printf("[ERROR] receiptMsgHandler:err=%s\n", err.Error())
+		return
+	}
+	if err = cs.deliverReceipt(*cmdCtx.Ctx, cmdCtx.ConnID, msgCmd.Type, receipt); err != nil {
+		fmt.Printf("[ERROR] receiptMsgHandler:err=%s\n", err.Error())
+	}
+}
+
+// handle ephemeral signal, no ack is replied and nothing is retransmitted
+func signalMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
+	signal := &message.SignalMsg{}
+	err := proto.Unmarshal(msgCmd.Payload, signal)
+	if err != nil {
+		fmt.Printf("[ERROR] signalMsgHandler:err=%s\n", err.Error())
+		return
+	}
+	if err = cs.deliverSignal(*cmdCtx.Ctx, cmdCtx.ConnID, signal); err != nil {
+		fmt.Printf("[ERROR] signalMsgHandler:err=%s\n", err.Error())
+	}
+}
+
+// handle recall and edit of a sent message
+func modifyMsgHandler(cmdCtx *service.CmdContext, msgCmd *message.MsgCmd) {
+	modifyMsg := &message.ModifyMsg{}
+	err := proto.Unmarshal(msgCmd.Payload, modifyMsg)
+	if err != nil {
+		fmt.Printf("[ERROR] modifyMsgHandler:err=%s\n", err.Error())
+		return
+	}
+	reply := &message.ModifyMsg{Op: modifyMsg.Op, SessionID: modifyMsg.SessionID, MsgID: modifyMsg.MsgID, Msg: "ok"}
+	if err = cs.modifyMsg(*cmdCtx.Ctx, cmdCtx.ConnID, modifyMsg); err != nil {
+		fmt.Printf("[ERROR] modifyMsgHandler:err=%s\n", err.Error())
+		reply.Code, reply.Msg = 1, err.Error()
+	}
+	data, err := proto.Marshal(reply)
+	if err != nil {
+		fmt.Printf("[ERROR] Marshal:err=%s\n", err.Error())
+		return
+	}
+	sendMsg(cmdCtx.ConnID, message.CmdType_Modify, data)
+}
+
+// called by business layer, handle down-stream message
+func pushMsg(ctx context.Context, connID uint64, pushMsg *message.PushMsg) {
 	if data, err := proto.Marshal(pushMsg); err != nil {
 		fmt.Printf("[ERROR] Marshal:err=%s\n", err.Error())
 	} else {
@@ -165,7 +421,8 @@ func pushMsg(ctx context.Context, connID, sessionID, msgID uint64, data []byte)
 		sendMsg(connID, message.CmdType_Push, data)
 		err = cs.appendLastMsg(ctx, connID, pushMsg)
 		if err != nil {
-			panic(err)
+			// the connection may be owned by another state server, which tracks its own ack
+			fmt.Printf("[ERROR] appendLastMsg connID=%d:err=%s\n", connID, err.Error())
 		}
 	}
 }
@@ -178,13 +435,36 @@ func sendACKMsg(ackType message.CmdType, connID, clientID uint64, code uint32, m
 	ackMsg.ConnID = connID
 	ackMsg.Type = ackType
 	ackMsg.ClientID = clientID
+	sendACK(ackMsg)
+}
+
+// ack an accepted up-stream message with the msgID assigned by the server
+func sendUpACK(connID, clientID, msgID uint64) {
+	sendACK(&message.ACKMsg{
+		Code:     uint32(message.ACKCode_OK),
+		Msg:      "ok",
+		Type:     message.CmdType_UP,
+		ConnID:   connID,
+		ClientID: clientID,
+		MsgID:    msgID,
+	})
+}
+
+func sendACK(ackMsg *message.ACKMsg) {
 	downLoad, err := proto.Marshal(ackMsg)
 	if err != nil {
 		fmt.Println("[ERROR] sendACKMsg", err)
 	}
-	sendMsg(connID, message.CmdType_ACK, downLoad)
+	sendMsg(ackMsg.ConnID, message.CmdType_ACK, downLoad)
 }
 
+// the rpcs to the gateway, replaced in tests
+var (
+	gatewayPush      = client.Push
+	gatewayBatchPush = client.BatchPush
+	gatewayDelConn   = client.DelConn
+)
+
 // send msg
 func sendMsg(connID uint64, ty message.CmdType, downLoad []byte) {
 	mc := &message.MsgCmd{}
@@ -195,24 +475,40 @@ func sendMsg(connID uint64, ty message.CmdType, downLoad []byte) {
 	if err != nil {
 		fmt.Println("[ERROR] sendMsg", ty, err)
 	}
-	client.Push(&ctx, connID, data)
+	// a push shed by a saturated gateway is recovered by the retransmission of the last msg
+	if err = gatewayPush(&ctx, connID, data); err != nil {
+		fmt.Printf("[ERROR] Push connID=%d:err=%s\n", connID, err.Error())
+	}
 }
 
 // re-send push msg
 func rePush(connID uint64) {
-	pushMsg, err := cs.getLastMsg(context.Background(), connID)
+	ctx := context.Background()
+	state, ok := cs.loadConnIDState(connID)
+	if !ok {
+		return
+	}
+	pushMsg, err := cs.getLastMsg(ctx, connID)
 	if err != nil {
 		panic(err)
 	}
 	if pushMsg == nil {
 		return
 	}
+	// stop retrying once the attempts or the deadline are exhausted
+	if state.retransmitExhausted(cs.retransmit) {
+		retransmitCounter.WithLabelValues("exhausted").Inc()
+		fmt.Printf("[INFO] rePush exhausted connID=%d, msgID=%d\n", connID, pushMsg.MsgID)
+		if err = cs.exhaustLastMsg(ctx, state, pushMsg); err != nil {
+			fmt.Printf("[ERROR] exhaustLastMsg:err=%s\n", err.Error())
+		}
+		return
+	}
 	msgData, err := proto.Marshal(pushMsg)
 	if err != nil {
 		panic(err)
 	}
 	sendMsg(connID, message.CmdType_Push, msgData)
-	if state, ok := cs.loadConnIDState(connID); ok {
-		state.reSetMsgTimer(connID, pushMsg.SessionID, pushMsg.MsgID)
-	}
+	retransmitCounter.WithLabelValues("retry").Inc()
+	state.reSetMsgTimer(connID, pushMsgKey(pushMsg))
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"context"
+
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"google.golang.org/protobuf/proto"
+)
+
+// forward an ephemeral signal to the current connections of the target.
+// Signals skip the client id check and the last msg, a signal that cannot be delivered right now is dropped.
+func (cs *cacheState) deliverSignal(ctx context.Context, connID uint64, signal *message.SignalMsg) error {
+	state, ok := cs.loadConnIDState(connID)
+	if !ok {
+		return nil
+	}
+	if state.signalBucket.TakeAvailable(1) == 0 {
+		signalCounter.WithLabelValues("limited").Inc()
+		return nil
+	}
+	signal.FromUserID, signal.FromDeviceID = state.uid, state.did
+	payload, err := proto.Marshal(signal)
+	if err != nil {
+		return err
+	}
+	signalCounter.WithLabelValues("sent").Inc()
+	if signal.GroupID != 0 {
+		return cs.signalGroup(ctx, connID, signal.GroupID, state.uid, payload)
+	}
+	batches, err := cs.groupConnBatches(ctx, []uint64{signal.ToUserID}, connID)
+	if err != nil {
+		return err
+	}
+	for endpoint, connIDs := range batches {
+		batchSendMsg(ctx, endpoint, connIDs, message.CmdType_Signal, payload)
+	}
+	return nil
+}
+
+func (cs *cacheState) signalGroup(ctx context.Context, connID, gid, uid uint64, payload []byte) error {
+	if err := cs.checkGroupMember(ctx, gid, uid); err != nil {
+		return err
+	}
+	uids, err := cs.groupMembers(ctx, gid)
+	if err != nil {
+		return err
+	}
+	batches, err := cs.groupConnBatches(ctx, uids, connID)
+	if err != nil {
+		return err
+	}
+	for endpoint, connIDs := range batches {
+		batchSendMsg(ctx, endpoint, connIDs, message.CmdType_Signal, payload)
+	}
+	return nil
+}
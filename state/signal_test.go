@@ -0,0 +1,103 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"github.com/feichai0017/GoChat/state/rpc/service"
+)
+
+func TestSignalBypassesReliability(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	testLogin(t, cs, 1, 11, 101)
+	testLogin(t, cs, 2, 21, 201)
+
+	payload, _ := proto.Marshal(&message.SignalMsg{ToUserID: 2})
+	signalMsgHandler(&service.CmdContext{Ctx: &ctx, ConnID: 101}, &message.MsgCmd{Type: message.CmdType_Signal, Payload: payload})
+
+	// the signal is forwarded once, it is neither acked nor kept as the last msg
+	if got, want := pushes.types(201), []message.CmdType{message.CmdType_Signal}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("receiver got %v, want %v", got, want)
+	}
+	if got := pushes.types(101); len(got) != 0 {
+		t.Fatalf("sender got %v, want nothing", got)
+	}
+	if last, err := cs.getLastMsg(ctx, 201); err != nil || last != nil {
+		t.Fatalf("last msg got %v, %v, want none", last, err)
+	}
+	// the ClientIDs of the sender are untouched, its first up-stream message is still expected
+	if code, _, err := cs.acceptClientID(ctx, 101, 0); err != nil || code != clientIDAccepted {
+		t.Fatalf("accept the first ClientID got %d, %v", code, err)
+	}
+}
+
+func TestSignalRateLimit(t *testing.T) {
+	viper.Set("state.signal.rate", 0.001)
+	viper.Set("state.signal.burst", 2)
+	t.Cleanup(func() {
+		viper.Set("state.signal.rate", 0)
+		viper.Set("state.signal.burst", 0)
+	})
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	testLogin(t, cs, 1, 11, 101)
+	testLogin(t, cs, 2, 21, 201)
+
+	// the signals over the burst are dropped silently
+	for i := 0; i < 5; i++ {
+		if err := cs.deliverSignal(ctx, 101, &message.SignalMsg{ToUserID: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(pushes.types(201)); got != 2 {
+		t.Fatalf("receiver got %d signals, want 2", got)
+	}
+}
+
+func TestSignalGroup(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestCacheState(t)
+	pushes := recordPushes(t)
+	testLoginAt(t, cs, "gw1", 1, 11, 101)
+	testLoginAt(t, cs, "gw2", 1, 12, 102)
+	testLoginAt(t, cs, "gw1", 2, 21, 201)
+	testLogin(t, cs, 3, 31, 301)
+	gid, err := cs.createGroup(ctx, 1, []uint64{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cs.deliverSignal(ctx, 101, &message.SignalMsg{GroupID: gid}); err != nil {
+		t.Fatal(err)
+	}
+	for connID, want := range map[uint64][]message.CmdType{
+		101: nil,
+		102: {message.CmdType_Signal},
+		201: {message.CmdType_Signal},
+		301: nil,
+	} {
+		if got := pushes.types(connID); !reflect.DeepEqual(got, want) {
+			t.Fatalf("connID %d got %v, want %v", connID, got, want)
+		}
+		if last, _ := cs.getLastMsg(ctx, connID); last != nil {
+			t.Fatalf("connID %d kept the signal as the last msg", connID)
+		}
+	}
+
+	// a user outside the group can not signal it
+	if err = cs.deliverSignal(ctx, 301, &message.SignalMsg{GroupID: gid}); !errors.Is(err, errNotGroupMember) {
+		t.Fatalf("signal from a non-member got %v, want %v", err, errNotGroupMember)
+	}
+	if got := pushes.types(201); len(got) != 1 {
+		t.Fatalf("connID 201 got %v after the rejected signal", got)
+	}
+}
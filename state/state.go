@@ -8,19 +8,44 @@ import (
 
 	"github.com/feichai0017/GoChat/common/cache"
 	"github.com/feichai0017/GoChat/common/config"
+	"github.com/feichai0017/GoChat/common/idl/message"
 	"github.com/feichai0017/GoChat/common/timingwheel"
-	"github.com/feichai0017/GoChat/state/rpc/client"
-	"github.com/redis/go-redis/v9"
+	"github.com/juju/ratelimit"
 )
 
+// a down-stream message is told by its session and its msgID. The msgIDs of a direct session are
+// allocated per pair of users, so a direct message is told by its sender and its receiver as well.
+type msgKey struct {
+	sessionID, fromUID, toUID, msgID uint64
+}
+
+func pushMsgKey(pm *message.PushMsg) msgKey {
+	return msgKey{sessionID: pm.SessionID, fromUID: pm.FromUserID, toUID: pm.ToUserID, msgID: pm.MsgID}
+}
+
+// the acked message, the ack echoes the identity of the push
+func ackMsgKey(ackMsg *message.ACKMsg) msgKey {
+	return msgKey{sessionID: ackMsg.SessionID, fromUID: ackMsg.FromUserID, toUID: ackMsg.ToUserID, msgID: ackMsg.MsgID}
+}
+
+func (k msgKey) String() string {
+	return fmt.Sprintf("%d_%d_%d_%d", k.sessionID, k.fromUID, k.toUID, k.msgID)
+}
+
 type connState struct {
 	sync.RWMutex
 	heartTimer   *timingwheel.Timer
 	reConnTimer  *timingwheel.Timer
 	msgTimer     *timingwheel.Timer
 	msgTimerLock string
+	msgAttempts  int       // retransmission attempts of the last msg
+	msgPushedAt  time.Time // first push time of the last msg
 	connID       uint64
 	did          uint64
+	uid          uint64
+	lastActive   time.Time     // last time the presence active time was refreshed
+	heartbeat    time.Duration // negotiated heartbeat interval
+	signalBucket *ratelimit.Bucket
 }
 
 func (c *connState) close(ctx context.Context) error {
@@ -28,66 +53,115 @@ func (c *connState) close(ctx context.Context) error {
 	defer c.Unlock()
 
 	// 1. Stop local Go timers.
-	if c.heartTimer != nil {
-		c.heartTimer.Stop()
-	}
-	if c.reConnTimer != nil {
-		c.reConnTimer.Stop()
-	}
-	if c.msgTimer != nil {
-		c.msgTimer.Stop()
-	}
+	c.stopTimersLocked()
 	// 2. Atomically clean up all distributed states using a single Lua script.
 	// This replaces multiple individual Redis calls.
-	slotSize := uint64(len(config.GetStateServerLoginSlotRange()))
-	_, err := cache.RunLua(ctx, cache.LuaCleanupConnection, nil, c.connID, c.did, slotSize)
+	meta := cs.loginSlotMarshal(c.did, c.connID, c.uid, c.heartbeat)
+	keys := []string{cs.getConnKeysKey(c.connID), cs.getLoginSlotKey(c.connID)}
+	err := cs.store.CleanupConnection(ctx, keys[0], keys[1], meta)
 	
-	if err != nil && err != redis.Nil {
+	if err != nil {
 		// Log a critical error, as this could lead to residual state in Redis.
 		// logger.ErrorCtx(ctx, "Failed to cleanup connection state atomically via Lua", "connID", c.connID, "err", err)
 		return err
 	}
-
-	err = client.DelConn(&ctx, c.connID, nil)
-	if err != nil {
+	// the router record lives in the slot of the device, so it can not join the script in cluster mode
+	if err = cs.router.DelRecord(ctx, c.did); err != nil {
 		return err
 	}
 
+	// the gateway may have closed the connection already
+	if err = gatewayDelConn(&ctx, c.connID, nil); err != nil {
+		fmt.Printf("[ERROR] DelConn connID=%d:err=%s\n", c.connID, err.Error())
+	}
+
 	cs.deleteConnIDState(ctx, c.connID)
 	return nil
 }
 
+// stop the local timers only, used when the slot of the connection is handed over to another state server
+func (c *connState) stopTimers() {
+	c.Lock()
+	defer c.Unlock()
+	c.stopTimersLocked()
+}
+
+func (c *connState) stopTimersLocked() {
+	if c.heartTimer != nil {
+		c.heartTimer.Stop()
+	}
+	if c.reConnTimer != nil {
+		c.reConnTimer.Stop()
+	}
+	if c.msgTimer != nil {
+		c.msgTimer.Stop()
+	}
+}
+
 func (c *connState) appendMsg(ctx context.Context, key, msgTimerLock string, msgData []byte) {
 	c.Lock()
 	defer c.Unlock()
 	c.msgTimerLock = msgTimerLock
+	c.msgAttempts = 0
+	c.msgPushedAt = time.Now()
 	if c.msgTimer != nil {
 		c.msgTimer.Stop()
 		c.msgTimer = nil
 	}
 	// create new timer
-	t := AfterFunc(100*time.Millisecond, func() {
+	t := AfterFunc(cs.retransmit.backoff(0), func() {
 		rePush(c.connID)
 	})
 	c.msgTimer = t
-	err := cache.SetBytes(ctx, key, msgData, cache.TTL7D)
+	err := cs.store.SetBytesIndexed(ctx, key, msgData, cache.TTL7D, cs.getConnKeysKey(c.connID))
 	if err != nil {
 		panic(key)
 	}
 }
 
-func (c *connState) reSetMsgTimer(connID, sessionID, msgID uint64) {
+func (c *connState) reSetMsgTimer(connID uint64, key msgKey) {
 	c.Lock()
 	defer c.Unlock()
 	if c.msgTimer != nil {
 		c.msgTimer.Stop()
 	}
-	c.msgTimerLock = fmt.Sprintf("%d_%d", sessionID, msgID)
-	c.msgTimer = AfterFunc(100*time.Millisecond, func() {
+	msgTimerLock := key.String()
+	if c.msgTimerLock == msgTimerLock {
+		c.msgAttempts++
+	} else {
+		c.msgTimerLock = msgTimerLock
+		c.msgAttempts = 0
+		c.msgPushedAt = time.Now()
+	}
+	c.msgTimer = AfterFunc(cs.retransmit.backoff(c.msgAttempts), func() {
 		rePush(connID)
 	})
 }
 
+// whether the last msg should not be retransmitted anymore
+func (c *connState) retransmitExhausted(policy *retransmitPolicy) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return policy.exhausted(c.msgAttempts, c.msgPushedAt)
+}
+
+// whether the message is the last msg waiting for the ack
+func (c *connState) inFlight(key msgKey) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.msgTimerLock == key.String()
+}
+
+func (c *connState) stopMsgTimer() {
+	c.Lock()
+	defer c.Unlock()
+	if c.msgTimer != nil {
+		c.msgTimer.Stop()
+		c.msgTimer = nil
+	}
+	c.msgTimerLock = ""
+}
+
 // used to restore when restarting
 func (c *connState) loadMsgTimer(ctx context.Context) {
 	// create timer
@@ -99,7 +173,7 @@ func (c *connState) loadMsgTimer(ctx context.Context) {
 	if data == nil {
 		return
 	}
-	c.reSetMsgTimer(c.connID, data.SessionID, data.MsgID)
+	c.reSetMsgTimer(c.connID, pushMsgKey(data))
 }
 
 func (c *connState) reSetHeartTimer() {
@@ -108,11 +182,24 @@ func (c *connState) reSetHeartTimer() {
 	if c.heartTimer != nil {
 		c.heartTimer.Stop()
 	}
-	c.heartTimer = AfterFunc(5*time.Second, func() {
+	timeout := c.heartbeat * time.Duration(config.GetStateHeartbeatTimeoutFactor())
+	c.heartTimer = AfterFunc(timeout, func() {
 		c.reSetReConnTimer()
 	})
 }
 
+// report whether the active time should be refreshed, and record the refresh
+func (c *connState) touchActive(interval time.Duration) bool {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastActive) < interval {
+		return false
+	}
+	c.lastActive = now
+	return true
+}
+
 func (c *connState) reSetReConnTimer() {
 	c.Lock()
 	defer c.Unlock()
@@ -121,24 +208,22 @@ func (c *connState) reSetReConnTimer() {
 		c.reConnTimer.Stop()
 	}
 	
-	// initialize re-connection timer
-	c.reConnTimer = AfterFunc(10*time.Second, func() {
+	// initialize re-connection timer, the grace period scales with the heartbeat interval
+	grace := c.heartbeat * time.Duration(config.GetStateReConnTimeoutFactor())
+	c.reConnTimer = AfterFunc(grace, func() {
 		ctx := context.TODO()
 		// overall connID state logout
 		cs.connLogOut(ctx, c.connID)
 	})
 }
 
-func (c *connState) ackLastMsg(ctx context.Context, sessionID, msgID uint64) bool {
+func (c *connState) ackLastMsg(ctx context.Context, key msgKey) bool {
 	c.Lock()
 	defer c.Unlock()
-	msgTimerLock := fmt.Sprintf("%d_%d", sessionID, msgID)
-	if c.msgTimerLock != msgTimerLock {
+	if c.msgTimerLock != key.String() {
 		return false
 	}
-	slot := cs.getConnStateSlot(c.connID)
-	key := fmt.Sprintf(cache.LastMsgKey, slot, c.connID)
-	if err := cache.Del(ctx, key); err != nil {
+	if err := cs.store.Del(ctx, cs.connKey(cache.LastMsgKey, c.connID)); err != nil {
 		return false
 	}
 	if c.msgTimer != nil {
@@ -0,0 +1,108 @@
+package state
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/feichai0017/GoChat/state/rpc/service"
+)
+
+// cmdWorkers shards the commands by connID, the commands of a connection are always handled
+// by the same worker so they keep their order, while independent connections proceed in parallel.
+// A command changing the state of connections on other shards holds the locks of those shards as well.
+type cmdWorkers struct {
+	shards []chan *service.CmdContext
+	locks  []sync.Mutex
+	labels []string
+	handle func(cmdCtx *service.CmdContext)
+	// the other connections changed by the command, nil means only the connection of the command
+	conns func(cmdCtx *service.CmdContext) []uint64
+	wg    sync.WaitGroup
+}
+
+func newCmdWorkers(num, size int, handle func(cmdCtx *service.CmdContext), conns func(cmdCtx *service.CmdContext) []uint64) *cmdWorkers {
+	w := &cmdWorkers{
+		shards: make([]chan *service.CmdContext, num),
+		locks:  make([]sync.Mutex, num),
+		labels: make([]string, num),
+		handle: handle,
+		conns:  conns,
+	}
+	for i := range w.shards {
+		w.shards[i] = make(chan *service.CmdContext, size)
+		w.labels[i] = strconv.Itoa(i)
+	}
+	return w
+}
+
+func (w *cmdWorkers) start() {
+	for i := range w.shards {
+		w.wg.Add(1)
+		go w.run(i)
+	}
+}
+
+// stop waits for the queued commands to be handled
+func (w *cmdWorkers) stop() {
+	for _, shard := range w.shards {
+		close(shard)
+	}
+	w.wg.Wait()
+}
+
+func (w *cmdWorkers) shard(connID uint64) int {
+	return int(connID % uint64(len(w.shards)))
+}
+
+// dispatch blocks when the shard of the connection is full
+func (w *cmdWorkers) dispatch(cmdCtx *service.CmdContext) {
+	i := w.shard(cmdCtx.ConnID)
+	cmdBacklogGauge.WithLabelValues(w.labels[i]).Inc()
+	w.shards[i] <- cmdCtx
+}
+
+// tryDispatch rejects the command when the shard of the connection is full,
+// so a slow shard pushes back on its own connections only
+func (w *cmdWorkers) tryDispatch(cmdCtx *service.CmdContext) bool {
+	i := w.shard(cmdCtx.ConnID)
+	cmdBacklogGauge.WithLabelValues(w.labels[i]).Inc()
+	select {
+	case w.shards[i] <- cmdCtx:
+		return true
+	default:
+		cmdBacklogGauge.WithLabelValues(w.labels[i]).Dec()
+		cmdRejectedCounter.WithLabelValues(w.labels[i]).Inc()
+		return false
+	}
+}
+
+func (w *cmdWorkers) run(i int) {
+	defer w.wg.Done()
+	for cmdCtx := range w.shards[i] {
+		cmdBacklogGauge.WithLabelValues(w.labels[i]).Dec()
+		held := w.lockShards(i, cmdCtx)
+		w.handle(cmdCtx)
+		for _, j := range held {
+			w.locks[j].Unlock()
+		}
+	}
+}
+
+// lock the shards of every connection changed by the command, in ascending order so that two commands
+// spanning the same shards do not deadlock
+func (w *cmdWorkers) lockShards(i int, cmdCtx *service.CmdContext) []int {
+	held := []int{i}
+	if w.conns != nil {
+		for _, connID := range w.conns(cmdCtx) {
+			if j := w.shard(connID); !slices.Contains(held, j) {
+				held = append(held, j)
+			}
+		}
+	}
+	slices.Sort(held)
+	for _, j := range held {
+		w.locks[j].Lock()
+	}
+	return held
+}
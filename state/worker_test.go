@@ -0,0 +1,161 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/feichai0017/GoChat/common/idl/message"
+	"github.com/feichai0017/GoChat/state/rpc/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCmdWorkersOrder(t *testing.T) {
+	const conns, cmds = 8, 100
+	var (
+		lock sync.Mutex
+		seen = make(map[uint64][]int32)
+	)
+	w := newCmdWorkers(4, 16, func(cmdCtx *service.CmdContext) {
+		lock.Lock()
+		seen[cmdCtx.ConnID] = append(seen[cmdCtx.ConnID], cmdCtx.Cmd)
+		lock.Unlock()
+	}, nil)
+	w.start()
+	for i := int32(0); i < cmds; i++ {
+		for connID := uint64(0); connID < conns; connID++ {
+			w.dispatch(&service.CmdContext{ConnID: connID, Cmd: i})
+		}
+	}
+	w.stop()
+	for connID := uint64(0); connID < conns; connID++ {
+		if len(seen[connID]) != cmds {
+			t.Fatalf("connID %d handled %d cmds, want %d", connID, len(seen[connID]), cmds)
+		}
+		for i, cmd := range seen[connID] {
+			if cmd != int32(i) {
+				t.Fatalf("connID %d handled cmd %d at %d", connID, cmd, i)
+			}
+		}
+	}
+}
+
+func TestCmdWorkersTryDispatch(t *testing.T) {
+	block := make(chan struct{})
+	w := newCmdWorkers(2, 1, func(cmdCtx *service.CmdContext) {
+		<-block
+	}, nil)
+	w.start()
+	// the worker of shard 0 is blocked by the first command, the second one fills the queue
+	if !w.tryDispatch(&service.CmdContext{ConnID: 0}) {
+		t.Fatal("first cmd rejected")
+	}
+	for !w.tryDispatch(&service.CmdContext{ConnID: 2}) {
+	}
+	if w.tryDispatch(&service.CmdContext{ConnID: 4}) {
+		t.Fatal("cmd admitted into a saturated shard")
+	}
+	// the other shard is not affected
+	if !w.tryDispatch(&service.CmdContext{ConnID: 1}) {
+		t.Fatal("cmd rejected by an idle shard")
+	}
+	close(block)
+	w.stop()
+}
+
+func TestCmdWorkersCrossShard(t *testing.T) {
+	const oldConnID, newConnID = 2, 1
+	reConn, _ := proto.Marshal(&message.ReConnMsg{Head: &message.ReConnMsgHead{ConnID: oldConnID}})
+	reConnCmd, _ := proto.Marshal(&message.MsgCmd{Type: message.CmdType_ReConn, Payload: reConn})
+	ack, _ := proto.Marshal(&message.ACKMsg{ConnID: oldConnID})
+	ackCmd, _ := proto.Marshal(&message.MsgCmd{Type: message.CmdType_ACK, Payload: ack})
+	heartbeatCmd, _ := proto.Marshal(&message.MsgCmd{Type: message.CmdType_Heartbeat})
+
+	// the connections changed by each command are never handled concurrently
+	var (
+		lock    sync.Mutex
+		active  = make(map[uint64]int)
+		overlap bool
+	)
+	w := newCmdWorkers(2, 64, func(cmdCtx *service.CmdContext) {
+		connIDs := append([]uint64{cmdCtx.ConnID}, cmdConnIDs(cmdCtx)...)
+		lock.Lock()
+		for _, connID := range connIDs {
+			if active[connID]++; active[connID] > 1 {
+				overlap = true
+			}
+		}
+		lock.Unlock()
+		time.Sleep(100 * time.Microsecond)
+		lock.Lock()
+		for _, connID := range connIDs {
+			active[connID]--
+		}
+		lock.Unlock()
+	}, cmdConnIDs)
+	if w.shard(oldConnID) == w.shard(newConnID) {
+		t.Fatal("old and new connIDs on the same shard")
+	}
+	w.start()
+	for i := 0; i < 50; i++ {
+		w.dispatch(&service.CmdContext{Cmd: service.SendMsgCmd, ConnID: oldConnID, Payload: heartbeatCmd})
+		w.dispatch(&service.CmdContext{Cmd: service.SendMsgCmd, ConnID: newConnID, Payload: reConnCmd})
+		w.dispatch(&service.CmdContext{Cmd: service.SendMsgCmd, ConnID: newConnID, Payload: ackCmd})
+	}
+	w.stop()
+	if overlap {
+		t.Fatal("a connection was changed by two workers at once")
+	}
+}
+
+// every command simulates a blocking redis round-trip, one worker is the previous single goroutine handler
+func BenchmarkCmdWorkers(b *testing.B) {
+	const roundTrip = 100 * time.Microsecond
+	for _, num := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("workers=%d", num), func(b *testing.B) {
+			var wg sync.WaitGroup
+			w := newCmdWorkers(num, 2048, func(cmdCtx *service.CmdContext) {
+				time.Sleep(roundTrip)
+				wg.Done()
+			}, nil)
+			w.start()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wg.Add(1)
+				w.dispatch(&service.CmdContext{ConnID: uint64(i % 1024)})
+			}
+			wg.Wait()
+			b.StopTimer()
+			w.stop()
+		})
+	}
+}
+
+func TestAdmitOwnedSlot(t *testing.T) {
+	var dispatched []uint64
+	s := &service.Service{
+		Dispatch: func(cmdCtx *service.CmdContext) bool {
+			dispatched = append(dispatched, cmdCtx.ConnID)
+			return true
+		},
+		// the server holds the odd slots only
+		Owns: func(connID uint64) bool { return connID%2 == 1 },
+	}
+	if _, err := s.SendMsg(context.Background(), &service.StateRequest{ConnID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.SendMsg(context.Background(), &service.StateRequest{ConnID: 2})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("cmd of a slot not owned got %v", err)
+	}
+	if _, err = s.CancelConn(context.Background(), &service.StateRequest{ConnID: 4}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("cancel of a slot not owned got %v", err)
+	}
+	if len(dispatched) != 1 || dispatched[0] != 1 {
+		t.Fatalf("dispatched %v, want [1]", dispatched)
+	}
+}